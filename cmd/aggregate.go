@@ -1,15 +1,43 @@
 package cmd
 
 import (
+	"context"
+	"github.com/DrBushytop/amag/pkg/job"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 // aggregateCmd represents the aggregate command
 var aggregateCmd = &cobra.Command{
 	Use:   "aggregate",
-	Short: "aggregate values from kql queries and save as custom metrics or logs",
+	Short: "aggregate values from kql queries and save as custom metrics, logs, Prometheus or OpenTelemetry metrics",
 }
 
 func init() {
 	rootCmd.AddCommand(aggregateCmd)
+}
+
+// runAggregateJob runs a job built from the flags of an aggregate command.
+// In a dry run, the payload is printed to the output of the command instead of being sent.
+func runAggregateJob(cmd *cobra.Command, j job.Job) error {
+	var opts []job.RunnerOption
+	dryRun := viper.GetBool(GetViperKey(cmd, KeyDryRun))
+	if dryRun {
+		opts = append(opts, job.WithDryRun(cmd.OutOrStdout()))
+	}
+
+	runner, err := newRunner(opts...)
+	if err != nil {
+		return err
+	}
+	defer closeRunner(runner)
+
+	if viper.GetBool(GetViperKey(cmd, KeyResetCheckpoint)) && !dryRun {
+		if err := runner.ResetCheckpoint(j); err != nil {
+			return err
+		}
+	}
+
+	_, err = runner.Run(context.Background(), j)
+	return err
 }
\ No newline at end of file
@@ -0,0 +1,200 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/DrBushytop/amag/pkg/job"
+	"github.com/DrBushytop/amag/pkg/kql"
+	"github.com/charmbracelet/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"time"
+)
+
+var backfillCmd = &cobra.Command{
+	Use:   "backfill",
+	Short: "Run a KQL query over consecutive windows of a historical range and save each result",
+	Long: `Run a specified KQL file against an Azure Log Analytics workspace repeatedly, over consecutive windows of the given range,
+and save each result with the timestamp of its window. This is useful for populating history for a newly added aggregation.
+
+Example usage:
+
+amag backfill --file ./queries/latency_p90.kql --metric LatencyP90 --workspaceid <workspace-id> --from 2024-09-01 --to 2024-10-01 --step 1h --sink log --datacollectionendpoint <data-collection-endpoint> --datacollectionstreamname <data-collection-stream-name> --datacollectionruleid <data-collection-rule-id>
+
+The metric name, workspace ID, sink type and scope resource ID can also be set in the front matter of the query file.
+Flags override the front matter.
+
+--from and --to accept RFC3339 timestamps, dates, or relative expressions like ago(7d). --step defaults to 1h.
+
+The sink is selected with --sink, and takes the same flags as the matching aggregate command:
+- log: The start of each window is used as OriginalTimeGenerated for result rows without a TimeGenerated column.
+- metric: The start of each window is used as the metric timestamp. Azure Monitor only accepts custom metrics up to 20 minutes
+  in the past, so older windows are skipped with a warning.
+- prometheus: The start of each window is used as the sample timestamp for result rows without a TimeGenerated column.
+  Prometheus rejects samples older than its TSDB head window, which covers only the last few hours, unless out-of-order
+  ingestion is enabled with out_of_order_time_window.
+- otlp: The start of each window is used as the data point timestamp for result rows without a TimeGenerated column.`,
+	RunE: RunBackfill,
+}
+
+func RunBackfill(cmd *cobra.Command, args []string) error {
+	metricName := viper.GetString(GetViperKey(cmd, KeyMetric))
+	j := job.Job{
+		Name:            metricName,
+		File:            viper.GetString(GetViperKey(cmd, KeyFile)),
+		WorkspaceID:     viper.GetString(GetViperKey(cmd, KeyWorkspaceID)),
+		SplitWorkspaces: viper.GetBool(GetViperKey(cmd, KeySplitWorkspaces)),
+		Sink: job.Sink{
+			Type:                     job.SinkType(viper.GetString(GetViperKey(cmd, KeySink))),
+			Metric:                   metricName,
+			ScopeResourceID:          viper.GetString(GetViperKey(cmd, KeyScopeResourceID)),
+			Location:                 viper.GetString(GetViperKey(cmd, KeyLocation)),
+			DataCollectionEndpoint:   viper.GetString(GetViperKey(cmd, KeyDataCollectionEndpoint)),
+			DataCollectionStreamName: viper.GetString(GetViperKey(cmd, KeyDataCollectionStreamName)),
+			DataCollectionRuleID:     viper.GetString(GetViperKey(cmd, KeyDataCollectionRuleId)),
+			Passthrough:              viper.GetBool(GetViperKey(cmd, KeyPassthrough)),
+			RemoteWriteURL:           viper.GetString(GetViperKey(cmd, KeyRemoteWriteURL)),
+			Username:                 viper.GetString(GetViperKey(cmd, KeyUsername)),
+			OTLPEndpoint:             viper.GetString(GetViperKey(cmd, KeyOTLPEndpoint)),
+			OTLPProtocol:             viper.GetString(GetViperKey(cmd, KeyOTLPProtocol)),
+		},
+	}
+	setSource(cmd, &j)
+	if err := setQueryMetadata(&j); err != nil {
+		return fmt.Errorf("%w: error reading metadata from file %s: %w", ErrInvalidInput, j.File, err)
+	}
+	// The sink type can come from the front matter of the query file
+	if j.Sink.Type == job.SinkPrometheus {
+		j.Sink.Password, j.Sink.BearerToken = remoteWriteSecrets()
+	}
+	if err := setParams(cmd, &j); err != nil {
+		return fmt.Errorf("%w: error reading query parameters: %w", ErrInvalidInput, err)
+	}
+	if err := j.Validate(); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidInput, err)
+	}
+	if err := j.ValidateTimeFilter(); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidInput, err)
+	}
+	if j.Sink.Type == job.SinkMetric {
+		if err := validateResourceId(j.Sink.ScopeResourceID); err != nil {
+			return fmt.Errorf("%w: error validating scopeResourceId: %w", ErrInvalidInput, err)
+		}
+	}
+
+	window, err := kql.ParseTimeWindow(
+		time.Now().UTC(),
+		"",
+		viper.GetString(GetViperKey(cmd, KeyFrom)),
+		viper.GetString(GetViperKey(cmd, KeyTo)),
+		"",
+	)
+	if err != nil {
+		return fmt.Errorf("%w: error resolving backfill range: %w", ErrInvalidInput, err)
+	}
+	step, err := kql.ParseDuration(viper.GetString(GetViperKey(cmd, KeyStep)))
+	if err != nil || step <= 0 {
+		return fmt.Errorf("%w: invalid step %q", ErrInvalidInput, viper.GetString(GetViperKey(cmd, KeyStep)))
+	}
+
+	cmd.SilenceUsage = true // Avoid printing usage on error generated by functions later
+
+	runner, err := newRunner()
+	if err != nil {
+		return fmt.Errorf("failed to create runner: %w", err)
+	}
+	defer closeRunner(runner)
+
+	res, err := runner.Backfill(context.Background(), j, window, step)
+	if err != nil {
+		return fmt.Errorf("failed to backfill, completed up to %s: %w", res.Window.End.Format(time.RFC3339), err)
+	}
+	log.Info("Backfill completed", "metricName", j.Sink.Metric, "range", res.Window, "windows", res.Windows, "rows", res.Rows)
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(backfillCmd)
+
+	err := bind(backfillCmd, KeyFile, "f", "", "Path to the KQL file to run")
+	if err != nil {
+		panic(err)
+	}
+	err = bindOptional(backfillCmd, KeyMetric, "m", "", "Name of the custom metric to save the result into. Required unless set in the query file")
+	if err != nil {
+		panic(err)
+	}
+	err = bindOptional(backfillCmd, KeyWorkspaceID, "w", "", "Workspace id (not the resource id) of the Log Analytics workspace to run the aggregate against, or a comma separated list of them. Required unless set in the query file or another source is queried")
+	if err != nil {
+		panic(err)
+	}
+	err = bindOptional(backfillCmd, KeySink, "", "", "Where to save the results, metric, log, prometheus or otlp. Required unless set in the query file")
+	if err != nil {
+		panic(err)
+	}
+	err = bind(backfillCmd, KeyFrom, "", "", "Start of the range to backfill. RFC3339 timestamp, date or relative expression like ago(7d)")
+	if err != nil {
+		panic(err)
+	}
+	err = bind(backfillCmd, KeyTo, "", "", "End of the range to backfill. RFC3339 timestamp, date or relative expression like ago(1h)")
+	if err != nil {
+		panic(err)
+	}
+	err = bindOptional(backfillCmd, KeyStep, "", "1h", "Length of each window in the range, e.g. 15m or 1d")
+	if err != nil {
+		panic(err)
+	}
+
+	err = bindOptional(backfillCmd, KeyScopeResourceID, "s", "", "Resource id of the scope to save the custom metric to. Required for the metric sink")
+	if err != nil {
+		panic(err)
+	}
+	err = bindOptional(backfillCmd, KeyLocation, "l", "", "Azure region of the scope resource. Resolved from the resource if not set")
+	if err != nil {
+		panic(err)
+	}
+	err = bindOptional(backfillCmd, KeyDataCollectionEndpoint, "e", "", "The data collection endpoint to send data to. Required for the log sink")
+	if err != nil {
+		panic(err)
+	}
+	err = bindOptional(backfillCmd, KeyDataCollectionStreamName, "", "", "The data collection stream name to send data to. Required for the log sink")
+	if err != nil {
+		panic(err)
+	}
+	err = bindOptional(backfillCmd, KeyDataCollectionRuleId, "r", "", "The data collection rule ID to use. Required for the log sink")
+	if err != nil {
+		panic(err)
+	}
+	err = bindOptional(backfillCmd, KeyRemoteWriteURL, "u", "", "URL of the Prometheus remote write endpoint. Required for the prometheus sink")
+	if err != nil {
+		panic(err)
+	}
+	err = bindOptional(backfillCmd, KeyUsername, "", "", "Username for basic auth to the remote write endpoint. The password is read from AMAG_REMOTE_WRITE_PASSWORD")
+	if err != nil {
+		panic(err)
+	}
+	err = bindOptional(backfillCmd, KeyOTLPEndpoint, "", "", "URL of the OTLP receiver for the otlp sink. Defaults to OTEL_EXPORTER_OTLP_ENDPOINT")
+	if err != nil {
+		panic(err)
+	}
+	err = bindOptional(backfillCmd, KeyOTLPProtocol, "", "", "Protocol of the otlp sink: http/protobuf (default) or grpc")
+	if err != nil {
+		panic(err)
+	}
+	err = bindParams(backfillCmd)
+	if err != nil {
+		panic(err)
+	}
+	err = bindWorkspaces(backfillCmd)
+	if err != nil {
+		panic(err)
+	}
+	err = bindSource(backfillCmd)
+	if err != nil {
+		panic(err)
+	}
+	err = bindBool(backfillCmd, KeyPassthrough, "Save all columns of the query result to the log table, instead of only Name and Value. The table must have matching columns")
+	if err != nil {
+		panic(err)
+	}
+}
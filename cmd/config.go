@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"errors"
+	"fmt"
 	"github.com/charmbracelet/log"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -18,8 +19,8 @@ var configCmd = &cobra.Command{
 var configSetCmd = &cobra.Command{
 	Use:   "set [key] [value]",
 	Short: "Set a default value for a configuration key",
-	Args:  cobra.ExactArgs(2),
-	Run: func(cmd *cobra.Command, args []string) {
+	Args:  invalidInputArgs(cobra.ExactArgs(2)),
+	RunE: func(cmd *cobra.Command, args []string) error {
 		key := args[0]
 		value := args[1]
 
@@ -34,42 +35,38 @@ var configSetCmd = &cobra.Command{
 				err = viper.SafeWriteConfig()
 			}
 			if err != nil {
-				log.Error("Error writing config file:", err)
-			} else {
-				log.Info("Configuration saved.")
+				return fmt.Errorf("error writing config file: %w", err)
 			}
-		} else {
-			log.Info("Configuration saved.")
 		}
+		log.Info("Configuration saved.")
+		return nil
 	},
 }
 
 var configLoadCmd = &cobra.Command{
 	Use:   "load [path]",
 	Short: "Load configuration from a file. Must be in yaml format.",
-	Args:  cobra.ExactArgs(1),
-	Run: func(cmd *cobra.Command, args []string) {
+	Args:  invalidInputArgs(cobra.ExactArgs(1)),
+	RunE: func(cmd *cobra.Command, args []string) error {
 		configPath := args[0]
 
 		confFile, err := os.Open(configPath)
 		if err != nil {
-			log.Error("Error opening config file", "err", err)
-			return
+			return fmt.Errorf("%w: error opening config file: %w", ErrInvalidInput, err)
 		}
 		defer confFile.Close()
 		err = viper.ReadConfig(confFile)
 		if err != nil {
-			log.Error("Error reading config file", "err", err)
-			return
+			return fmt.Errorf("%w: error reading config file: %w", ErrInvalidInput, err)
 		}
 
 		err = viper.WriteConfig()
 		if err != nil {
-			log.Error("Error writing config file", "err", err)
-			return
+			return fmt.Errorf("error writing config file: %w", err)
 		}
 
 		log.Infof("Configuration loaded from %s", configPath)
+		return nil
 	},
 }
 
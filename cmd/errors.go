@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"github.com/DrBushytop/amag/pkg/kql"
+	"github.com/spf13/cobra"
+)
+
+// ErrInvalidInput is returned when flags, config values or manifest contents are invalid.
+var ErrInvalidInput = errors.New("invalid input")
+
+// Exit codes of the amag process. Scripts and pipelines can branch on these to tell failures apart.
+const (
+	ExitOK             = 0
+	ExitError          = 1
+	ExitInvalidInput   = 2
+	ExitQueryFailed    = 3
+	ExitNoRows         = 4
+	ExitColumnMissing  = 5
+	ExitUploadRejected = 6
+	ExitAuthFailed     = 7
+)
+
+// exitCodes maps errors to exit codes, in priority order for errors that wrap several of them.
+var exitCodes = []struct {
+	err  error
+	code int
+}{
+	{ErrInvalidInput, ExitInvalidInput},
+	{kql.ErrAuthFailed, ExitAuthFailed},
+	{kql.ErrQueryFailed, ExitQueryFailed},
+	{kql.ErrColumnMissing, ExitColumnMissing},
+	{kql.ErrNoRows, ExitNoRows},
+	{kql.ErrUploadRejected, ExitUploadRejected},
+}
+
+// exitCode returns the exit code for the error returned by a command.
+func exitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+	for _, e := range exitCodes {
+		if errors.Is(err, e.err) {
+			return e.code
+		}
+	}
+	return ExitError
+}
+
+// invalidInputArgs wraps the errors of the positional argument validator with ErrInvalidInput, since cobra returns
+// plain errors for them.
+func invalidInputArgs(validate cobra.PositionalArgs) cobra.PositionalArgs {
+	return func(cmd *cobra.Command, args []string) error {
+		if err := validate(cmd, args); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidInput, err)
+		}
+		return nil
+	}
+}
+
+// validateFlags checks the required flags and flag groups before cobra does, so that missing flags wrap
+// ErrInvalidInput instead of being plain errors.
+func validateFlags(cmd *cobra.Command, _ []string) error {
+	if err := cmd.ValidateRequiredFlags(); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidInput, err)
+	}
+	if err := cmd.ValidateFlagGroups(); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidInput, err)
+	}
+	return nil
+}
\ No newline at end of file
@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"github.com/DrBushytop/amag/pkg/kql"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"io"
+	"testing"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{name: "no error", err: nil, want: ExitOK},
+		{name: "unknown error", err: errors.New("boom"), want: ExitError},
+		{name: "invalid input", err: fmt.Errorf("%w: bad flag", ErrInvalidInput), want: ExitInvalidInput},
+		{name: "wrapped query failure", err: fmt.Errorf("failed to aggregate metric: %w", fmt.Errorf("%w: timeout", kql.ErrQueryFailed)), want: ExitQueryFailed},
+		{name: "no rows", err: kql.ErrNoRows, want: ExitNoRows},
+		{name: "column missing", err: fmt.Errorf("x: %w", kql.ErrColumnMissing), want: ExitColumnMissing},
+		{name: "upload rejected", err: fmt.Errorf("x: %w", kql.ErrUploadRejected), want: ExitUploadRejected},
+		{name: "auth failure takes priority", err: errors.Join(kql.ErrUploadRejected, kql.ErrAuthFailed), want: ExitAuthFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if got := exitCode(tt.err); got != tt.want {
+				t.Errorf("exitCode() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommandInputErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{name: "valid", args: []string{"run", "jobs.yaml", "--file", "a.kql"}, want: ExitOK},
+		{name: "missing argument", args: []string{"run", "--file", "a.kql"}, want: ExitInvalidInput},
+		{name: "extra argument", args: []string{"run", "jobs.yaml", "other.yaml", "--file", "a.kql"}, want: ExitInvalidInput},
+		{name: "missing required flag", args: []string{"run", "jobs.yaml"}, want: ExitInvalidInput},
+		{name: "unknown flag", args: []string{"run", "jobs.yaml", "--file", "a.kql", "--fiel", "b.kql"}, want: ExitInvalidInput},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			// A command tree wired up like the root command and its subcommands
+			root := &cobra.Command{Use: "amag", SilenceErrors: true, SilenceUsage: true, PersistentPreRunE: validateFlags}
+			root.SetFlagErrorFunc(rootCmd.FlagErrorFunc())
+			sub := &cobra.Command{
+				Use:  "run",
+				Args: invalidInputArgs(cobra.ExactArgs(1)),
+				RunE: func(*cobra.Command, []string) error { return nil },
+			}
+			sub.Flags().String("file", "", "")
+			if err := sub.MarkFlagRequired("file"); err != nil {
+				t.Fatal(err)
+			}
+			root.AddCommand(sub)
+			root.SetArgs(tt.args)
+			root.SetOut(io.Discard)
+			root.SetErr(io.Discard)
+
+			err := root.Execute()
+			if got := exitCode(err); got != tt.want {
+				t.Errorf("exitCode() = %v for error %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewCredentialExitCode(t *testing.T) {
+	t.Setenv("AZURE_CLIENT_SECRET", "")
+
+	tests := []struct {
+		name     string
+		settings map[string]string
+		want     int
+	}{
+		{name: "valid", settings: map[string]string{KeyAuth: "azurecli"}, want: ExitOK},
+		{name: "unknown auth mode", settings: map[string]string{KeyAuth: "password"}, want: ExitInvalidInput},
+		{name: "unknown cloud", settings: map[string]string{KeyAuth: "azurecli", KeyCloud: "germany"}, want: ExitInvalidInput},
+		{
+			name:     "client secret missing from the environment",
+			settings: map[string]string{KeyAuth: "clientsecret", KeyTenantID: "tenant", KeyClientID: "client"},
+			want:     ExitAuthFailed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range []string{KeyAuth, KeyCloud, KeyTenantID, KeyClientID} {
+				previous := viper.Get(key)
+				viper.Set(key, tt.settings[key])
+				t.Cleanup(func() { viper.Set(key, previous) })
+			}
+
+			_, err := newCredential()
+			if got := exitCode(err); got != tt.want {
+				t.Errorf("exitCode() = %v for error %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
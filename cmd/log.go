@@ -1,13 +1,9 @@
 package cmd
 
 import (
-	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
-	"github.com/Azure/azure-sdk-for-go/sdk/monitor/azquery"
-	"github.com/DrBushytop/amag/pkg/kql"
-	"github.com/charmbracelet/log"
+	"fmt"
+	"github.com/DrBushytop/amag/pkg/job"
 	"github.com/spf13/viper"
-	"golang.org/x/net/context"
-	"time"
 
 	"github.com/spf13/cobra"
 )
@@ -28,6 +24,10 @@ Example usage:
 amag aggregate metric --file "/path/to/query.kql" --metric "LatencyP90" --workspaceid "<workspace-id>" --datacollectionendpoint "<data-collection-endpoint>" --datacollectionstreamname "<data-collection-stream-name>" --datacollectionruleid "<data-collection-rule-id>"
 
 You can set defaults using the config command or env variables.
+The metric name, workspace ID and timespan can also be set in the front matter of the query file. Flags override the front matter.
+The query runs against a Log Analytics workspace, or with --appid, --resourceid, --cluster and --database, or --source resourcegraph
+against a classic Application Insights app, the logs of an Azure resource, an Azure Data Explorer database or Azure Resource Graph.
+Add --dry-run to print the target URL and the log entries that would be uploaded, without uploading them.
 
 This command requires:
 - A KQL query file that defines the aggregation. It should have at least column MetricValue. All results are saved.
@@ -36,13 +36,15 @@ This command requires:
 - A data collection endpoint to send data to.
 - A data collection stream name to send data to.
 - A data collection rule ID to use.
+
+With --passthrough, every column of the query result is saved alongside Name and Value, so that the custom table can hold dimensions.
+The TimeGenerated column of the result is saved as OriginalTimeGenerated. The data collection rule and table must have a column
+for each query column that should be kept.
 `,
-	Run: RunAggregateLog,
+	RunE: RunAggregateLog,
 }
 
-func RunAggregateLog(cmd *cobra.Command, args []string) {
-	keys := viper.AllKeys()
-	_ = keys
+func RunAggregateLog(cmd *cobra.Command, args []string) error {
 	metricName := viper.GetString(GetViperKey(cmd, KeyMetric))
 	fileName := viper.GetString(GetViperKey(cmd, KeyFile))
 	workspaceId := viper.GetString(GetViperKey(cmd, KeyWorkspaceID))
@@ -50,58 +52,40 @@ func RunAggregateLog(cmd *cobra.Command, args []string) {
 	dataCollectionStreamName := viper.GetString(GetViperKey(cmd, KeyDataCollectionStreamName))
 	dataCollectionRuleId := viper.GetString(GetViperKey(cmd, KeyDataCollectionRuleId))
 
-	cmd.SilenceUsage = true // Avoid printing usage on error generated by functions later
-
-	query, err := kql.ParseQuery(fileName)
-	if err != nil {
-		log.Error("Error parsing query from file", "file", fileName, "err", err)
-		return
+	j := job.Job{
+		Name:            metricName,
+		File:            fileName,
+		WorkspaceID:     workspaceId,
+		SplitWorkspaces: viper.GetBool(GetViperKey(cmd, KeySplitWorkspaces)),
+		Sink: job.Sink{
+			Type:                     job.SinkLog,
+			Metric:                   metricName,
+			DataCollectionEndpoint:   dataCollectionEndpoint,
+			DataCollectionStreamName: dataCollectionStreamName,
+			DataCollectionRuleID:     dataCollectionRuleId,
+			Passthrough:              viper.GetBool(GetViperKey(cmd, KeyPassthrough)),
+		},
 	}
-
-	log.Infof("Running Query:\n%s", query)
-
-	wsClient, err := kql.NewWorkspaceClient(workspaceId)
-	if err != nil {
-		log.Error("Failed to create workspace client", "err", err)
-		return
+	setSource(cmd, &j)
+	if err := setQueryMetadata(&j); err != nil {
+		return fmt.Errorf("%w: error reading metadata from file %s: %w", ErrInvalidInput, fileName, err)
 	}
-
-	res, err := wsClient.QueryWorkspaceForAggregateValue(
-		context.Background(),
-		azquery.Body{
-			Query:    to.Ptr(query),
-			Timespan: to.Ptr(azquery.NewTimeInterval(time.Now().Add(time.Duration(-24)*time.Hour), time.Now())),
-		},
-		nil,
-	)
-	if err != nil {
-		log.Error("Failed to aggregate workspace", "err", err)
-		return
+	if err := setParams(cmd, &j); err != nil {
+		return fmt.Errorf("%w: error reading query parameters: %w", ErrInvalidInput, err)
 	}
-
-	logsClient, err := kql.NewLogsClient(dataCollectionStreamName, dataCollectionEndpoint, dataCollectionRuleId)
-	if err != nil {
-		log.Error("Failed to create logs client", "err", err)
-		return
+	if err := setTimeWindow(cmd, &j); err != nil {
+		return fmt.Errorf("%w: error resolving time window: %w", ErrInvalidInput, err)
 	}
-
-	var ag []kql.AggregateLogEntry
-
-	for _, r := range res {
-		ag = append(ag, kql.AggregateLogEntry{
-			TimeGenerated:         time.Now(),
-			OriginalTimeGenerated: r.TimeGenerated,
-			Name:                  metricName,
-			Value:                 r.MetricValue,
-		})
+	if err := j.Validate(); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidInput, err)
 	}
 
-	log.Info("Sending log")
-	if err := logsClient.SaveLogEntryToLogAnalytics(context.Background(), ag); err != nil {
-		log.Error("Failed to send log", "err", err)
-		return
+	cmd.SilenceUsage = true // Avoid printing usage on error generated by functions later
+
+	if err := runAggregateJob(cmd, j); err != nil {
+		return fmt.Errorf("failed to aggregate log: %w", err)
 	}
-	log.Info("Saved log", "metricName", metricName, "number of entries", len(ag))
+	return nil
 }
 
 func init() {
@@ -111,11 +95,11 @@ func init() {
 	if err != nil {
 		panic(err)
 	}
-	err = bind(logCmd, KeyMetric, "m", "", "Name of the custom metric to save the result into")
+	err = bindOptional(logCmd, KeyMetric, "m", "", "Name of the custom metric to save the result into. Required unless set in the query file")
 	if err != nil {
 		panic(err)
 	}
-	err = bind(logCmd, KeyWorkspaceID, "w", "", "Workspace id (not the resource id) of the Log Analytics workspace to run the aggregate against")
+	err = bindOptional(logCmd, KeyWorkspaceID, "w", "", "Workspace id (not the resource id) of the Log Analytics workspace to run the aggregate against, or a comma separated list of them. Required unless set in the query file or another source is queried")
 	if err != nil {
 		panic(err)
 	}
@@ -132,5 +116,33 @@ func init() {
 	if err != nil {
 		panic(err)
 	}
+	err = bindTimeWindow(logCmd)
+	if err != nil {
+		panic(err)
+	}
+	err = bindIncremental(logCmd)
+	if err != nil {
+		panic(err)
+	}
+	err = bindParams(logCmd)
+	if err != nil {
+		panic(err)
+	}
+	err = bindWorkspaces(logCmd)
+	if err != nil {
+		panic(err)
+	}
+	err = bindSource(logCmd)
+	if err != nil {
+		panic(err)
+	}
+	err = bindBool(logCmd, KeyDryRun, "Run the query and print the target URL and payload that would be sent, without sending it or moving the checkpoint")
+	if err != nil {
+		panic(err)
+	}
+	err = bindBool(logCmd, KeyPassthrough, "Save all columns of the query result to the log table, instead of only Name and Value. The table must have matching columns")
+	if err != nil {
+		panic(err)
+	}
 
 }
\ No newline at end of file
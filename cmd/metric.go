@@ -1,13 +1,9 @@
 package cmd
 
 import (
-	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
-	"github.com/Azure/azure-sdk-for-go/sdk/monitor/azquery"
-	"github.com/DrBushytop/amag/pkg/kql"
-	"github.com/charmbracelet/log"
+	"fmt"
+	"github.com/DrBushytop/amag/pkg/job"
 	"github.com/spf13/viper"
-	"golang.org/x/net/context"
-	"time"
 
 	"github.com/spf13/cobra"
 )
@@ -23,85 +19,113 @@ Example usage:
 
 amag aggregate metric --file /path/to/query.kql --metric LatencyP90 --workspaceid <workspace-id> --scoperesourceid <scope-resource-id>
 
+The metric name, workspace ID, scope resource ID and timespan can also be set in the front matter of the query file,
+in which case only --file is needed. Flags override the front matter.
+
+The query runs against a Log Analytics workspace, or with --appid, --resourceid, --cluster and --database, or --source resourcegraph
+against a classic Application Insights app, the logs of an Azure resource, an Azure Data Explorer database or Azure Resource Graph.
+
+Add --dry-run to print the target URL and the custom metric body that would be sent, without sending it.
+
 This command requires:
-- A KQL query file that defines the aggregation. It must have at least a column named MetricValue. Only the first row of the result is used, TimeGenerated is ignored.
+- A KQL query file that defines the aggregation. It must have at least a column named MetricValue. TimeGenerated is ignored.
+  Every other column (e.g. cloud_RoleName, ResultCode) becomes a dimension of the metric, and each row is saved as its own series.
+  If the query has no other columns, only the first row of the result is used.
+  Optional MetricMin, MetricMax, MetricSum and MetricCount columns are saved as the pre-aggregated values of each series.
 - A valid workspace ID where the query will be executed.
-- A scope resource ID where the custom metric will be saved. This can be a resource or subresource ID.`,
-	Run: RunAggregateMetric,
+- A scope resource ID where the custom metric will be saved. This can be a resource or subresource ID.
+  The metric is sent to the region of the resource, which is looked up once and cached under $HOME/.amag unless --location is given.`,
+	RunE: RunAggregateMetric,
 }
 
-func RunAggregateMetric(cmd *cobra.Command, args []string) {
+func RunAggregateMetric(cmd *cobra.Command, args []string) error {
 	metricName := viper.GetString(GetViperKey(cmd, KeyMetric))
 	fileName := viper.GetString(GetViperKey(cmd, KeyFile))
 	workspaceId := viper.GetString(GetViperKey(cmd, KeyWorkspaceID))
 	scopeResourceId := viper.GetString(GetViperKey(cmd, KeyScopeResourceID))
-	if err := validateResourceId(scopeResourceId); err != nil {
-		log.Error("Error validating scopeResourceId", "err", err)
-		return
+
+	j := job.Job{
+		Name:            metricName,
+		File:            fileName,
+		WorkspaceID:     workspaceId,
+		SplitWorkspaces: viper.GetBool(GetViperKey(cmd, KeySplitWorkspaces)),
+		Sink: job.Sink{
+			Type:            job.SinkMetric,
+			Metric:          metricName,
+			ScopeResourceID: scopeResourceId,
+			Location:        viper.GetString(GetViperKey(cmd, KeyLocation)),
+		},
+	}
+	setSource(cmd, &j)
+	if err := setQueryMetadata(&j); err != nil {
+		return fmt.Errorf("%w: error reading metadata from file %s: %w", ErrInvalidInput, fileName, err)
+	}
+	if err := setParams(cmd, &j); err != nil {
+		return fmt.Errorf("%w: error reading query parameters: %w", ErrInvalidInput, err)
+	}
+	if err := setTimeWindow(cmd, &j); err != nil {
+		return fmt.Errorf("%w: error resolving time window: %w", ErrInvalidInput, err)
+	}
+	if err := j.Validate(); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidInput, err)
+	}
+	if err := validateResourceId(j.Sink.ScopeResourceID); err != nil {
+		return fmt.Errorf("%w: error validating scopeResourceId: %w", ErrInvalidInput, err)
 	}
 
 	cmd.SilenceUsage = true // Avoid printing usage on error generated by functions later
 
-	query, err := kql.ParseQuery(fileName)
-	if err != nil {
-		log.Error("Error parsing query from file", "file", fileName, "err", err)
-		return
+	if err := runAggregateJob(cmd, j); err != nil {
+		return fmt.Errorf("failed to aggregate metric: %w", err)
 	}
+	return nil
+}
 
-	log.Infof("Running Query:\n%s", query)
+func init() {
+	aggregateCmd.AddCommand(metricCmd)
 
-	wsClient, err := kql.NewWorkspaceClient(workspaceId)
+	err := bind(metricCmd, KeyFile, "f", "", "Path to the KQL file to run")
 	if err != nil {
-		log.Error("Failed to create workspace client", "err", err)
-		return
+		panic(err)
 	}
-
-	res, err := wsClient.QueryWorkspaceForAggregateValue(
-		context.Background(),
-		azquery.Body{
-			Query:    to.Ptr(query),
-			Timespan: to.Ptr(azquery.NewTimeInterval(time.Now().Add(time.Duration(-24)*time.Hour), time.Now())),
-		},
-		nil,
-	)
+	err = bindOptional(metricCmd, KeyMetric, "m", "", "Name of the custom metric to save the result into. Required unless set in the query file")
 	if err != nil {
-		log.Error("Failed to aggregate workspace", "err", err)
-		return
+		panic(err)
 	}
-
-	body := kql.NewCustomMetricsBody(metricName, res[0].MetricValue)
-
-	cmClient, err := kql.NewCustomMetricsClient()
+	err = bindOptional(metricCmd, KeyWorkspaceID, "w", "", "Workspace id (not the resource id) of the Log Analytics workspace to run the aggregate against, or a comma separated list of them. Required unless set in the query file or another source is queried")
 	if err != nil {
-		log.Error("Failed to create custom metrics client", "err", err)
-		return
+		panic(err)
 	}
-	log.Info("Sending custom metric")
-	if err := cmClient.SendCustomMetrics(context.Background(), scopeResourceId, "westeurope", body); err != nil {
-		log.Error("Failed to send custom metrics", "err", err)
-		return
+	err = bindOptional(metricCmd, KeyScopeResourceID, "s", "", "Resource id of the scope to save the custom metric to. Required unless set in the query file")
+	if err != nil {
+		panic(err)
 	}
-
-	log.Info("Saved custom metric", "metricName", metricName, "metricValue", res, "scope", scopeResourceId)
-}
-
-func init() {
-	aggregateCmd.AddCommand(metricCmd)
-
-	err := bind(metricCmd, KeyFile, "f", "", "Path to the KQL file to run")
+	err = bindOptional(metricCmd, KeyLocation, "l", "", "Azure region of the scope resource. Resolved from the resource if not set")
 	if err != nil {
 		panic(err)
 	}
-	err = bind(metricCmd, KeyMetric, "m", "", "Name of the custom metric to save the result into")
+	err = bindTimeWindow(metricCmd)
 	if err != nil {
 		panic(err)
 	}
-	err = bind(metricCmd, KeyWorkspaceID, "w", "", "Workspace id (not the resource id) of the Log Analytics workspace to run the aggregate against")
+	err = bindIncremental(metricCmd)
 	if err != nil {
 		panic(err)
 	}
-	err = bind(metricCmd, KeyScopeResourceID, "s", "", "Resource id of the scope to save the custom metric to")
+	err = bindParams(metricCmd)
 	if err != nil {
 		panic(err)
 	}
-}
\ No newline at end of file
+	err = bindWorkspaces(metricCmd)
+	if err != nil {
+		panic(err)
+	}
+	err = bindSource(metricCmd)
+	if err != nil {
+		panic(err)
+	}
+	err = bindBool(metricCmd, KeyDryRun, "Run the query and print the target URL and payload that would be sent, without sending it or moving the checkpoint")
+	if err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/DrBushytop/amag/pkg/job"
+	"github.com/DrBushytop/amag/pkg/kql"
+	"github.com/spf13/viper"
+
+	"github.com/spf13/cobra"
+)
+
+// otlpCmd represents the otlp command
+var otlpCmd = &cobra.Command{
+	Use:   "otlp",
+	Short: "Aggregate KQL values and export them as OpenTelemetry metrics",
+	Long: `Run a specified KQL file against an Azure Log Analytics workspace, aggregate the result,
+and export it as an OTLP gauge to an OpenTelemetry collector, or any other OTLP receiver, over HTTP/protobuf or gRPC.
+
+Example usage:
+
+amag aggregate otlp --file "/path/to/query.kql" --metric "request.latency.p90" --unit ms --workspaceid "<workspace-id>" --otlpendpoint "http://localhost:4318"
+
+You can set defaults using the config command or env variables.
+The metric name, unit, workspace ID, timespan and resource attributes can also be set in the front matter of the query file.
+Flags override the front matter.
+The query runs against a Log Analytics workspace, or with --appid, --resourceid, --cluster and --database, or --source resourcegraph
+against a classic Application Insights app, the logs of an Azure resource, an Azure Data Explorer database or Azure Resource Graph.
+Add --dry-run to print the endpoint and the metrics that would be exported, without exporting them.
+
+This command requires:
+- A KQL query file that defines the aggregation. It must produce a value in the MetricValue column.
+  Every other column in the result becomes an attribute of the data point, with one data point per row.
+- A valid workspace ID where the query will be executed.
+- Name of the metric.
+
+The TimeGenerated column of the result is used as the data point timestamp, or the current time if there is none.
+Without --otlpendpoint, the endpoint is read from OTEL_EXPORTER_OTLP_METRICS_ENDPOINT or OTEL_EXPORTER_OTLP_ENDPOINT,
+and defaults to localhost. Headers, e.g. for authentication, are read from OTEL_EXPORTER_OTLP_HEADERS.
+`,
+	RunE: RunAggregateOTLP,
+}
+
+func RunAggregateOTLP(cmd *cobra.Command, args []string) error {
+	metricName := viper.GetString(GetViperKey(cmd, KeyMetric))
+	fileName := viper.GetString(GetViperKey(cmd, KeyFile))
+
+	attributeValues, err := cmd.Flags().GetStringArray(KeyAttribute)
+	if err != nil {
+		return err
+	}
+	attributes, err := kql.ParseParams(attributeValues)
+	if err != nil {
+		return fmt.Errorf("%w: error reading resource attributes: %w", ErrInvalidInput, err)
+	}
+
+	j := job.Job{
+		Name:            metricName,
+		File:            fileName,
+		WorkspaceID:     viper.GetString(GetViperKey(cmd, KeyWorkspaceID)),
+		SplitWorkspaces: viper.GetBool(GetViperKey(cmd, KeySplitWorkspaces)),
+		Sink: job.Sink{
+			Type:         job.SinkOTLP,
+			Metric:       metricName,
+			Unit:         viper.GetString(GetViperKey(cmd, KeyUnit)),
+			OTLPEndpoint: viper.GetString(GetViperKey(cmd, KeyOTLPEndpoint)),
+			OTLPProtocol: viper.GetString(GetViperKey(cmd, KeyOTLPProtocol)),
+			Attributes:   attributes,
+		},
+	}
+	setSource(cmd, &j)
+	if err := setQueryMetadata(&j); err != nil {
+		return fmt.Errorf("%w: error reading metadata from file %s: %w", ErrInvalidInput, fileName, err)
+	}
+	if err := setParams(cmd, &j); err != nil {
+		return fmt.Errorf("%w: error reading query parameters: %w", ErrInvalidInput, err)
+	}
+	if err := setTimeWindow(cmd, &j); err != nil {
+		return fmt.Errorf("%w: error resolving time window: %w", ErrInvalidInput, err)
+	}
+	if err := j.Validate(); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidInput, err)
+	}
+
+	cmd.SilenceUsage = true // Avoid printing usage on error generated by functions later
+
+	if err := runAggregateJob(cmd, j); err != nil {
+		return fmt.Errorf("failed to export otlp metric: %w", err)
+	}
+	return nil
+}
+
+func init() {
+	aggregateCmd.AddCommand(otlpCmd)
+
+	err := bind(otlpCmd, KeyFile, "f", "", "Path to the KQL file to run")
+	if err != nil {
+		panic(err)
+	}
+	err = bindOptional(otlpCmd, KeyMetric, "m", "", "Name of the OTLP metric to export the result as. Required unless set in the query file")
+	if err != nil {
+		panic(err)
+	}
+	err = bindOptional(otlpCmd, KeyWorkspaceID, "w", "", "Workspace id (not the resource id) of the Log Analytics workspace to run the aggregate against, or a comma separated list of them. Required unless set in the query file or another source is queried")
+	if err != nil {
+		panic(err)
+	}
+
+	err = bindOptional(otlpCmd, KeyOTLPEndpoint, "e", "", "URL of the OTLP receiver, e.g. http://localhost:4318 or http://localhost:4317 for grpc. Defaults to OTEL_EXPORTER_OTLP_ENDPOINT")
+	if err != nil {
+		panic(err)
+	}
+	err = bindOptional(otlpCmd, KeyOTLPProtocol, "", string(kql.OTLPProtocolHTTP), "Protocol to export with: http/protobuf or grpc")
+	if err != nil {
+		panic(err)
+	}
+	err = bindOptional(otlpCmd, KeyUnit, "", "", "Unit of the metric, e.g. ms or By. Defaults to the unit in the query file")
+	if err != nil {
+		panic(err)
+	}
+	otlpCmd.Flags().StringArray(KeyAttribute, nil, "Resource attribute as key=value, e.g. service.name=checkout. Can be repeated, and overrides the attributes in the query file")
+	err = bindTimeWindow(otlpCmd)
+	if err != nil {
+		panic(err)
+	}
+	err = bindIncremental(otlpCmd)
+	if err != nil {
+		panic(err)
+	}
+	err = bindParams(otlpCmd)
+	if err != nil {
+		panic(err)
+	}
+	err = bindWorkspaces(otlpCmd)
+	if err != nil {
+		panic(err)
+	}
+	err = bindSource(otlpCmd)
+	if err != nil {
+		panic(err)
+	}
+	err = bindBool(otlpCmd, KeyDryRun, "Run the query and print the target URL and payload that would be sent, without sending it or moving the checkpoint")
+	if err != nil {
+		panic(err)
+	}
+}
@@ -2,10 +2,14 @@ package cmd
 
 import (
 	"fmt"
+	"github.com/DrBushytop/amag/pkg/job"
+	"github.com/DrBushytop/amag/pkg/kql"
 	"github.com/charmbracelet/log"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"maps"
 	"regexp"
+	"time"
 )
 
 const (
@@ -16,11 +20,55 @@ const (
 	KeyDataCollectionEndpoint   = "dataCollectionEndpoint"
 	KeyDataCollectionStreamName = "dataCollectionStreamName"
 	KeyDataCollectionRuleId     = "dataCollectionRuleId"
+	KeyLocation                 = "location"
+	KeyTimespan                 = "timespan"
+	KeyStart                    = "start"
+	KeyEnd                      = "end"
+	KeyAlign                    = "align"
+	KeyIncremental              = "incremental"
+	KeyResetCheckpoint          = "reset-checkpoint"
+	KeySink                     = "sink"
+	KeyFrom                     = "from"
+	KeyTo                       = "to"
+	KeyStep                     = "step"
+	KeyPassthrough              = "passthrough"
+	KeyAuth                     = "auth"
+	KeyTenantID                 = "tenantid"
+	KeyClientID                 = "clientid"
+	KeyClientCertificate        = "clientcertificate"
+	KeyCloud                    = "cloud"
+	KeyOutput                   = "output"
+	KeyDryRun                   = "dry-run"
+	KeyParam                    = "param"
+	KeyParams                   = "params"
+	KeyDeclareParams            = "declare-params"
+	KeySplitWorkspaces          = "split-workspaces"
+	KeySource                   = "source"
+	KeyAppID                    = "appid"
+	KeyResourceID               = "resourceid"
+	KeyCluster                  = "cluster"
+	KeyDatabase                 = "database"
+	KeySubscriptions            = "subscriptions"
+	KeyManagementGroups         = "managementgroups"
+	KeyRemoteWriteURL           = "remotewriteurl"
+	KeyUsername                 = "username"
+	KeyOTLPEndpoint             = "otlpendpoint"
+	KeyOTLPProtocol             = "otlpprotocol"
+	KeyUnit                     = "unit"
+	KeyAttribute                = "attribute"
 )
 
 func bind(cmd *cobra.Command, keyName string, shortHand string, value string, usage string) error {
-	cmd.Flags().StringP(keyName, shortHand, value, usage)
+	if err := bindOptional(cmd, keyName, shortHand, value, usage); err != nil {
+		return err
+	}
 	_ = cmd.MarkFlagRequired(keyName)
+	return nil
+}
+
+// bindOptional works like bind, but does not mark the flag as required.
+func bindOptional(cmd *cobra.Command, keyName string, shortHand string, value string, usage string) error {
+	cmd.Flags().StringP(keyName, shortHand, value, usage)
 	err := viper.BindPFlag(GetViperKey(cmd, keyName), cmd.Flags().Lookup(keyName))
 	if err != nil {
 		log.Error("Failed to bind flag", "name", keyName, "err", err)
@@ -44,6 +92,166 @@ func bindPersistent(cmd *cobra.Command, keyName string, shortHand string, value
 	return nil
 }
 
+// bindGlobal binds a persistent flag of the root command, available to all commands. The viper key is the flag name
+// without a command prefix, so that it can be set at the top level of the config file.
+func bindGlobal(keyName string, value string, usage string) error {
+	rootCmd.PersistentFlags().String(keyName, value, usage)
+	err := viper.BindPFlag(keyName, rootCmd.PersistentFlags().Lookup(keyName))
+	if err != nil {
+		log.Error("Failed to bind flag", "name", keyName, "err", err)
+		return err
+	}
+	return nil
+}
+
+// bindBool works like bindOptional for boolean flags.
+func bindBool(cmd *cobra.Command, keyName string, usage string) error {
+	cmd.Flags().Bool(keyName, false, usage)
+	err := viper.BindPFlag(GetViperKey(cmd, keyName), cmd.Flags().Lookup(keyName))
+	if err != nil {
+		log.Error("Failed to bind flag", "name", keyName, "err", err)
+		return err
+	}
+	return nil
+}
+
+// bindTimeWindow adds the flags used to select the time window a query is run over.
+func bindTimeWindow(cmd *cobra.Command) error {
+	if err := bindOptional(cmd, KeyTimespan, "t", "24h", "Length of the time window to query, e.g. 6h or 7d. Ignored if --start is set"); err != nil {
+		return err
+	}
+	if err := bindOptional(cmd, KeyStart, "", "", "Start of the time window. RFC3339 timestamp or relative expression like ago(6h)"); err != nil {
+		return err
+	}
+	if err := bindOptional(cmd, KeyEnd, "", "", "End of the time window. RFC3339 timestamp or relative expression like ago(1h). Defaults to now"); err != nil {
+		return err
+	}
+	return bindOptional(cmd, KeyAlign, "", "", "Truncate the start and end of the time window to multiples of this bin size, e.g. 1h")
+}
+
+// bindIncremental adds the flags used to run a job incrementally from its checkpoint.
+func bindIncremental(cmd *cobra.Command) error {
+	if err := bindBool(cmd, KeyIncremental, "Query from the end of the last successful run instead of the configured time window. Missed bins are caught up in --align sized steps"); err != nil {
+		return err
+	}
+	return bindBool(cmd, KeyResetCheckpoint, "Remove the checkpoint before running, so that the configured time window is used again")
+}
+
+// setTimeWindow copies the flags added by bindTimeWindow and bindIncremental to the job, and validates them. Jobs run
+// over an explicitly set window must filter on it if their source requires it, see job.Job.ValidateTimeFilter.
+// A timespan already set on the job, e.g. from the query file, is only overridden by an explicitly set timespan.
+func setTimeWindow(cmd *cobra.Command, j *job.Job) error {
+	timespanSet := cmd.Flags().Changed(KeyTimespan) || viper.IsSet(GetViperKey(cmd, KeyTimespan))
+	if j.Timespan == "" || timespanSet {
+		j.Timespan = viper.GetString(GetViperKey(cmd, KeyTimespan))
+	}
+	j.Start = viper.GetString(GetViperKey(cmd, KeyStart))
+	j.End = viper.GetString(GetViperKey(cmd, KeyEnd))
+	j.Align = viper.GetString(GetViperKey(cmd, KeyAlign))
+	j.Incremental = viper.GetBool(GetViperKey(cmd, KeyIncremental))
+
+	if _, err := kql.ParseTimeWindow(time.Now().UTC(), j.Timespan, j.Start, j.End, j.Align); err != nil {
+		return err
+	}
+	// The default timespan doesn't count, so that sources without a query timespan can run queries without a window
+	if j.Incremental || timespanSet || j.Start != "" || j.End != "" {
+		return j.ValidateTimeFilter()
+	}
+	return nil
+}
+
+// bindWorkspaces adds the flags used to select how multiple workspaces are queried. The workspaces themselves are
+// given as a comma separated list in the workspaceid flag.
+func bindWorkspaces(cmd *cobra.Command) error {
+	return bindBool(cmd, KeySplitWorkspaces, "Query each workspace of a comma separated --workspaceid (or app of --appid) separately, and add its id to each row as the WorkspaceId (or AppId) dimension, instead of querying them as a union")
+}
+
+// bindSource adds the flags used to query something other than a Log Analytics workspace.
+func bindSource(cmd *cobra.Command) error {
+	if err := bindOptional(cmd, KeySource, "", "", "What to run the query against: workspace, appinsights, resource, adx or resourcegraph. Defaults to the one whose flag is set"); err != nil {
+		return err
+	}
+	if err := bindOptional(cmd, KeyAppID, "", "", "App id of a classic Application Insights app to run the query against, or a comma separated list of them"); err != nil {
+		return err
+	}
+	if err := bindOptional(cmd, KeyResourceID, "", "", "Resource id of an Azure resource to run the query against its logs, e.g. a workspace based Application Insights component"); err != nil {
+		return err
+	}
+	if err := bindOptional(cmd, KeyCluster, "", "", "URI of an Azure Data Explorer cluster to run the query against, e.g. https://mycluster.westeurope.kusto.windows.net"); err != nil {
+		return err
+	}
+	if err := bindOptional(cmd, KeyDatabase, "", "", "Database of the Azure Data Explorer cluster to run the query in"); err != nil {
+		return err
+	}
+	if err := bindOptional(cmd, KeySubscriptions, "", "", "Comma separated subscription ids to run an Azure Resource Graph query in. Without subscriptions or management groups, all readable subscriptions are queried"); err != nil {
+		return err
+	}
+	return bindOptional(cmd, KeyManagementGroups, "", "", "Comma separated management group ids to run an Azure Resource Graph query in")
+}
+
+// setSource copies the flags added by bindSource to the job.
+func setSource(cmd *cobra.Command, j *job.Job) {
+	j.Source = job.Source{
+		Type:             job.SourceType(viper.GetString(GetViperKey(cmd, KeySource))),
+		AppID:            viper.GetString(GetViperKey(cmd, KeyAppID)),
+		ResourceID:       viper.GetString(GetViperKey(cmd, KeyResourceID)),
+		Cluster:          viper.GetString(GetViperKey(cmd, KeyCluster)),
+		Database:         viper.GetString(GetViperKey(cmd, KeyDatabase)),
+		Subscriptions:    viper.GetString(GetViperKey(cmd, KeySubscriptions)),
+		ManagementGroups: viper.GetString(GetViperKey(cmd, KeyManagementGroups)),
+	}
+}
+
+// bindParams adds the flags used to fill the template placeholders of the query file.
+func bindParams(cmd *cobra.Command) error {
+	cmd.Flags().StringArray(KeyParam, nil, "Value of a template placeholder of the query file as key=value, e.g. Service=api. Can be repeated")
+	return bindBool(cmd, KeyDeclareParams, "Also declare the parameters as KQL query parameters, so that the query can refer to them by name")
+}
+
+// getParams returns the query parameters from the params list of the config file, overridden by the --param flags.
+func getParams(cmd *cobra.Command) (map[string]string, error) {
+	// Parameters are given as key=value strings in the config file as well, since viper lowercases the keys of maps
+	params, err := kql.ParseParams(viper.GetStringSlice(KeyParams))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s in config: %w", KeyParams, err)
+	}
+	flagValues, err := cmd.Flags().GetStringArray(KeyParam)
+	if err != nil {
+		return nil, err
+	}
+	flagParams, err := kql.ParseParams(flagValues)
+	if err != nil {
+		return nil, err
+	}
+	maps.Copy(params, flagParams)
+	return params, nil
+}
+
+// setParams copies the flags added by bindParams to the job.
+func setParams(cmd *cobra.Command, j *job.Job) error {
+	params, err := getParams(cmd)
+	if err != nil {
+		return err
+	}
+	j.Params = params
+	j.DeclareParams = viper.GetBool(GetViperKey(cmd, KeyDeclareParams))
+	return nil
+}
+
+// setQueryMetadata fills the fields of the job that were not given as flags from the front matter of its query file,
+// and names the job after its metric.
+func setQueryMetadata(j *job.Job) error {
+	withMetadata, err := j.WithQueryMetadata()
+	if err != nil {
+		return err
+	}
+	*j = withMetadata
+	if j.Name == "" {
+		j.Name = j.Sink.Metric
+	}
+	return nil
+}
+
 func validateResourceId(resourceId string) error {
 	unifiedPattern := `^/subscriptions/([a-f0-9\-]{36})/resourceGroups/([a-zA-Z0-9_\-\.]+)/providers/([a-zA-Z0-9_\-\.]+)/([a-zA-Z0-9_\-\.]+)/([a-zA-Z0-9_\-\.]+)(?:/([a-zA-Z0-9_\-\.]+)/([a-zA-Z0-9_\-\.]+))?(?:/([a-zA-Z0-9_\-\.]+)/([a-zA-Z0-9_\-\.]+))?$`
 	reUnified := regexp.MustCompile(unifiedPattern)
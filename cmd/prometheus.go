@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/DrBushytop/amag/pkg/job"
+	"github.com/spf13/viper"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// prometheusCmd represents the prometheus command
+var prometheusCmd = &cobra.Command{
+	Use:   "prometheus",
+	Short: "Aggregate KQL values and send them to a Prometheus remote write endpoint",
+	Long: `Run a specified KQL file against an Azure Log Analytics workspace, aggregate the result,
+and send it to a Prometheus remote write endpoint, such as Prometheus, Mimir, Thanos or the Azure Monitor managed service for Prometheus.
+
+Example usage:
+
+amag aggregate prometheus --file "/path/to/query.kql" --metric "latency_p90" --workspaceid "<workspace-id>" --remotewriteurl "https://prometheus.example.com/api/v1/write"
+
+You can set defaults using the config command or env variables.
+The metric name, workspace ID and timespan can also be set in the front matter of the query file. Flags override the front matter.
+The query runs against a Log Analytics workspace, or with --appid, --resourceid, --cluster and --database, or --source resourcegraph
+against a classic Application Insights app, the logs of an Azure resource, an Azure Data Explorer database or Azure Resource Graph.
+Add --dry-run to print the target URL and the series that would be sent, without sending them.
+
+This command requires:
+- A KQL query file that defines the aggregation. It must produce a value in the MetricValue column.
+  Every other column in the result becomes a label of the series, with one series per row.
+- A valid workspace ID where the query will be executed.
+- Name of the metric. Characters not allowed in Prometheus metric names are replaced with underscores.
+- The URL of the remote write endpoint.
+
+The TimeGenerated column of the result is used as the sample timestamp, or the current time if there is none.
+With --username, requests use basic auth with the password read from the AMAG_REMOTE_WRITE_PASSWORD environment variable, which must be set.
+Otherwise, AMAG_REMOTE_WRITE_BEARER_TOKEN is sent as bearer token if set.
+`,
+	RunE: RunAggregatePrometheus,
+}
+
+// Environment variables the secrets of the Prometheus sink are read from, so that they don't end up in shell history
+// or manifests.
+const (
+	envRemoteWritePassword    = "AMAG_REMOTE_WRITE_PASSWORD"
+	envRemoteWriteBearerToken = "AMAG_REMOTE_WRITE_BEARER_TOKEN"
+)
+
+// remoteWriteSecrets returns the password and bearer token of the Prometheus sink from the environment.
+func remoteWriteSecrets() (password string, bearerToken string) {
+	return os.Getenv(envRemoteWritePassword), os.Getenv(envRemoteWriteBearerToken)
+}
+
+func RunAggregatePrometheus(cmd *cobra.Command, args []string) error {
+	metricName := viper.GetString(GetViperKey(cmd, KeyMetric))
+	fileName := viper.GetString(GetViperKey(cmd, KeyFile))
+
+	j := job.Job{
+		Name:            metricName,
+		File:            fileName,
+		WorkspaceID:     viper.GetString(GetViperKey(cmd, KeyWorkspaceID)),
+		SplitWorkspaces: viper.GetBool(GetViperKey(cmd, KeySplitWorkspaces)),
+		Sink: job.Sink{
+			Type:           job.SinkPrometheus,
+			Metric:         metricName,
+			RemoteWriteURL: viper.GetString(GetViperKey(cmd, KeyRemoteWriteURL)),
+			Username:       viper.GetString(GetViperKey(cmd, KeyUsername)),
+		},
+	}
+	setSource(cmd, &j)
+	if err := setQueryMetadata(&j); err != nil {
+		return fmt.Errorf("%w: error reading metadata from file %s: %w", ErrInvalidInput, fileName, err)
+	}
+	j.Sink.Password, j.Sink.BearerToken = remoteWriteSecrets()
+	if err := setParams(cmd, &j); err != nil {
+		return fmt.Errorf("%w: error reading query parameters: %w", ErrInvalidInput, err)
+	}
+	if err := setTimeWindow(cmd, &j); err != nil {
+		return fmt.Errorf("%w: error resolving time window: %w", ErrInvalidInput, err)
+	}
+	if err := j.Validate(); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidInput, err)
+	}
+
+	cmd.SilenceUsage = true // Avoid printing usage on error generated by functions later
+
+	if err := runAggregateJob(cmd, j); err != nil {
+		return fmt.Errorf("failed to aggregate prometheus metric: %w", err)
+	}
+	return nil
+}
+
+func init() {
+	aggregateCmd.AddCommand(prometheusCmd)
+
+	err := bind(prometheusCmd, KeyFile, "f", "", "Path to the KQL file to run")
+	if err != nil {
+		panic(err)
+	}
+	err = bindOptional(prometheusCmd, KeyMetric, "m", "", "Name of the Prometheus metric to save the result into. Required unless set in the query file")
+	if err != nil {
+		panic(err)
+	}
+	err = bindOptional(prometheusCmd, KeyWorkspaceID, "w", "", "Workspace id (not the resource id) of the Log Analytics workspace to run the aggregate against, or a comma separated list of them. Required unless set in the query file or another source is queried")
+	if err != nil {
+		panic(err)
+	}
+
+	err = bind(prometheusCmd, KeyRemoteWriteURL, "u", "", "URL of the Prometheus remote write endpoint, e.g. https://prometheus.example.com/api/v1/write")
+	if err != nil {
+		panic(err)
+	}
+	err = bindOptional(prometheusCmd, KeyUsername, "", "", "Username for basic auth. The password is read from AMAG_REMOTE_WRITE_PASSWORD")
+	if err != nil {
+		panic(err)
+	}
+	err = bindTimeWindow(prometheusCmd)
+	if err != nil {
+		panic(err)
+	}
+	err = bindIncremental(prometheusCmd)
+	if err != nil {
+		panic(err)
+	}
+	err = bindParams(prometheusCmd)
+	if err != nil {
+		panic(err)
+	}
+	err = bindWorkspaces(prometheusCmd)
+	if err != nil {
+		panic(err)
+	}
+	err = bindSource(prometheusCmd)
+	if err != nil {
+		panic(err)
+	}
+	err = bindBool(prometheusCmd, KeyDryRun, "Run the query and print the target URL and payload that would be sent, without sending it or moving the checkpoint")
+	if err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,282 @@
+package cmd
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/monitor/azquery"
+	"github.com/DrBushytop/amag/pkg/auth"
+	"github.com/DrBushytop/amag/pkg/job"
+	"github.com/DrBushytop/amag/pkg/kql"
+	"github.com/charmbracelet/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	OutputTable = "table"
+	OutputJSON  = "json"
+	OutputCSV   = "csv"
+)
+
+var queryCmd = &cobra.Command{
+	Use:   "query",
+	Short: "Run a KQL query and print the result",
+	Long: `Run a specified KQL file against an Azure Log Analytics workspace and print the whole result, with all columns and their types.
+Instead of a workspace, --appid queries a classic Application Insights app, --resourceid the logs of an Azure resource,
+--cluster with --database an Azure Data Explorer database, and --source resourcegraph Azure Resource Graph.
+Nothing is saved. This is useful for checking what a query returns before saving it with the aggregate commands or a manifest.
+
+Example usage:
+
+amag query --file ./queries/latency_p90.kql --workspaceid <workspace-id> --timespan 1h --output table
+
+--output selects the format:
+- table: Aligned columns, with the type of each column in the header. The default.
+- json: An object per table with the columns and their types, and the rows as objects keyed by column name.
+- csv: The column names as the header row, followed by the rows. Tables are separated by an empty line.
+
+If the query partially fails, the error is logged and the partial result is printed. In json output, the error is included in the output.`,
+	RunE: RunQuery,
+}
+
+func RunQuery(cmd *cobra.Command, args []string) error {
+	fileName := viper.GetString(GetViperKey(cmd, KeyFile))
+	output := strings.ToLower(viper.GetString(GetViperKey(cmd, KeyOutput)))
+
+	write, ok := map[string]func(io.Writer, kql.QueryResult) error{
+		OutputTable: writeTable,
+		OutputJSON:  writeJSON,
+		OutputCSV:   writeCSV,
+	}[output]
+	if !ok {
+		return fmt.Errorf("%w: invalid output %q, must be one of %s, %s or %s", ErrInvalidInput, output, OutputTable, OutputJSON, OutputCSV)
+	}
+
+	j := job.Job{
+		File:        fileName,
+		WorkspaceID: viper.GetString(GetViperKey(cmd, KeyWorkspaceID)),
+	}
+	setSource(cmd, &j)
+	if err := setQueryMetadata(&j); err != nil {
+		return fmt.Errorf("%w: error reading metadata from file %s: %w", ErrInvalidInput, fileName, err)
+	}
+	if err := j.ValidateSource(); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidInput, err)
+	}
+	if err := setTimeWindow(cmd, &j); err != nil {
+		return fmt.Errorf("%w: error resolving time window: %w", ErrInvalidInput, err)
+	}
+	window, err := kql.ParseTimeWindow(time.Now().UTC(), j.Timespan, j.Start, j.End, j.Align)
+	if err != nil {
+		return fmt.Errorf("%w: error resolving time window: %w", ErrInvalidInput, err)
+	}
+
+	query, err := kql.ParseQuery(fileName)
+	if err != nil {
+		return fmt.Errorf("%w: error parsing query from file %s: %w", ErrInvalidInput, fileName, err)
+	}
+	params, err := getParams(cmd)
+	if err != nil {
+		return fmt.Errorf("%w: error reading query parameters: %w", ErrInvalidInput, err)
+	}
+	query, err = kql.RenderQuery(query, params, window)
+	if err != nil {
+		return fmt.Errorf("%w: error rendering query from file %s: %w", ErrInvalidInput, fileName, err)
+	}
+	if viper.GetBool(GetViperKey(cmd, KeyDeclareParams)) {
+		query = kql.DeclareQueryParameters(query, params)
+	}
+
+	cmd.SilenceUsage = true // Avoid printing usage on error generated by functions later
+
+	cred, err := newCredential()
+	if err != nil {
+		return err
+	}
+	wsClient, err := job.NewQueryClient(j, cred, auth.Cloud(viper.GetString(KeyCloud)))
+	if err != nil {
+		return fmt.Errorf("failed to create query client: %w", err)
+	}
+
+	log.Infof("Running Query over %s:\n%s", window, query)
+	res, err := wsClient.Query(
+		context.Background(),
+		azquery.Body{
+			Query:    to.Ptr(query),
+			Timespan: to.Ptr(window.TimeInterval()),
+		},
+		nil,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to run query: %w", err)
+	}
+	if res.PartialError != nil {
+		log.Warn("Query partially failed, the result may be incomplete", "err", res.PartialError)
+	}
+
+	return write(cmd.OutOrStdout(), res)
+}
+
+func writeTable(w io.Writer, res kql.QueryResult) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for i, table := range res.Tables {
+		if i > 0 {
+			fmt.Fprintln(tw)
+		}
+		if len(res.Tables) > 1 {
+			fmt.Fprintf(tw, "%s\n", table.Name)
+		}
+
+		header := make([]string, len(table.Columns))
+		for j, col := range table.Columns {
+			header[j] = fmt.Sprintf("%s (%s)", col.Name, col.Type)
+		}
+		fmt.Fprintln(tw, strings.Join(header, "\t"))
+
+		for _, row := range table.Rows {
+			values := make([]string, len(row))
+			for j, value := range row {
+				values[j] = formatValue(value)
+			}
+			fmt.Fprintln(tw, strings.Join(values, "\t"))
+		}
+		fmt.Fprintf(tw, "(%d rows)\n", len(table.Rows))
+	}
+	return tw.Flush()
+}
+
+func writeJSON(w io.Writer, res kql.QueryResult) error {
+	type jsonColumn struct {
+		Name string `json:"name"`
+		Type string `json:"type"`
+	}
+	type jsonTable struct {
+		Name    string           `json:"name"`
+		Columns []jsonColumn     `json:"columns"`
+		Rows    []map[string]any `json:"rows"`
+	}
+	out := struct {
+		Tables []jsonTable `json:"tables"`
+		Error  string      `json:"error,omitempty"`
+	}{
+		Tables: make([]jsonTable, len(res.Tables)),
+	}
+	if res.PartialError != nil {
+		out.Error = res.PartialError.Error()
+	}
+
+	for i, table := range res.Tables {
+		t := jsonTable{
+			Name:    table.Name,
+			Columns: make([]jsonColumn, len(table.Columns)),
+			Rows:    make([]map[string]any, len(table.Rows)),
+		}
+		for j, col := range table.Columns {
+			t.Columns[j] = jsonColumn{Name: col.Name, Type: col.Type}
+		}
+		for j, row := range table.Rows {
+			values := make(map[string]any, len(row))
+			for k, value := range row {
+				values[table.Columns[k].Name] = value
+			}
+			t.Rows[j] = values
+		}
+		out.Tables[i] = t
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+func writeCSV(w io.Writer, res kql.QueryResult) error {
+	cw := csv.NewWriter(w)
+	for i, table := range res.Tables {
+		if i > 0 {
+			cw.Flush()
+			if _, err := fmt.Fprintln(w); err != nil {
+				return err
+			}
+		}
+
+		header := make([]string, len(table.Columns))
+		for j, col := range table.Columns {
+			header[j] = col.Name
+		}
+		if err := cw.Write(header); err != nil {
+			return err
+		}
+		for _, row := range table.Rows {
+			values := make([]string, len(row))
+			for j, value := range row {
+				values[j] = formatValue(value)
+			}
+			if err := cw.Write(values); err != nil {
+				return err
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// formatValue formats a converted column value for the text outputs. Dynamic values are formatted as json.
+func formatValue(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(data)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(queryCmd)
+
+	err := bind(queryCmd, KeyFile, "f", "", "Path to the KQL file to run")
+	if err != nil {
+		panic(err)
+	}
+	err = bindOptional(queryCmd, KeyWorkspaceID, "w", "", "Workspace id (not the resource id) of the Log Analytics workspace to run the query against, or a comma separated list of them queried as a union. Required unless set in the query file or another source is queried")
+	if err != nil {
+		panic(err)
+	}
+	err = bindOptional(queryCmd, KeyOutput, "o", OutputTable, "Output format: table, json or csv")
+	if err != nil {
+		panic(err)
+	}
+	err = bindTimeWindow(queryCmd)
+	if err != nil {
+		panic(err)
+	}
+	err = bindParams(queryCmd)
+	if err != nil {
+		panic(err)
+	}
+	err = bindSource(queryCmd)
+	if err != nil {
+		panic(err)
+	}
+}
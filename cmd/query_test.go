@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"github.com/DrBushytop/amag/pkg/kql"
+	"testing"
+	"time"
+)
+
+func TestWriteQueryResult(t *testing.T) {
+	res := kql.QueryResult{
+		Tables: []kql.ResultTable{{
+			Name: "PrimaryResult",
+			Columns: []kql.ResultColumn{
+				{Name: "TimeGenerated", Type: "datetime"},
+				{Name: "cloud_RoleName", Type: "string"},
+				{Name: "MetricValue", Type: "real"},
+				{Name: "Tags", Type: "dynamic"},
+			},
+			Rows: [][]any{
+				{time.Date(2024, 9, 20, 10, 0, 0, 0, time.UTC), "api", 1.5, map[string]any{"env": "prod"}},
+				{nil, "web, frontend", int64(2), nil},
+			},
+		}},
+		PartialError: errors.New("partial"),
+	}
+	tests := []struct {
+		name  string
+		write func(w *bytes.Buffer) error
+		want  string
+	}{
+		{
+			name:  "table",
+			write: func(w *bytes.Buffer) error { return writeTable(w, res) },
+			want: "TimeGenerated (datetime)  cloud_RoleName (string)  MetricValue (real)  Tags (dynamic)\n" +
+				"2024-09-20T10:00:00Z      api                      1.5                 {\"env\":\"prod\"}\n" +
+				"                          web, frontend            2                   \n" +
+				"(2 rows)\n",
+		},
+		{
+			name:  "csv",
+			write: func(w *bytes.Buffer) error { return writeCSV(w, res) },
+			want: "TimeGenerated,cloud_RoleName,MetricValue,Tags\n" +
+				"2024-09-20T10:00:00Z,api,1.5,\"{\"\"env\"\":\"\"prod\"\"}\"\n" +
+				",\"web, frontend\",2,\n",
+		},
+		{
+			name:  "json",
+			write: func(w *bytes.Buffer) error { return writeJSON(w, res) },
+			want: `{
+  "tables": [
+    {
+      "name": "PrimaryResult",
+      "columns": [
+        {
+          "name": "TimeGenerated",
+          "type": "datetime"
+        },
+        {
+          "name": "cloud_RoleName",
+          "type": "string"
+        },
+        {
+          "name": "MetricValue",
+          "type": "real"
+        },
+        {
+          "name": "Tags",
+          "type": "dynamic"
+        }
+      ],
+      "rows": [
+        {
+          "MetricValue": 1.5,
+          "Tags": {
+            "env": "prod"
+          },
+          "TimeGenerated": "2024-09-20T10:00:00Z",
+          "cloud_RoleName": "api"
+        },
+        {
+          "MetricValue": 2,
+          "Tags": null,
+          "TimeGenerated": null,
+          "cloud_RoleName": "web, frontend"
+        }
+      ]
+    }
+  ],
+  "error": "partial"
+}
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			var buf bytes.Buffer
+			if err := tt.write(&buf); err != nil {
+				t.Fatalf("write error = %v", err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("write =\n%s\nwant\n%s", buf.String(), tt.want)
+			}
+		})
+	}
+}
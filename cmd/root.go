@@ -1,9 +1,13 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/DrBushytop/amag/pkg/auth"
 	"github.com/spf13/pflag"
 	"os"
+	"path/filepath"
 
 	"github.com/charmbracelet/log"
 
@@ -22,13 +26,21 @@ var rootCmd = &cobra.Command{
 	
 	The tool is designed to be used in conjunction with Azure Identity, and handles authentication using DefaultAzureCredential.
 	So in most cases, you'd be using this while logged in to Azure CLI or Azure Powershell.
+	Use --auth to select a single credential type instead: azurecli, managedidentity, workloadidentity, clientsecret,
+	clientcertificate or devicecode.
+
+	The exit code tells failures apart: 1 for other errors, 2 for invalid input, 3 when a query fails, 4 when a query returns no rows,
+	5 when a required column is missing from the result, 6 when an upload is rejected and 7 when authentication fails.
 `,
+	SilenceErrors:     true, // Errors are logged in Execute
+	PersistentPreRunE: validateFlags,
 }
 
 func Execute() {
 	err := rootCmd.Execute()
 	if err != nil {
-		os.Exit(1)
+		log.Error(err)
+		os.Exit(exitCode(err))
 	}
 }
 
@@ -37,7 +49,50 @@ func init() {
 		initConfig()
 		postInitCommands(rootCmd.Commands())
 	})
+	rootCmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return fmt.Errorf("%w: %w", ErrInvalidInput, err)
+	})
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.amag/config.yaml)")
+
+	err := bindGlobal(KeyAuth, string(auth.ModeDefault), "Credential type to authenticate with: default, azurecli, managedidentity, workloadidentity, clientsecret, clientcertificate or devicecode")
+	if err != nil {
+		panic(err)
+	}
+	err = bindGlobal(KeyCloud, string(auth.CloudPublic), "Azure cloud to authenticate in and send requests to: public, usgovernment or china")
+	if err != nil {
+		panic(err)
+	}
+	err = bindGlobal(KeyTenantID, "", "Tenant id to authenticate in. Defaults to AZURE_TENANT_ID for clientsecret and clientcertificate")
+	if err != nil {
+		panic(err)
+	}
+	err = bindGlobal(KeyClientID, "", "Client id of the app registration, or of the user-assigned identity with managedidentity. Defaults to AZURE_CLIENT_ID for clientsecret and clientcertificate")
+	if err != nil {
+		panic(err)
+	}
+	err = bindGlobal(KeyClientCertificate, "", "Path to the PEM or PKCS#12 certificate for clientcertificate. Defaults to AZURE_CLIENT_CERTIFICATE_PATH")
+	if err != nil {
+		panic(err)
+	}
+}
+
+// newCredential creates the credential selected with the global auth flags, shared by all clients of a command.
+func newCredential() (azcore.TokenCredential, error) {
+	cred, err := auth.NewCredential(auth.CredentialOptions{
+		Mode:            auth.Mode(viper.GetString(KeyAuth)),
+		Cloud:           auth.Cloud(viper.GetString(KeyCloud)),
+		TenantID:        viper.GetString(KeyTenantID),
+		ClientID:        viper.GetString(KeyClientID),
+		CertificatePath: viper.GetString(KeyClientCertificate),
+	})
+	if errors.Is(err, auth.ErrInvalidOptions) {
+		return nil, fmt.Errorf("%w: failed to create credential: %w", ErrInvalidInput, err)
+	}
+	if err != nil {
+		// Everything else comes from the environment the credential is set up from, e.g. a missing secret or certificate
+		return nil, fmt.Errorf("%w: failed to create credential: %w", auth.ErrAuthFailed, err)
+	}
+	return cred, nil
 }
 
 func postInitCommands(commands []*cobra.Command) {
@@ -62,22 +117,12 @@ func initConfig() {
 	if cfgFile != "" {
 		viper.SetConfigFile(cfgFile)
 	} else {
-		// Find home directory.
-		home, err := os.UserHomeDir()
+		configPath, err := getAmagDir()
 		if err != nil {
-			fmt.Println("Error finding home directory:", err)
+			fmt.Println("Error finding config folder:", err)
 			os.Exit(1)
 		}
 
-		configPath := home + "/.amag"
-
-		// If the folder doesn't exist, create it
-		err = os.MkdirAll(configPath, os.ModePerm)
-		if err != nil {
-			fmt.Printf("Error creating config folder: %v\n", err)
-			return
-		}
-
 		viper.AddConfigPath(configPath)
 		viper.SetConfigName("config")
 		viper.SetConfigType("yaml")
@@ -88,4 +133,22 @@ func initConfig() {
 	if err := viper.ReadInConfig(); err == nil {
 		log.Infof("Using config file: %s", viper.ConfigFileUsed())
 	}
+}
+
+// getAmagDir returns the folder amag stores its configuration and state in, creating it if it doesn't exist.
+func getAmagDir() (string, error) {
+	// Find home directory.
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("error finding home directory: %w", err)
+	}
+
+	configPath := filepath.Join(home, ".amag")
+
+	// If the folder doesn't exist, create it
+	err = os.MkdirAll(configPath, os.ModePerm)
+	if err != nil {
+		return "", fmt.Errorf("error creating config folder: %w", err)
+	}
+	return configPath, nil
 }
\ No newline at end of file
@@ -0,0 +1,149 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/DrBushytop/amag/pkg/auth"
+	"github.com/DrBushytop/amag/pkg/job"
+	"github.com/charmbracelet/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"path/filepath"
+	"strings"
+)
+
+var runCmd = &cobra.Command{
+	Use:   "run [manifest]",
+	Short: "Run all aggregation jobs listed in a manifest file",
+	Long: `Run all aggregation jobs listed in a yaml manifest file. Each job runs a KQL file against a Log Analytics workspace
+and saves the result either as a custom metric or as logs, same as the aggregate commands.
+Clients are shared between jobs that target the same workspace or destination, and a summary is printed at the end.
+
+Example manifest:
+
+defaults:
+  workspaceid: 12345678-1234-1234-1234-123456789abc
+  timespan: 1h
+jobs:
+  - name: latency-p90
+    file: ./queries/latency_p90.kql
+    sink:
+      type: metric
+      metric: LatencyP90
+      scoperesourceid: /subscriptions/.../resourceGroups/MyResourceGroup/providers/Microsoft.Compute/virtualMachines/MyVM
+  - name: availability
+    file: ./queries/availability.kql
+    timespan: 24h
+    sink:
+      type: log
+      metric: Availability
+      datacollectionendpoint: https://my-dce.westeurope-1.ingest.monitor.azure.com
+      datacollectionstreamname: Custom-Aggregates_CL
+      datacollectionruleid: dcr-12345678123412341234123456789abc
+
+Values under defaults are used for every job that doesn't set them. Query file paths are relative to the manifest file.
+
+Example usage:
+
+amag run ./jobs.yaml`,
+	Args: invalidInputArgs(cobra.ExactArgs(1)),
+	RunE: RunManifest,
+}
+
+func RunManifest(cmd *cobra.Command, args []string) error {
+	manifest, err := loadManifest(args[0])
+	if err != nil {
+		return fmt.Errorf("error loading manifest: %w", err)
+	}
+
+	cmd.SilenceUsage = true // Avoid printing usage on error generated by functions later
+
+	runner, err := newRunner()
+	if err != nil {
+		return fmt.Errorf("failed to create runner: %w", err)
+	}
+	defer closeRunner(runner)
+
+	if viper.GetBool(GetViperKey(cmd, KeyResetCheckpoint)) {
+		for _, j := range manifest.Jobs {
+			if err := runner.ResetCheckpoint(j); err != nil {
+				return err
+			}
+		}
+	}
+
+	var failed []string
+	var errs []error
+	for _, j := range manifest.Jobs {
+		log.Info("Running job", "job", j.Name, "file", j.File, "sink", j.Sink.Type)
+		res, err := runner.Run(context.Background(), j)
+		if err != nil {
+			log.Error("Job failed", "job", j.Name, "err", err)
+			failed = append(failed, j.Name)
+			errs = append(errs, fmt.Errorf("job %s: %w", j.Name, err))
+			continue
+		}
+		log.Info("Job succeeded", "job", j.Name, "window", res.Window, "windows", res.Windows, "rows", res.Rows)
+	}
+
+	summary := fmt.Sprintf("%d succeeded, %d failed", len(manifest.Jobs)-len(failed), len(failed))
+	if len(failed) > 0 {
+		return fmt.Errorf("finished running jobs: %s (%s): %w", summary, strings.Join(failed, ", "), errors.Join(errs...))
+	}
+	log.Info("Finished running jobs: " + summary)
+	return nil
+}
+
+// loadManifest loads the manifest and validates the scope resource ids of its custom metric jobs.
+func loadManifest(path string) (*job.Manifest, error) {
+	manifest, err := job.LoadManifest(path, job.WithRemoteWriteSecrets(remoteWriteSecrets()))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidInput, err)
+	}
+	for _, j := range manifest.Jobs {
+		if j.Sink.Type != job.SinkMetric {
+			continue
+		}
+		if err := validateResourceId(j.Sink.ScopeResourceID); err != nil {
+			return nil, fmt.Errorf("%w: job %s: %w", ErrInvalidInput, j.Name, err)
+		}
+	}
+	return manifest, nil
+}
+
+// newRunner creates a job runner that authenticates with the selected credential, and keeps resolved locations and
+// checkpoints in the amag folder.
+func newRunner(opts ...job.RunnerOption) (*job.Runner, error) {
+	amagDir, err := getAmagDir()
+	if err != nil {
+		return nil, err
+	}
+	cred, err := newCredential()
+	if err != nil {
+		return nil, err
+	}
+	return job.NewRunner(append([]job.RunnerOption{
+		job.WithCredential(cred),
+		job.WithCloud(auth.Cloud(viper.GetString(KeyCloud))),
+		job.WithLocationCache(filepath.Join(amagDir, "locations.json")),
+		job.WithCheckpoints(filepath.Join(amagDir, "checkpoints.json")),
+	}, opts...)...)
+}
+
+// closeRunner closes the runner when a command is done with it. Failing to flush is logged, since the results were
+// already reported.
+func closeRunner(runner *job.Runner) {
+	if err := runner.Close(); err != nil {
+		log.Warn("Failed to close runner", "err", err)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(runCmd)
+
+	err := bindBool(runCmd, KeyResetCheckpoint, "Remove the checkpoints of all jobs in the manifest before running")
+	if err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/DrBushytop/amag/pkg/job"
+	"github.com/charmbracelet/log"
+	"github.com/spf13/cobra"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve [manifest]",
+	Short: "Keep running and run the jobs of a manifest file on their schedules",
+	Long: `Keep running and run the jobs listed in a yaml manifest file on their schedules. This is meant for running amag
+in a container or as a systemd service instead of wrapping it in cron. The manifest format is the same as for the run command,
+with the addition of the following job fields:
+
+- schedule: A cron expression (e.g. "*/15 * * * *", evaluated in UTC) or a descriptor like "@hourly" or "@every 5m". Required.
+- jitter: Maximum random delay added before each run, e.g. 30s. Optional.
+
+If a run of a job is still in progress when it is scheduled again, the new run is skipped.
+The credential and clients are created once and reused for every run. The command stops on SIGINT or SIGTERM,
+cancelling runs in progress.
+
+Example usage:
+
+amag serve ./jobs.yaml`,
+	Args: invalidInputArgs(cobra.ExactArgs(1)),
+	RunE: RunServe,
+}
+
+func RunServe(cmd *cobra.Command, args []string) error {
+	manifest, err := loadManifest(args[0])
+	if err != nil {
+		return fmt.Errorf("error loading manifest: %w", err)
+	}
+
+	cmd.SilenceUsage = true // Avoid printing usage on error generated by functions later
+
+	runner, err := newRunner()
+	if err != nil {
+		return fmt.Errorf("failed to create runner: %w", err)
+	}
+	defer closeRunner(runner)
+
+	scheduler, err := job.NewScheduler(runner, manifest.Jobs)
+	if err != nil {
+		return fmt.Errorf("%w: failed to create scheduler: %w", ErrInvalidInput, err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	log.Info("Scheduler started", "jobs", len(manifest.Jobs))
+	scheduler.Run(ctx)
+	log.Info("Scheduler stopped")
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+}
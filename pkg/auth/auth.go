@@ -2,6 +2,7 @@ package auth
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
@@ -9,6 +10,9 @@ import (
 	"time"
 )
 
+// ErrAuthFailed is returned when a token could not be acquired, or when a service rejected the credential.
+var ErrAuthFailed = errors.New("authentication failed")
+
 type Client struct {
 	cred azcore.TokenCredential
 }
@@ -42,7 +46,7 @@ func (a *Client) GetAccessToken(scopes []string) (string, error) {
 	})
 
 	if err != nil {
-		return "", fmt.Errorf("GetAccessToken: failed to get token: %w", err)
+		return "", fmt.Errorf("GetAccessToken: failed to get token: %w", markAuthFailed(err))
 	}
 
 	return token.Token, nil
@@ -66,4 +70,32 @@ func WithCredentialOptions(options azidentity.DefaultAzureCredentialOptions) Aut
 		client.cred = cred
 		return nil
 	}
+}
+
+// WrapCredential returns a credential that marks all errors acquiring tokens with ErrAuthFailed.
+// Azure SDK clients using the returned credential return errors that match ErrAuthFailed when authentication fails.
+func WrapCredential(cred azcore.TokenCredential) azcore.TokenCredential {
+	if _, ok := cred.(wrappedCredential); ok {
+		return cred
+	}
+	return wrappedCredential{cred}
+}
+
+type wrappedCredential struct {
+	cred azcore.TokenCredential
+}
+
+func (w wrappedCredential) GetToken(ctx context.Context, options policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	token, err := w.cred.GetToken(ctx, options)
+	if err != nil {
+		return token, markAuthFailed(err)
+	}
+	return token, nil
+}
+
+func markAuthFailed(err error) error {
+	if errors.Is(err, ErrAuthFailed) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", ErrAuthFailed, err)
 }
\ No newline at end of file
@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"strings"
+)
+
+// Cloud selects the Azure cloud to authenticate in and send requests to.
+type Cloud string
+
+const (
+	CloudPublic       Cloud = "public"
+	CloudUSGovernment Cloud = "usgovernment"
+	CloudChina        Cloud = "china"
+)
+
+// Clouds lists the supported clouds.
+var Clouds = []Cloud{CloudPublic, CloudUSGovernment, CloudChina}
+
+// Endpoints holds the endpoints of the services used by amag in a cloud.
+type Endpoints struct {
+	// Configuration holds the authority host, and the endpoints and audiences the Azure SDK clients read from their
+	// registered service configurations.
+	Configuration cloud.Configuration
+	// ResourceManager is the base URL of the Azure Resource Manager API.
+	ResourceManager string
+	// MetricsIngestionDomain is the domain of the regional custom metrics endpoints, <region>.<domain>.
+	MetricsIngestionDomain string
+	// AppInsightsAPI is the base URL of the Application Insights REST API, used to query classic Application Insights apps.
+	AppInsightsAPI string
+}
+
+// ResourceManagerScope returns the token scope of the Azure Resource Manager API.
+func (e Endpoints) ResourceManagerScope() string {
+	return e.ResourceManager + "/.default"
+}
+
+// MetricsIngestionScope returns the token scope of the custom metrics API.
+func (e Endpoints) MetricsIngestionScope() string {
+	return fmt.Sprintf("https://%s/", e.MetricsIngestionDomain)
+}
+
+// AppInsightsScope returns the token scope of the Application Insights REST API.
+func (e Endpoints) AppInsightsScope() string {
+	return e.AppInsightsAPI + "/.default"
+}
+
+// MetricsIngestionURL returns the base URL of the custom metrics API in the given region.
+func (e Endpoints) MetricsIngestionURL(location string) string {
+	return fmt.Sprintf("https://%s.%s", location, e.MetricsIngestionDomain)
+}
+
+// Endpoints returns the endpoints of the cloud. An empty cloud is the public cloud.
+func (c Cloud) Endpoints() (Endpoints, error) {
+	switch Cloud(strings.ToLower(string(c))) {
+	case "", CloudPublic:
+		return Endpoints{
+			Configuration:          cloud.AzurePublic,
+			ResourceManager:        "https://management.azure.com",
+			MetricsIngestionDomain: "monitoring.azure.com",
+			AppInsightsAPI:         "https://api.applicationinsights.io",
+		}, nil
+	case CloudUSGovernment:
+		return Endpoints{
+			Configuration:          cloud.AzureGovernment,
+			ResourceManager:        "https://management.usgovcloudapi.net",
+			MetricsIngestionDomain: "monitoring.azure.us",
+			AppInsightsAPI:         "https://api.applicationinsights.us",
+		}, nil
+	case CloudChina:
+		return Endpoints{
+			Configuration:          cloud.AzureChina,
+			ResourceManager:        "https://management.chinacloudapi.cn",
+			MetricsIngestionDomain: "monitoring.azure.cn",
+			AppInsightsAPI:         "https://api.applicationinsights.azure.cn",
+		}, nil
+	default:
+		return Endpoints{}, fmt.Errorf("unknown cloud %q, must be one of %v", c, Clouds)
+	}
+}
@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"testing"
+)
+
+func TestCloudEndpoints(t *testing.T) {
+	tests := []struct {
+		name             string
+		cloud            Cloud
+		wantAuthority    string
+		wantARMScope     string
+		wantMetricsURL   string
+		wantMetricsScope string
+		wantAppInsights  string
+		wantErr          bool
+	}{
+		{
+			name:             "default is public",
+			wantAuthority:    "https://login.microsoftonline.com/",
+			wantARMScope:     "https://management.azure.com/.default",
+			wantMetricsURL:   "https://westeurope.monitoring.azure.com",
+			wantMetricsScope: "https://monitoring.azure.com/",
+			wantAppInsights:  "https://api.applicationinsights.io/.default",
+		},
+		{
+			name:             "us government",
+			cloud:            CloudUSGovernment,
+			wantAuthority:    "https://login.microsoftonline.us/",
+			wantARMScope:     "https://management.usgovcloudapi.net/.default",
+			wantMetricsURL:   "https://westeurope.monitoring.azure.us",
+			wantMetricsScope: "https://monitoring.azure.us/",
+			wantAppInsights:  "https://api.applicationinsights.us/.default",
+		},
+		{
+			name:             "china is case insensitive",
+			cloud:            "China",
+			wantAuthority:    "https://login.chinacloudapi.cn/",
+			wantARMScope:     "https://management.chinacloudapi.cn/.default",
+			wantMetricsURL:   "https://westeurope.monitoring.azure.cn",
+			wantMetricsScope: "https://monitoring.azure.cn/",
+			wantAppInsights:  "https://api.applicationinsights.azure.cn/.default",
+		},
+		{
+			name:    "unknown cloud",
+			cloud:   "germany",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := tt.cloud.Endpoints()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Endpoints() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.Configuration.ActiveDirectoryAuthorityHost != tt.wantAuthority {
+				t.Errorf("Endpoints() authority = %s, want %s", got.Configuration.ActiveDirectoryAuthorityHost, tt.wantAuthority)
+			}
+			if got.ResourceManagerScope() != tt.wantARMScope {
+				t.Errorf("ResourceManagerScope() = %s, want %s", got.ResourceManagerScope(), tt.wantARMScope)
+			}
+			if got.MetricsIngestionURL("westeurope") != tt.wantMetricsURL {
+				t.Errorf("MetricsIngestionURL() = %s, want %s", got.MetricsIngestionURL("westeurope"), tt.wantMetricsURL)
+			}
+			if got.MetricsIngestionScope() != tt.wantMetricsScope {
+				t.Errorf("MetricsIngestionScope() = %s, want %s", got.MetricsIngestionScope(), tt.wantMetricsScope)
+			}
+			if got.AppInsightsScope() != tt.wantAppInsights {
+				t.Errorf("AppInsightsScope() = %s, want %s", got.AppInsightsScope(), tt.wantAppInsights)
+			}
+		})
+	}
+}
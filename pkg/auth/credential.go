@@ -0,0 +1,143 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Mode selects the type of credential used to authenticate.
+type Mode string
+
+const (
+	ModeDefault           Mode = "default"
+	ModeAzureCLI          Mode = "azurecli"
+	ModeManagedIdentity   Mode = "managedidentity"
+	ModeWorkloadIdentity  Mode = "workloadidentity"
+	ModeClientSecret      Mode = "clientsecret"
+	ModeClientCertificate Mode = "clientcertificate"
+	ModeDeviceCode        Mode = "devicecode"
+)
+
+// Modes lists the supported authentication modes.
+var Modes = []Mode{ModeDefault, ModeAzureCLI, ModeManagedIdentity, ModeWorkloadIdentity, ModeClientSecret, ModeClientCertificate, ModeDeviceCode}
+
+// ErrInvalidOptions is returned by NewCredential when the mode or cloud of the options is unknown, as opposed to errors
+// setting up the credential from its environment.
+var ErrInvalidOptions = errors.New("invalid credential options")
+
+// Environment variables read for settings that are not given in CredentialOptions. These are the same variables
+// DefaultAzureCredential reads, so switching from the default mode doesn't need any new configuration.
+const (
+	envTenantID                  = "AZURE_TENANT_ID"
+	envClientID                  = "AZURE_CLIENT_ID"
+	envClientSecret              = "AZURE_CLIENT_SECRET"
+	envClientCertificatePath     = "AZURE_CLIENT_CERTIFICATE_PATH"
+	envClientCertificatePassword = "AZURE_CLIENT_CERTIFICATE_PASSWORD"
+)
+
+// CredentialOptions configures the credential created by NewCredential.
+//
+// TenantID, ClientID and CertificatePath fall back to the AZURE_TENANT_ID, AZURE_CLIENT_ID and AZURE_CLIENT_CERTIFICATE_PATH
+// environment variables. Secrets are only read from AZURE_CLIENT_SECRET and AZURE_CLIENT_CERTIFICATE_PASSWORD,
+// so that they don't end up in shell history or config files.
+type CredentialOptions struct {
+	Mode Mode
+	// Cloud selects the authority host to authenticate with. Defaults to the public cloud.
+	Cloud           Cloud
+	TenantID        string
+	ClientID        string
+	CertificatePath string
+}
+
+// NewCredential creates a credential of the given mode. An empty mode creates a DefaultAzureCredential.
+//
+// For managedidentity, ClientID selects a user-assigned identity, otherwise the system-assigned identity is used.
+// For workloadidentity, TenantID and ClientID override the values injected by the AKS workload identity webhook.
+func NewCredential(opts CredentialOptions) (azcore.TokenCredential, error) {
+	tenantID := valueOrEnv(opts.TenantID, envTenantID)
+	clientID := valueOrEnv(opts.ClientID, envClientID)
+	endpoints, err := opts.Cloud.Endpoints()
+	if err != nil {
+		return nil, fmt.Errorf("NewCredential: %w: %w", ErrInvalidOptions, err)
+	}
+	clientOptions := azcore.ClientOptions{Cloud: endpoints.Configuration}
+
+	switch Mode(strings.ToLower(string(opts.Mode))) {
+	case "", ModeDefault:
+		return azidentity.NewDefaultAzureCredential(&azidentity.DefaultAzureCredentialOptions{ClientOptions: clientOptions, TenantID: opts.TenantID})
+	case ModeAzureCLI:
+		// The Azure CLI authenticates in the cloud selected with az cloud set
+		return azidentity.NewAzureCLICredential(&azidentity.AzureCLICredentialOptions{TenantID: opts.TenantID})
+	case ModeManagedIdentity:
+		options := azidentity.ManagedIdentityCredentialOptions{ClientOptions: clientOptions}
+		// Only an explicitly given client id selects a user-assigned identity, AZURE_CLIENT_ID may belong to another mode
+		if opts.ClientID != "" {
+			options.ID = azidentity.ClientID(opts.ClientID)
+		}
+		return azidentity.NewManagedIdentityCredential(&options)
+	case ModeWorkloadIdentity:
+		return azidentity.NewWorkloadIdentityCredential(&azidentity.WorkloadIdentityCredentialOptions{
+			ClientOptions: clientOptions,
+			TenantID:      opts.TenantID,
+			ClientID:      opts.ClientID,
+		})
+	case ModeClientSecret:
+		secret := os.Getenv(envClientSecret)
+		if err := requireSettings(opts.Mode, map[string]string{"tenant id": tenantID, "client id": clientID, envClientSecret: secret}); err != nil {
+			return nil, err
+		}
+		return azidentity.NewClientSecretCredential(tenantID, clientID, secret, &azidentity.ClientSecretCredentialOptions{ClientOptions: clientOptions})
+	case ModeClientCertificate:
+		certPath := valueOrEnv(opts.CertificatePath, envClientCertificatePath)
+		if err := requireSettings(opts.Mode, map[string]string{"tenant id": tenantID, "client id": clientID, "certificate path": certPath}); err != nil {
+			return nil, err
+		}
+		data, err := os.ReadFile(certPath)
+		if err != nil {
+			return nil, fmt.Errorf("NewCredential: failed to read certificate: %w", err)
+		}
+		var password []byte
+		if p := os.Getenv(envClientCertificatePassword); p != "" {
+			password = []byte(p)
+		}
+		certs, key, err := azidentity.ParseCertificates(data, password)
+		if err != nil {
+			return nil, fmt.Errorf("NewCredential: failed to parse certificate %s: %w", certPath, err)
+		}
+		return azidentity.NewClientCertificateCredential(tenantID, clientID, certs, key, &azidentity.ClientCertificateCredentialOptions{ClientOptions: clientOptions})
+	case ModeDeviceCode:
+		return azidentity.NewDeviceCodeCredential(&azidentity.DeviceCodeCredentialOptions{
+			ClientOptions: clientOptions,
+			TenantID:      opts.TenantID,
+			ClientID:      opts.ClientID,
+		})
+	default:
+		return nil, fmt.Errorf("NewCredential: %w: unknown auth mode %q, must be one of %v", ErrInvalidOptions, opts.Mode, Modes)
+	}
+}
+
+func valueOrEnv(value string, envName string) string {
+	if value != "" {
+		return value
+	}
+	return os.Getenv(envName)
+}
+
+func requireSettings(mode Mode, settings map[string]string) error {
+	var missing []string
+	for name, value := range settings {
+		if value == "" {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		slices.Sort(missing)
+		return fmt.Errorf("NewCredential: auth mode %s requires %s", mode, strings.Join(missing, ", "))
+	}
+	return nil
+}
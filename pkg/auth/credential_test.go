@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNewCredential(t *testing.T) {
+	// Make the tests independent of the environment they run in
+	for _, env := range []string{envTenantID, envClientID, envClientSecret, envClientCertificatePath, envClientCertificatePassword} {
+		t.Setenv(env, "")
+	}
+
+	tests := []struct {
+		name    string
+		opts    CredentialOptions
+		wantErr bool
+		// wantInvalidOptions is set if the error must match ErrInvalidOptions
+		wantInvalidOptions bool
+	}{
+		{name: "default", opts: CredentialOptions{}},
+		{name: "azure cli", opts: CredentialOptions{Mode: ModeAzureCLI}},
+		{name: "mode is case insensitive", opts: CredentialOptions{Mode: "AzureCLI"}},
+		{name: "system-assigned managed identity", opts: CredentialOptions{Mode: ModeManagedIdentity}},
+		{name: "user-assigned managed identity", opts: CredentialOptions{Mode: ModeManagedIdentity, ClientID: "00000000-0000-0000-0000-000000000000"}},
+		{name: "device code", opts: CredentialOptions{Mode: ModeDeviceCode}},
+		{name: "client secret without secret", opts: CredentialOptions{Mode: ModeClientSecret, TenantID: "tenant", ClientID: "client"}, wantErr: true},
+		{name: "client certificate without certificate", opts: CredentialOptions{Mode: ModeClientCertificate, TenantID: "tenant", ClientID: "client"}, wantErr: true},
+		{name: "client certificate file missing", opts: CredentialOptions{Mode: ModeClientCertificate, TenantID: "tenant", ClientID: "client", CertificatePath: "missing.pem"}, wantErr: true},
+		{name: "us government", opts: CredentialOptions{Mode: ModeManagedIdentity, Cloud: CloudUSGovernment}},
+		{name: "unknown cloud", opts: CredentialOptions{Cloud: "germany"}, wantErr: true, wantInvalidOptions: true},
+		{name: "unknown mode", opts: CredentialOptions{Mode: "password"}, wantErr: true, wantInvalidOptions: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cred, err := NewCredential(tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewCredential() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if errors.Is(err, ErrInvalidOptions) != tt.wantInvalidOptions {
+				t.Errorf("NewCredential() error = %v, wantInvalidOptions %v", err, tt.wantInvalidOptions)
+			}
+			if !tt.wantErr && cred == nil {
+				t.Errorf("NewCredential() returned nil credential")
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package job
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+)
+
+// CheckpointStore persists the high-water mark of each incremental job, i.e. the end of the last window that was
+// saved successfully, in a json file.
+type CheckpointStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewCheckpointStore(path string) *CheckpointStore {
+	return &CheckpointStore{path: path}
+}
+
+// Get returns the high-water mark of the given key. The second return value is false if there is no checkpoint for the key.
+func (s *CheckpointStore) Get(key string) (time.Time, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	checkpoints, err := s.read()
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	mark, ok := checkpoints[key]
+	return mark, ok, nil
+}
+
+// Set stores the high-water mark of the given key.
+func (s *CheckpointStore) Set(key string, mark time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	checkpoints, err := s.read()
+	if err != nil {
+		return err
+	}
+	checkpoints[key] = mark.UTC()
+	return s.write(checkpoints)
+}
+
+// Delete removes the checkpoint of the given key, so that the next run starts from the configured time window again.
+func (s *CheckpointStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	checkpoints, err := s.read()
+	if err != nil {
+		return err
+	}
+	if _, ok := checkpoints[key]; !ok {
+		return nil
+	}
+	delete(checkpoints, key)
+	return s.write(checkpoints)
+}
+
+func (s *CheckpointStore) read() (map[string]time.Time, error) {
+	checkpoints := map[string]time.Time{}
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return checkpoints, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoints: %w", err)
+	}
+	if err := json.Unmarshal(data, &checkpoints); err != nil {
+		return nil, fmt.Errorf("failed to parse checkpoints in %s: %w", s.path, err)
+	}
+	return checkpoints, nil
+}
+
+func (s *CheckpointStore) write(checkpoints map[string]time.Time) error {
+	data, err := json.MarshalIndent(checkpoints, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal checkpoints: %w", err)
+	}
+
+	// Write to a temporary file first, so that a crash doesn't leave a truncated file behind
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write checkpoints: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to write checkpoints: %w", err)
+	}
+	return nil
+}
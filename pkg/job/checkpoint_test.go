@@ -0,0 +1,35 @@
+package job
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCheckpointStore(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "checkpoints.json")
+	mark := time.Date(2024, 9, 20, 10, 0, 0, 0, time.UTC)
+
+	store := NewCheckpointStore(path)
+	if _, ok, err := store.Get("metric/latency"); err != nil || ok {
+		t.Fatalf("Get() on empty store = %v, %v, want false, nil", ok, err)
+	}
+
+	if err := store.Set("metric/latency", mark); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	// A new store reads the checkpoint persisted by the previous one
+	got, ok, err := NewCheckpointStore(path).Get("metric/latency")
+	if err != nil || !ok || !got.Equal(mark) {
+		t.Fatalf("Get() = %v, %v, %v, want %v, true, nil", got, ok, err, mark)
+	}
+
+	if err := store.Delete("metric/latency"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, ok, err := store.Get("metric/latency"); err != nil || ok {
+		t.Fatalf("Get() after Delete() = %v, %v, want false, nil", ok, err)
+	}
+}
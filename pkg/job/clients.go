@@ -0,0 +1,129 @@
+package job
+
+import (
+	"context"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/monitor/azquery"
+	"github.com/DrBushytop/amag/pkg/auth"
+	"github.com/DrBushytop/amag/pkg/kql"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+// queryClient runs the queries of jobs against a source, see kql.WorkspaceClient.
+type queryClient interface {
+	QueryWorkspaceForAggregateValue(ctx context.Context, body azquery.Body, options *azquery.LogsClientQueryWorkspaceOptions) ([]kql.LogLine, error)
+	QueryWorkspaceForAggregateValueWithColumns(ctx context.Context, body azquery.Body, options *azquery.LogsClientQueryWorkspaceOptions) ([]kql.LogLine, error)
+}
+
+// logsUploader saves log entries to Log Analytics, see kql.LogsClient.
+type logsUploader interface {
+	UploadURL() string
+	SaveLogEntryToLogAnalytics(ctx context.Context, entry []kql.AggregateLogEntry) error
+	SaveRowsToLogAnalytics(ctx context.Context, rows []map[string]any) error
+}
+
+// metricsSender saves custom metrics, see kql.CustomMetricsClient.
+type metricsSender interface {
+	MetricsURL(scopeResourceId string, location string) string
+	SendCustomMetrics(ctx context.Context, scopeResourceId string, location string, body kql.CustomMetricBody) error
+}
+
+// remoteWriter saves Prometheus samples, see kql.RemoteWriteClient.
+type remoteWriter interface {
+	Write(ctx context.Context, req kql.RemoteWriteRequest) error
+}
+
+// otlpExporter exports OpenTelemetry metrics, see kql.OTLPClient.
+type otlpExporter interface {
+	URL() string
+	Export(ctx context.Context, metrics *metricdata.ResourceMetrics) error
+	Shutdown(ctx context.Context) error
+}
+
+// clientFactory creates the clients the runner queries sources and saves to sinks with. The runner creates each client
+// only once per target.
+type clientFactory interface {
+	newQueryClient(j Job, targets []string) (queryClient, error)
+	newLogsUploader(target logsTarget) (logsUploader, error)
+	newMetricsSender() (metricsSender, error)
+	newRemoteWriter(target remoteWriteTarget) (remoteWriter, error)
+	newOTLPExporter(ctx context.Context, target otlpTarget) (otlpExporter, error)
+}
+
+// kqlClients creates the clients of package kql, authenticating with the credential of the runner.
+type kqlClients struct {
+	cred       azcore.TokenCredential
+	cloud      auth.Cloud
+	authClient *auth.Client
+}
+
+func (c kqlClients) newQueryClient(j Job, targets []string) (queryClient, error) {
+	return newWorkspaceClient(j, targets, c.cred, c.cloud)
+}
+
+// NewQueryClient creates the client that runs the queries of the job against its source, without a runner, e.g. to
+// print query results.
+func NewQueryClient(j Job, cred azcore.TokenCredential, cloud auth.Cloud) (*kql.WorkspaceClient, error) {
+	targets, err := queryTargets(j)
+	if err != nil {
+		return nil, fmt.Errorf("NewQueryClient: %w", err)
+	}
+	c, err := newWorkspaceClient(j, targets, auth.WrapCredential(cred), cloud)
+	if err != nil {
+		return nil, fmt.Errorf("NewQueryClient: %w", err)
+	}
+	return c, nil
+}
+
+// newWorkspaceClient creates the query client of the source of the job, querying the given targets.
+func newWorkspaceClient(j Job, targets []string, cred azcore.TokenCredential, cloud auth.Cloud) (*kql.WorkspaceClient, error) {
+	newClient := kql.NewWorkspaceClient
+	switch j.SourceType() {
+	case SourceAppInsights:
+		newClient = kql.NewAppInsightsClient
+	case SourceResource:
+		newClient = kql.NewResourceQueryClient
+	case SourceADX:
+		newClient = func(database string, opts ...kql.WsOption) (*kql.WorkspaceClient, error) {
+			return kql.NewADXClient(j.Source.Cluster, database, opts...)
+		}
+	case SourceResourceGraph:
+		newClient = func(subscriptionId string, opts ...kql.WsOption) (*kql.WorkspaceClient, error) {
+			return kql.NewResourceGraphClient(subscriptionId, kql.ParseWorkspaceIDs(j.Source.ManagementGroups), opts...)
+		}
+	}
+	var primary string
+	var additional []string
+	if len(targets) > 0 {
+		primary, additional = targets[0], targets[1:]
+	}
+	return newClient(
+		primary,
+		kql.WithAdditionalWorkspaces(additional...),
+		kql.WithCredential(cred),
+		kql.WithCloud(cloud),
+	)
+}
+
+func (c kqlClients) newLogsUploader(target logsTarget) (logsUploader, error) {
+	return kql.NewLogsClient(target.streamName, target.endpoint, target.ruleId, kql.WithIngestCredential(c.cred), kql.WithIngestCloud(c.cloud))
+}
+
+func (c kqlClients) newMetricsSender() (metricsSender, error) {
+	return kql.NewCustomMetricsClient(kql.WithAuthClient(c.authClient), kql.WithCustomMetricsCloud(c.cloud))
+}
+
+func (c kqlClients) newRemoteWriter(target remoteWriteTarget) (remoteWriter, error) {
+	var opts []kql.RemoteWriteOption
+	if target.username != "" {
+		opts = append(opts, kql.WithBasicAuth(target.username, target.password))
+	} else if target.bearerToken != "" {
+		opts = append(opts, kql.WithBearerToken(target.bearerToken))
+	}
+	return kql.NewRemoteWriteClient(target.url, opts...)
+}
+
+func (c kqlClients) newOTLPExporter(ctx context.Context, target otlpTarget) (otlpExporter, error) {
+	return kql.NewOTLPClient(ctx, kql.OTLPProtocol(target.protocol), target.endpoint)
+}
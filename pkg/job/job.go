@@ -0,0 +1,435 @@
+package job
+
+import (
+	"errors"
+	"fmt"
+	"github.com/DrBushytop/amag/pkg/kql"
+	"io/fs"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+type SinkType string
+
+type SourceType string
+
+const (
+	SourceWorkspace     SourceType = "workspace"
+	SourceAppInsights   SourceType = "appinsights"
+	SourceResource      SourceType = "resource"
+	SourceADX           SourceType = "adx"
+	SourceResourceGraph SourceType = "resourcegraph"
+)
+
+const (
+	SinkMetric     SinkType = "metric"
+	SinkLog        SinkType = "log"
+	SinkPrometheus SinkType = "prometheus"
+	SinkOTLP       SinkType = "otlp"
+)
+
+// Job describes a single aggregation: the query to run, where to run it and where to save the result.
+type Job struct {
+	Name        string `yaml:"name"`
+	File        string `yaml:"file"`
+	WorkspaceID string `yaml:"workspaceid"`
+	Timespan    string `yaml:"timespan"`
+	Start       string `yaml:"start"`
+	End         string `yaml:"end"`
+	Align       string `yaml:"align"`
+	Sink        Sink   `yaml:"sink"`
+	// Source selects what the query is run against. Defaults to the workspaces in WorkspaceID.
+	Source Source `yaml:"source"`
+
+	// WorkspaceID can be a comma separated list of workspace ids. Multiple workspaces are queried as a union, or with
+	// SplitWorkspaces each separately, adding the workspace id to each row as the WorkspaceId dimension. The dimension is
+	// kept even if Sink.Dimensions doesn't list it. Log sinks need Passthrough to save it.
+	SplitWorkspaces bool `yaml:"splitworkspaces"`
+
+	// Params fill the template placeholders of the query file, see kql.RenderQuery. With DeclareParams, the parameters are
+	// also declared as KQL query parameters, so that the query can refer to them by name.
+	Params        map[string]string `yaml:"params"`
+	DeclareParams bool              `yaml:"declareparams"`
+
+	// Incremental jobs query from the end of their last successful run instead of the configured window.
+	// See Runner.Run for details.
+	Incremental bool `yaml:"incremental"`
+
+	// Schedule and Jitter are only used by the scheduler. Schedule is a cron expression or a descriptor like "@every 5m",
+	// and Jitter is the maximum random delay added before each scheduled run.
+	Schedule string `yaml:"schedule"`
+	Jitter   string `yaml:"jitter"`
+}
+
+// Source describes what the query of a job is run against, when it is not a Log Analytics workspace.
+// If Type is not set, it is selected by which of the other fields is set.
+type Source struct {
+	Type SourceType `yaml:"type"`
+	// AppID is the app id of a classic Application Insights app, or a comma separated list of them, queried through
+	// the Application Insights REST API.
+	AppID string `yaml:"appid"`
+	// ResourceID is the id of an Azure resource whose logs are queried, e.g. a workspace based Application Insights component.
+	ResourceID string `yaml:"resourceid"`
+	// Cluster is the URI of an Azure Data Explorer cluster, e.g. https://mycluster.westeurope.kusto.windows.net,
+	// and Database the database in it to run the query in.
+	Cluster  string `yaml:"cluster"`
+	Database string `yaml:"database"`
+	// Subscriptions and ManagementGroups are comma separated lists of the scopes Azure Resource Graph queries run in.
+	// Without either, all subscriptions the credential can read are queried.
+	Subscriptions    string `yaml:"subscriptions"`
+	ManagementGroups string `yaml:"managementgroups"`
+}
+
+// Sink describes where the result of a job is saved. Only the fields relevant to the sink type need to be set.
+type Sink struct {
+	Type   SinkType `yaml:"type"`
+	Metric string   `yaml:"metric"`
+	// Unit is saved with log entries and OTLP metrics. Custom metrics and Prometheus samples have no unit.
+	Unit string `yaml:"unit"`
+
+	// Custom metric sink
+	ScopeResourceID string `yaml:"scoperesourceid"`
+	Location        string `yaml:"location"`
+	// Namespace of the custom metric. Defaults to CustomMetrics.
+	Namespace string `yaml:"namespace"`
+	// Dimensions limits the columns saved as dimensions of the custom metric to the given ones, in the given order.
+	// By default every column other than TimeGenerated and the MetricValue, MetricMin, MetricMax, MetricSum and
+	// MetricCount columns is a dimension, numeric ones included.
+	Dimensions []string `yaml:"dimensions"`
+
+	// Log sink
+	DataCollectionEndpoint   string `yaml:"datacollectionendpoint"`
+	DataCollectionStreamName string `yaml:"datacollectionstreamname"`
+	DataCollectionRuleID     string `yaml:"datacollectionruleid"`
+	// Passthrough saves all columns of the query result instead of only Name and Value.
+	Passthrough bool `yaml:"passthrough"`
+
+	// Prometheus sink. Metric is the metric name, and the dimensions are saved as labels. Username enables basic auth
+	// with Password. Without it, BearerToken is sent as bearer token if set. The secrets can't be set in manifests, they
+	// are passed in by the caller, see WithRemoteWriteSecrets.
+	RemoteWriteURL string `yaml:"remotewriteurl"`
+	Username       string `yaml:"username"`
+	Password       string `yaml:"-"`
+	BearerToken    string `yaml:"-"`
+
+	// OTLP sink. Metric is exported as a gauge, with the dimensions as data point attributes and Attributes as resource
+	// attributes. OTLPProtocol is http/protobuf (default) or grpc. Without OTLPEndpoint, the standard OTEL_EXPORTER_OTLP_*
+	// environment variables are used, which also hold the headers.
+	OTLPEndpoint string            `yaml:"otlpendpoint"`
+	OTLPProtocol string            `yaml:"otlpprotocol"`
+	Attributes   map[string]string `yaml:"attributes"`
+}
+
+// Validate checks that all the fields required by the job and its sink type are set, and that incremental jobs filter on
+// the time window if their source requires it, see ValidateTimeFilter.
+func (j Job) Validate() error {
+	var missing []string
+	if j.File == "" {
+		missing = append(missing, "file")
+	}
+	sourceMissing, err := j.missingSourceFields()
+	if err != nil {
+		return err
+	}
+	missing = append(missing, sourceMissing...)
+	if j.Sink.Metric == "" {
+		missing = append(missing, "sink.metric")
+	}
+
+	switch j.Sink.Type {
+	case SinkMetric:
+		if j.Sink.ScopeResourceID == "" {
+			missing = append(missing, "sink.scoperesourceid")
+		}
+	case SinkLog:
+		if j.Sink.DataCollectionEndpoint == "" {
+			missing = append(missing, "sink.datacollectionendpoint")
+		}
+		if j.Sink.DataCollectionStreamName == "" {
+			missing = append(missing, "sink.datacollectionstreamname")
+		}
+		if j.Sink.DataCollectionRuleID == "" {
+			missing = append(missing, "sink.datacollectionruleid")
+		}
+	case SinkPrometheus:
+		if j.Sink.RemoteWriteURL == "" {
+			missing = append(missing, "sink.remotewriteurl")
+		}
+		if j.Sink.Username != "" && j.Sink.Password == "" {
+			return fmt.Errorf("job %s: sink.username %s requires a password for basic auth", j.Name, j.Sink.Username)
+		}
+	case SinkOTLP:
+		switch kql.OTLPProtocol(strings.ToLower(j.Sink.OTLPProtocol)) {
+		case "", kql.OTLPProtocolHTTP, kql.OTLPProtocolGRPC:
+		default:
+			return fmt.Errorf("job %s: unknown otlp protocol %q, expected %q or %q", j.Name, j.Sink.OTLPProtocol, kql.OTLPProtocolHTTP, kql.OTLPProtocolGRPC)
+		}
+	default:
+		return fmt.Errorf("job %s: unknown sink type %q, expected %q, %q, %q or %q", j.Name, j.Sink.Type, SinkMetric, SinkLog, SinkPrometheus, SinkOTLP)
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("job %s: missing required fields: %s", j.Name, strings.Join(missing, ", "))
+	}
+	for _, name := range slices.Sorted(maps.Keys(j.Params)) {
+		if err := kql.ValidateParamName(name); err != nil {
+			return fmt.Errorf("job %s: %w", j.Name, err)
+		}
+	}
+	if j.SplitWorkspaces && j.Sink.Type == SinkLog && !j.Sink.Passthrough {
+		return fmt.Errorf("job %s: split workspaces require passthrough for log sinks, log entries have no column for the %s dimension", j.Name, j.splitDimension())
+	}
+	if dims := j.sinkDimensions(); j.Sink.Type == SinkMetric && len(dims) > kql.MaxCustomMetricDimensions {
+		return fmt.Errorf("job %s: custom metrics support at most %d dimensions, got %d: %s", j.Name, kql.MaxCustomMetricDimensions, len(dims), strings.Join(dims, ", "))
+	}
+	if j.Incremental {
+		return j.ValidateTimeFilter()
+	}
+	return nil
+}
+
+// ValidateTimeFilter checks that the query of a job run over time windows filters on the window itself, if its source
+// has no query timespan. Azure Data Explorer and Resource Graph queries are sent without one, so without the
+// {{ .Start }} and {{ .End }} placeholders every window of an incremental run or backfill would return the same rows.
+func (j Job) ValidateTimeFilter() error {
+	switch j.SourceType() {
+	case SourceADX, SourceResourceGraph:
+	default:
+		return nil
+	}
+	query, err := kql.ParseQuery(j.File)
+	if err != nil {
+		return fmt.Errorf("job %s: failed to read query file: %w", j.Name, err)
+	}
+	if !kql.UsesTimeWindow(query) {
+		return fmt.Errorf("job %s: %s queries have no timespan, filter on the time window with the {{ .Start }} and {{ .End }} placeholders", j.Name, j.SourceType())
+	}
+	return nil
+}
+
+// ValidateSource checks that the fields required by the source of the job are set.
+func (j Job) ValidateSource() error {
+	missing, err := j.missingSourceFields()
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("job %s: missing required fields: %s", j.Name, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+func (j Job) missingSourceFields() ([]string, error) {
+	switch j.SourceType() {
+	case SourceWorkspace:
+		if len(j.QueryTargets()) == 0 {
+			return []string{"workspaceid"}, nil
+		}
+	case SourceAppInsights:
+		if len(j.QueryTargets()) == 0 {
+			return []string{"source.appid"}, nil
+		}
+	case SourceResource:
+		if len(j.QueryTargets()) == 0 {
+			return []string{"source.resourceid"}, nil
+		}
+	case SourceADX:
+		var missing []string
+		if j.Source.Cluster == "" {
+			missing = append(missing, "source.cluster")
+		}
+		if j.Source.Database == "" {
+			missing = append(missing, "source.database")
+		}
+		return missing, nil
+	case SourceResourceGraph:
+		// All fields are optional
+	default:
+		return nil, fmt.Errorf("job %s: unknown source type %q, expected one of %q, %q, %q, %q or %q", j.Name, j.Source.Type, SourceWorkspace, SourceAppInsights, SourceResource, SourceADX, SourceResourceGraph)
+	}
+	return nil, nil
+}
+
+// SourceType returns the type of the source, or if not set, the type of the source whose fields are set.
+func (j Job) SourceType() SourceType {
+	switch {
+	case j.Source.Type != "":
+		return SourceType(strings.ToLower(string(j.Source.Type)))
+	case j.Source.AppID != "":
+		return SourceAppInsights
+	case j.Source.ResourceID != "":
+		return SourceResource
+	case j.Source.Cluster != "":
+		return SourceADX
+	case j.Source.Subscriptions != "" || j.Source.ManagementGroups != "":
+		return SourceResourceGraph
+	default:
+		return SourceWorkspace
+	}
+}
+
+// QueryTargets returns the workspace ids, app ids, the resource id, the database or the subscription ids the query is run
+// against, depending on the source type. Resource Graph queries without subscriptions have none, and are run against
+// all subscriptions the credential can read.
+func (j Job) QueryTargets() []string {
+	switch j.SourceType() {
+	case SourceAppInsights:
+		return kql.ParseWorkspaceIDs(j.Source.AppID)
+	case SourceResource:
+		if j.Source.ResourceID == "" {
+			return nil
+		}
+		return []string{j.Source.ResourceID}
+	case SourceADX:
+		if j.Source.Database == "" {
+			return nil
+		}
+		return []string{j.Source.Database}
+	case SourceResourceGraph:
+		return kql.ParseWorkspaceIDs(j.Source.Subscriptions)
+	default:
+		return kql.ParseWorkspaceIDs(j.WorkspaceID)
+	}
+}
+
+// splitDimension returns the dimension the target is added to the rows as with SplitWorkspaces, e.g. WorkspaceId.
+// It is empty if the job isn't split, or has no targets to split by.
+func (j Job) splitDimension() string {
+	if !j.SplitWorkspaces || len(j.QueryTargets()) == 0 {
+		return ""
+	}
+	switch j.SourceType() {
+	case SourceAppInsights:
+		return kql.AppIDDimension
+	case SourceResource:
+		return kql.ResourceIDDimension
+	case SourceADX:
+		return kql.DatabaseDimension
+	case SourceResourceGraph:
+		return kql.SubscriptionIDDimension
+	default:
+		return kql.WorkspaceIDDimension
+	}
+}
+
+// sinkDimensions returns the dimensions the sink selects, with the split dimension appended if they don't list it,
+// so that the rows of different targets aren't saved as the same series. Nil selects all dimensions.
+func (j Job) sinkDimensions() []string {
+	dimension := j.splitDimension()
+	if len(j.Sink.Dimensions) == 0 || dimension == "" || slices.Contains(j.Sink.Dimensions, dimension) {
+		return j.Sink.Dimensions
+	}
+	return append(slices.Clone(j.Sink.Dimensions), dimension)
+}
+
+// CheckpointKey returns the key the high-water mark of the job is stored under.
+func (j Job) CheckpointKey() string {
+	return fmt.Sprintf("%s/%s", j.Sink.Type, j.Name)
+}
+
+// WithQueryMetadata returns a copy of the job with empty fields set from the front matter of its query file.
+// See kql.QueryMetadata. A missing file is not an error here, it is reported when the job is run.
+func (j Job) WithQueryMetadata() (Job, error) {
+	if j.File == "" {
+		return j, nil
+	}
+	_, meta, err := kql.ParseQueryFile(j.File)
+	if errors.Is(err, fs.ErrNotExist) {
+		return j, nil
+	}
+	if err != nil {
+		return j, err
+	}
+
+	setDefault(&j.WorkspaceID, meta.WorkspaceID)
+	setDefault(&j.Timespan, meta.Timespan)
+	setDefault(&j.Sink.Type, SinkType(meta.Sink))
+	setDefault(&j.Sink.Metric, meta.Metric)
+	setDefault(&j.Sink.Unit, meta.Unit)
+	setDefault(&j.Sink.ScopeResourceID, meta.ScopeResourceID)
+	setDefault(&j.Sink.Namespace, meta.Namespace)
+	if len(j.Sink.Dimensions) == 0 {
+		j.Sink.Dimensions = slices.Clone(meta.Dimensions)
+	}
+	j.Sink.Attributes = mergeAttributes(meta.Attributes, j.Sink.Attributes)
+	return j, nil
+}
+
+// withDefaults returns a copy of the job with all empty fields set from defaults.
+func (j Job) withDefaults(defaults Job) Job {
+	setDefault(&j.File, defaults.File)
+	setDefault(&j.WorkspaceID, defaults.WorkspaceID)
+	setDefault(&j.Timespan, defaults.Timespan)
+	setDefault(&j.Start, defaults.Start)
+	setDefault(&j.End, defaults.End)
+	setDefault(&j.Align, defaults.Align)
+	setDefault(&j.Schedule, defaults.Schedule)
+	setDefault(&j.Jitter, defaults.Jitter)
+	if defaults.Incremental {
+		j.Incremental = true
+	}
+	if defaults.Sink.Passthrough {
+		j.Sink.Passthrough = true
+	}
+	if defaults.SplitWorkspaces {
+		j.SplitWorkspaces = true
+	}
+	if defaults.DeclareParams {
+		j.DeclareParams = true
+	}
+	if len(defaults.Params) > 0 {
+		// Parameters of the job override the default parameters of the same name
+		params := maps.Clone(defaults.Params)
+		maps.Copy(params, j.Params)
+		j.Params = params
+	}
+
+	setDefault(&j.Source.Type, defaults.Source.Type)
+	setDefault(&j.Source.AppID, defaults.Source.AppID)
+	setDefault(&j.Source.ResourceID, defaults.Source.ResourceID)
+	setDefault(&j.Source.Cluster, defaults.Source.Cluster)
+	setDefault(&j.Source.Database, defaults.Source.Database)
+	setDefault(&j.Source.Subscriptions, defaults.Source.Subscriptions)
+	setDefault(&j.Source.ManagementGroups, defaults.Source.ManagementGroups)
+
+	setDefault(&j.Sink.Type, defaults.Sink.Type)
+	setDefault(&j.Sink.Metric, defaults.Sink.Metric)
+	setDefault(&j.Sink.Unit, defaults.Sink.Unit)
+	setDefault(&j.Sink.Namespace, defaults.Sink.Namespace)
+	if len(j.Sink.Dimensions) == 0 {
+		j.Sink.Dimensions = slices.Clone(defaults.Sink.Dimensions)
+	}
+	setDefault(&j.Sink.ScopeResourceID, defaults.Sink.ScopeResourceID)
+	setDefault(&j.Sink.Location, defaults.Sink.Location)
+	setDefault(&j.Sink.DataCollectionEndpoint, defaults.Sink.DataCollectionEndpoint)
+	setDefault(&j.Sink.DataCollectionStreamName, defaults.Sink.DataCollectionStreamName)
+	setDefault(&j.Sink.DataCollectionRuleID, defaults.Sink.DataCollectionRuleID)
+	setDefault(&j.Sink.RemoteWriteURL, defaults.Sink.RemoteWriteURL)
+	setDefault(&j.Sink.Username, defaults.Sink.Username)
+	setDefault(&j.Sink.OTLPEndpoint, defaults.Sink.OTLPEndpoint)
+	setDefault(&j.Sink.OTLPProtocol, defaults.Sink.OTLPProtocol)
+	j.Sink.Attributes = mergeAttributes(defaults.Sink.Attributes, j.Sink.Attributes)
+
+	if j.Name == "" {
+		j.Name = strings.TrimSuffix(filepath.Base(j.File), filepath.Ext(j.File))
+	}
+	return j
+}
+
+// mergeAttributes returns the attributes of base overridden by the attributes of the same name in attrs.
+func mergeAttributes(base, attrs map[string]string) map[string]string {
+	if len(base) == 0 {
+		return attrs
+	}
+	merged := maps.Clone(base)
+	maps.Copy(merged, attrs)
+	return merged
+}
+
+func setDefault[T ~string](field *T, value T) {
+	if *field == "" {
+		*field = value
+	}
+}
@@ -0,0 +1,77 @@
+package job
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+)
+
+// Manifest is a list of jobs, read from a yaml file.
+//
+// Values in Defaults are used for every job that doesn't set them. Values in the front matter of the query file
+// take precedence over Defaults, but not over the job. Relative query file paths are resolved against the folder
+// of the manifest file.
+type Manifest struct {
+	Defaults Job   `yaml:"defaults"`
+	Jobs     []Job `yaml:"jobs"`
+}
+
+// ManifestOption changes each job of a manifest after the defaults are applied, before it is validated.
+type ManifestOption func(j *Job)
+
+// WithRemoteWriteSecrets sets the password and bearer token of the jobs with a prometheus sink, which can't be set in
+// the manifest file.
+func WithRemoteWriteSecrets(password string, bearerToken string) ManifestOption {
+	return func(j *Job) {
+		if j.Sink.Type != SinkPrometheus {
+			return
+		}
+		j.Sink.Password = password
+		j.Sink.BearerToken = bearerToken
+	}
+}
+
+// LoadManifest reads the manifest from the given path, applies the defaults and options to each job and validates them.
+func LoadManifest(path string, opts ...ManifestOption) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("LoadManifest: failed to read file: %w", err)
+	}
+
+	var manifest Manifest
+	if err := yaml.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("LoadManifest: failed to parse %s: %w", path, err)
+	}
+
+	if len(manifest.Jobs) == 0 {
+		return nil, fmt.Errorf("LoadManifest: no jobs found in %s", path)
+	}
+
+	baseDir := filepath.Dir(path)
+	names := map[string]bool{}
+	for i, j := range manifest.Jobs {
+		setDefault(&j.File, manifest.Defaults.File)
+		if j.File != "" && !filepath.IsAbs(j.File) {
+			j.File = filepath.Join(baseDir, j.File)
+		}
+		j, err = j.WithQueryMetadata()
+		if err != nil {
+			return nil, fmt.Errorf("LoadManifest: job %s: %w", j.Name, err)
+		}
+		j = j.withDefaults(manifest.Defaults)
+		for _, opt := range opts {
+			opt(&j)
+		}
+		if err := j.Validate(); err != nil {
+			return nil, fmt.Errorf("LoadManifest: %w", err)
+		}
+		if names[j.Name] {
+			return nil, fmt.Errorf("LoadManifest: duplicate job name %s", j.Name)
+		}
+		names[j.Name] = true
+		manifest.Jobs[i] = j
+	}
+
+	return &manifest, nil
+}
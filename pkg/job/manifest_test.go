@@ -0,0 +1,575 @@
+package job
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadManifest(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+		files    map[string]string
+		opts     []ManifestOption
+		want     []Job
+		wantErr  bool
+	}{
+		{
+			name: "defaults and relative paths",
+			manifest: `
+defaults:
+  workspaceid: ws
+  timespan: 1h
+  params:
+    Environment: prod
+    Service: api
+  sink:
+    scoperesourceid: /subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm
+jobs:
+  - file: queries/latency.kql
+    params:
+      Service: web
+    sink:
+      type: metric
+      metric: Latency
+  - name: errors
+    file: /abs/errors.kql
+    timespan: 24h
+    sink:
+      type: log
+      metric: Errors
+      datacollectionendpoint: https://dce
+      datacollectionstreamname: Custom-Aggregates_CL
+      datacollectionruleid: dcr-1
+`,
+			want: []Job{
+				{
+					Name:        "latency",
+					File:        "queries/latency.kql",
+					WorkspaceID: "ws",
+					Timespan:    "1h",
+					Params:      map[string]string{"Environment": "prod", "Service": "web"},
+					Sink: Sink{
+						Type:            SinkMetric,
+						Metric:          "Latency",
+						ScopeResourceID: "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm",
+					},
+				},
+				{
+					Name:        "errors",
+					File:        "/abs/errors.kql",
+					WorkspaceID: "ws",
+					Timespan:    "24h",
+					Params:      map[string]string{"Environment": "prod", "Service": "api"},
+					Sink: Sink{
+						Type:                     SinkLog,
+						Metric:                   "Errors",
+						ScopeResourceID:          "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm",
+						DataCollectionEndpoint:   "https://dce",
+						DataCollectionStreamName: "Custom-Aggregates_CL",
+						DataCollectionRuleID:     "dcr-1",
+					},
+				},
+			},
+		},
+		{
+			name: "query file metadata",
+			manifest: `
+defaults:
+  timespan: 24h
+  sink:
+    namespace: Default
+jobs:
+  - file: latency.kql
+    workspaceid: ws
+    sink:
+      metric: LatencyP90
+`,
+			files: map[string]string{
+				"latency.kql": `// ---
+// metric: Latency
+// namespace: Web
+// timespan: 1h
+// sink: metric
+// scoperesourceid: /subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm
+// dimensions: [cloud_RoleName]
+// ---
+requests | summarize MetricValue = avg(duration) by cloud_RoleName`,
+			},
+			want: []Job{
+				{
+					Name:        "latency",
+					File:        "latency.kql",
+					WorkspaceID: "ws",
+					Timespan:    "1h",
+					Sink: Sink{
+						Type:            SinkMetric,
+						Metric:          "LatencyP90",
+						Namespace:       "Web",
+						Dimensions:      []string{"cloud_RoleName"},
+						ScopeResourceID: "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm",
+					},
+				},
+			},
+		},
+		{
+			name: "invalid query file metadata",
+			manifest: `
+jobs:
+  - file: a.kql
+`,
+			files:   map[string]string{"a.kql": "// ---\n// metric: A\nrequests"},
+			wantErr: true,
+		},
+		{
+			name: "application insights source",
+			manifest: `
+jobs:
+  - file: /abs/requests.kql
+    source:
+      appid: app1,app2
+    sink:
+      type: log
+      metric: Requests
+      datacollectionendpoint: https://dce
+      datacollectionstreamname: Custom-Aggregates_CL
+      datacollectionruleid: dcr-1
+`,
+			want: []Job{
+				{
+					Name:   "requests",
+					File:   "/abs/requests.kql",
+					Source: Source{AppID: "app1,app2"},
+					Sink: Sink{
+						Type:                     SinkLog,
+						Metric:                   "Requests",
+						DataCollectionEndpoint:   "https://dce",
+						DataCollectionStreamName: "Custom-Aggregates_CL",
+						DataCollectionRuleID:     "dcr-1",
+					},
+				},
+			},
+		},
+		{
+			name: "missing source fields",
+			manifest: `
+jobs:
+  - file: a.kql
+    source:
+      type: resource
+    sink:
+      type: metric
+      metric: A
+      scoperesourceid: /subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm
+`,
+			wantErr: true,
+		},
+		{
+			name: "unknown source type",
+			manifest: `
+jobs:
+  - file: a.kql
+    workspaceid: ws
+    source:
+      type: cluster
+    sink:
+      type: metric
+      metric: A
+      scoperesourceid: /subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm
+`,
+			wantErr: true,
+		},
+		{
+			name: "prometheus sink",
+			manifest: `
+jobs:
+  - file: /abs/latency.kql
+    workspaceid: ws
+    sink:
+      type: prometheus
+      metric: latency_p90
+      remotewriteurl: https://prometheus.example.com/api/v1/write
+      username: amag
+`,
+			opts: []ManifestOption{WithRemoteWriteSecrets("secret", "token")},
+			want: []Job{
+				{
+					Name:        "latency",
+					File:        "/abs/latency.kql",
+					WorkspaceID: "ws",
+					Sink: Sink{
+						Type:           SinkPrometheus,
+						Metric:         "latency_p90",
+						RemoteWriteURL: "https://prometheus.example.com/api/v1/write",
+						Username:       "amag",
+						Password:       "secret",
+						BearerToken:    "token",
+					},
+				},
+			},
+		},
+		{
+			name: "prometheus sink username without password",
+			manifest: `
+jobs:
+  - file: /abs/latency.kql
+    workspaceid: ws
+    sink:
+      type: prometheus
+      metric: latency_p90
+      remotewriteurl: https://prometheus.example.com/api/v1/write
+      username: amag
+      password: secret
+`,
+			wantErr: true,
+		},
+		{
+			name: "otlp sink attributes",
+			manifest: `
+defaults:
+  sink:
+    otlpprotocol: grpc
+    attributes:
+      deployment.environment: prod
+      service.namespace: shop
+jobs:
+  - file: checkout.kql
+    workspaceid: ws
+    sink:
+      type: otlp
+      otlpendpoint: http://collector:4317
+      attributes:
+        service.namespace: web
+`,
+			files: map[string]string{
+				"checkout.kql": `// ---
+// metric: checkout.latency
+// unit: ms
+// attributes:
+//   service.name: checkout
+//   service.namespace: payments
+// ---
+requests | summarize MetricValue = avg(duration)`,
+			},
+			want: []Job{
+				{
+					Name:        "checkout",
+					File:        "checkout.kql",
+					WorkspaceID: "ws",
+					Sink: Sink{
+						Type:         SinkOTLP,
+						Metric:       "checkout.latency",
+						Unit:         "ms",
+						OTLPEndpoint: "http://collector:4317",
+						OTLPProtocol: "grpc",
+						Attributes: map[string]string{
+							"deployment.environment": "prod",
+							"service.name":           "checkout",
+							"service.namespace":      "web",
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "incremental data explorer job filtering on the window",
+			manifest: `
+jobs:
+  - file: adx.kql
+    incremental: true
+    source:
+      cluster: https://cluster.westeurope.kusto.windows.net
+      database: telemetry
+    sink:
+      type: prometheus
+      metric: A
+      remotewriteurl: http://prometheus/api/v1/write
+`,
+			files: map[string]string{"adx.kql": "Metrics | where Timestamp between ({{ .Start }} .. {{ .End }}) | summarize MetricValue = count()"},
+			want: []Job{{
+				Name:        "adx",
+				File:        "adx.kql",
+				Incremental: true,
+				Source:      Source{Cluster: "https://cluster.westeurope.kusto.windows.net", Database: "telemetry"},
+				Sink:        Sink{Type: SinkPrometheus, Metric: "A", RemoteWriteURL: "http://prometheus/api/v1/write"},
+			}},
+		},
+		{
+			name: "incremental resource graph job without window filter",
+			manifest: `
+jobs:
+  - file: vms.kql
+    incremental: true
+    source:
+      type: resourcegraph
+    sink:
+      type: prometheus
+      metric: A
+      remotewriteurl: http://prometheus/api/v1/write
+`,
+			files:   map[string]string{"vms.kql": "resources | summarize MetricValue = count()"},
+			wantErr: true,
+		},
+		{
+			name: "unknown otlp protocol",
+			manifest: `
+jobs:
+  - file: a.kql
+    workspaceid: ws
+    sink:
+      type: otlp
+      metric: A
+      otlpprotocol: http/json
+`,
+			wantErr: true,
+		},
+		{
+			name: "missing remote write url",
+			manifest: `
+jobs:
+  - file: a.kql
+    workspaceid: ws
+    sink:
+      type: prometheus
+      metric: A
+`,
+			wantErr: true,
+		},
+		{
+			name: "missing sink fields",
+			manifest: `
+jobs:
+  - file: a.kql
+    workspaceid: ws
+    sink:
+      type: log
+      metric: A
+`,
+			wantErr: true,
+		},
+		{
+			name: "unknown sink type",
+			manifest: `
+jobs:
+  - file: a.kql
+    workspaceid: ws
+    sink:
+      type: table
+      metric: A
+`,
+			wantErr: true,
+		},
+		{
+			name: "duplicate job names",
+			manifest: `
+defaults:
+  workspaceid: ws
+  sink:
+    type: metric
+    metric: A
+    scoperesourceid: /subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm
+jobs:
+  - file: a.kql
+  - file: other/a.kql
+`,
+			wantErr: true,
+		},
+		{
+			name: "invalid parameter name",
+			manifest: `
+jobs:
+  - file: a.kql
+    workspaceid: ws
+    params:
+      cloud-role: api
+    sink:
+      type: prometheus
+      metric: A
+      remotewriteurl: http://prometheus/api/v1/write
+`,
+			wantErr: true,
+		},
+		{
+			name: "split workspaces with aggregate log entries",
+			manifest: `
+jobs:
+  - file: a.kql
+    workspaceid: ws1,ws2
+    splitworkspaces: true
+    sink:
+      type: log
+      metric: A
+      datacollectionendpoint: https://dce
+      datacollectionstreamname: Custom-Aggregates_CL
+      datacollectionruleid: dcr-1
+`,
+			wantErr: true,
+		},
+		{
+			name: "split workspaces over the custom metric dimension limit",
+			manifest: `
+jobs:
+  - file: a.kql
+    workspaceid: ws1,ws2
+    splitworkspaces: true
+    sink:
+      type: metric
+      metric: A
+      scoperesourceid: /subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm
+      dimensions: [d1, d2, d3, d4, d5, d6, d7, d8, d9, d10]
+`,
+			wantErr: true,
+		},
+		{
+			name:     "no jobs",
+			manifest: `defaults: {}`,
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			dir := t.TempDir()
+			path := filepath.Join(dir, "jobs.yaml")
+			if err := os.WriteFile(path, []byte(tt.manifest), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			for name, content := range tt.files {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := LoadManifest(path, tt.opts...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadManifest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(got.Jobs) != len(tt.want) {
+				t.Fatalf("LoadManifest() got %d jobs, want %d", len(got.Jobs), len(tt.want))
+			}
+			for i, want := range tt.want {
+				if !filepath.IsAbs(want.File) {
+					want.File = filepath.Join(dir, want.File)
+				}
+				if !reflect.DeepEqual(got.Jobs[i], want) {
+					t.Errorf("LoadManifest() job %d = %+v, want %+v", i, got.Jobs[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestJobSourceType(t *testing.T) {
+	tests := []struct {
+		name        string
+		job         Job
+		wantType    SourceType
+		wantTargets []string
+	}{
+		{
+			name:        "workspace by default",
+			job:         Job{WorkspaceID: "ws1, ws2"},
+			wantType:    SourceWorkspace,
+			wantTargets: []string{"ws1", "ws2"},
+		},
+		{
+			name:        "app insights from app id",
+			job:         Job{WorkspaceID: "ws1", Source: Source{AppID: "app1"}},
+			wantType:    SourceAppInsights,
+			wantTargets: []string{"app1"},
+		},
+		{
+			name:        "resource from resource id",
+			job:         Job{Source: Source{ResourceID: "/subscriptions/sub/resourceGroups/rg/providers/microsoft.insights/components/app"}},
+			wantType:    SourceResource,
+			wantTargets: []string{"/subscriptions/sub/resourceGroups/rg/providers/microsoft.insights/components/app"},
+		},
+		{
+			name:        "data explorer from cluster",
+			job:         Job{Source: Source{Cluster: "https://cluster.westeurope.kusto.windows.net", Database: "telemetry"}},
+			wantType:    SourceADX,
+			wantTargets: []string{"telemetry"},
+		},
+		{
+			name:        "resource graph without subscriptions",
+			job:         Job{Source: Source{Type: SourceResourceGraph}},
+			wantType:    SourceResourceGraph,
+			wantTargets: nil,
+		},
+		{
+			name:        "resource graph from subscriptions",
+			job:         Job{Source: Source{Subscriptions: "sub1,sub2"}},
+			wantType:    SourceResourceGraph,
+			wantTargets: []string{"sub1", "sub2"},
+		},
+		{
+			name:        "explicit type wins",
+			job:         Job{WorkspaceID: "ws1", Source: Source{Type: "Workspace", AppID: "app1"}},
+			wantType:    SourceWorkspace,
+			wantTargets: []string{"ws1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if got := tt.job.SourceType(); got != tt.wantType {
+				t.Errorf("SourceType() = %s, want %s", got, tt.wantType)
+			}
+			if got := tt.job.QueryTargets(); !reflect.DeepEqual(got, tt.wantTargets) {
+				t.Errorf("QueryTargets() = %v, want %v", got, tt.wantTargets)
+			}
+		})
+	}
+}
+
+func TestJobSinkDimensions(t *testing.T) {
+	tests := []struct {
+		name string
+		job  Job
+		want []string
+	}{
+		{
+			name: "not split",
+			job:  Job{WorkspaceID: "ws1,ws2", Sink: Sink{Dimensions: []string{"cloud_RoleName"}}},
+			want: []string{"cloud_RoleName"},
+		},
+		{
+			name: "split workspaces appended",
+			job:  Job{WorkspaceID: "ws1,ws2", SplitWorkspaces: true, Sink: Sink{Dimensions: []string{"cloud_RoleName"}}},
+			want: []string{"cloud_RoleName", "WorkspaceId"},
+		},
+		{
+			name: "split workspaces listed",
+			job:  Job{WorkspaceID: "ws1,ws2", SplitWorkspaces: true, Sink: Sink{Dimensions: []string{"WorkspaceId", "cloud_RoleName"}}},
+			want: []string{"WorkspaceId", "cloud_RoleName"},
+		},
+		{
+			name: "split apps appended",
+			job:  Job{Source: Source{AppID: "app1,app2"}, SplitWorkspaces: true, Sink: Sink{Dimensions: []string{"cloud_RoleName"}}},
+			want: []string{"cloud_RoleName", "AppId"},
+		},
+		{
+			name: "all dimensions",
+			job:  Job{WorkspaceID: "ws1,ws2", SplitWorkspaces: true},
+			want: nil,
+		},
+		{
+			name: "resource graph without subscriptions",
+			job:  Job{Source: Source{Type: SourceResourceGraph}, SplitWorkspaces: true, Sink: Sink{Dimensions: []string{"type"}}},
+			want: []string{"type"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if got := tt.job.sinkDimensions(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("sinkDimensions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,706 @@
+package job
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/monitor/azquery"
+	"github.com/DrBushytop/amag/pkg/auth"
+	"github.com/DrBushytop/amag/pkg/kql"
+	"github.com/charmbracelet/log"
+	"golang.org/x/sync/singleflight"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Runner runs jobs. It shares one credential between all clients, and creates each client only once per target,
+// so that running many jobs doesn't authenticate or connect again for every job.
+type Runner struct {
+	cred              azcore.TokenCredential
+	cloud             auth.Cloud
+	authClient        *auth.Client
+	locationCachePath string
+	checkpoints       *CheckpointStore
+	dryRun            io.Writer
+
+	clients        clientFactory
+	mu             sync.Mutex
+	wsClients      map[string]queryClient
+	logsClients    map[logsTarget]logsUploader
+	rwClients      map[remoteWriteTarget]remoteWriter
+	otlpClients    map[otlpTarget]otlpExporter
+	cmClient       metricsSender
+	resourceClient *kql.ResourceClient
+	locations      map[string]string
+	// locationLookups dedupes concurrent lookups of the same resource, which run without holding mu
+	locationLookups singleflight.Group
+}
+
+type logsTarget struct {
+	endpoint   string
+	streamName string
+	ruleId     string
+}
+
+type remoteWriteTarget struct {
+	url         string
+	username    string
+	password    string
+	bearerToken string
+}
+
+type otlpTarget struct {
+	protocol string
+	endpoint string
+}
+
+// otlpShutdownTimeout bounds how long Close waits for the OTLP exporters to shut down.
+const otlpShutdownTimeout = 10 * time.Second
+
+// Result describes a job run. Window is the range that was saved successfully, which can consist of several windows for incremental jobs.
+type Result struct {
+	Job     string
+	Window  kql.TimeWindow
+	Windows int
+	Rows    int
+}
+
+func NewRunner(opts ...RunnerOption) (*Runner, error) {
+	r := Runner{
+		wsClients:   map[string]queryClient{},
+		logsClients: map[logsTarget]logsUploader{},
+		rwClients:   map[remoteWriteTarget]remoteWriter{},
+		otlpClients: map[otlpTarget]otlpExporter{},
+		locations:   map[string]string{},
+	}
+
+	for _, opt := range opts {
+		err := opt(&r)
+		if err != nil {
+			return nil, fmt.Errorf("NewRunner: failed to apply option: %w", err)
+		}
+	}
+
+	if r.cred == nil {
+		cred, err := auth.NewCredential(auth.CredentialOptions{Cloud: r.cloud})
+		if err != nil {
+			return nil, fmt.Errorf("NewRunner: failed to create default azure credential: %w: %w", auth.ErrAuthFailed, err)
+		}
+		r.cred = cred
+	}
+	r.cred = auth.WrapCredential(r.cred)
+
+	authClient, err := auth.NewAuthClient(auth.WithCredential(r.cred))
+	if err != nil {
+		return nil, fmt.Errorf("NewRunner: failed to create auth client: %w", err)
+	}
+	r.authClient = authClient
+	r.clients = kqlClients{cred: r.cred, cloud: r.cloud, authClient: authClient}
+
+	return &r, nil
+}
+
+type RunnerOption func(r *Runner) error
+
+func WithCredential(cred azcore.TokenCredential) RunnerOption {
+	return func(r *Runner) error {
+		r.cred = cred
+		return nil
+	}
+}
+
+// WithCloud sends all requests to the endpoints of the given cloud. The credential must authenticate in the same cloud.
+func WithCloud(cloud auth.Cloud) RunnerOption {
+	return func(r *Runner) error {
+		if _, err := cloud.Endpoints(); err != nil {
+			return err
+		}
+		r.cloud = cloud
+		return nil
+	}
+}
+
+// WithDryRun prints the target URL and payload of each save to w instead of sending them, and leaves checkpoints untouched.
+// Queries are still run, and the location of metric scope resources is still resolved.
+func WithDryRun(w io.Writer) RunnerOption {
+	return func(r *Runner) error {
+		r.dryRun = w
+		return nil
+	}
+}
+
+// WithLocationCache caches the resolved locations of custom metric scope resources in the given json file.
+func WithLocationCache(path string) RunnerOption {
+	return func(r *Runner) error {
+		r.locationCachePath = path
+		return nil
+	}
+}
+
+// WithCheckpoints stores the checkpoints of incremental jobs in the given json file.
+func WithCheckpoints(path string) RunnerOption {
+	return func(r *Runner) error {
+		r.checkpoints = NewCheckpointStore(path)
+		return nil
+	}
+}
+
+// Run runs the job over the time window configured in the job, relative to the current time.
+//
+// Incremental jobs run from their checkpoint up to the end of the configured window instead, or over the configured window
+// if they have no checkpoint yet. If the job has an alignment, the range is run in alignment sized steps, so that gaps
+// left by missed runs are caught up bin by bin. The checkpoint is moved forward after each successful step.
+func (r *Runner) Run(ctx context.Context, j Job) (Result, error) {
+	window, err := kql.ParseTimeWindow(time.Now().UTC(), j.Timespan, j.Start, j.End, j.Align)
+	if err != nil {
+		return Result{Job: j.Name}, fmt.Errorf("failed to resolve time window: %w", err)
+	}
+	if j.Incremental {
+		return r.runIncremental(ctx, j, window)
+	}
+	return r.RunWindow(ctx, j, window)
+}
+
+func (r *Runner) runIncremental(ctx context.Context, j Job, window kql.TimeWindow) (Result, error) {
+	result := Result{Job: j.Name, Window: window}
+	if r.checkpoints == nil {
+		return result, fmt.Errorf("incremental job %s requires a checkpoint store", j.Name)
+	}
+
+	key := j.CheckpointKey()
+	mark, ok, err := r.checkpoints.Get(key)
+	if err != nil {
+		return result, err
+	}
+	if ok {
+		if !mark.Before(window.End) {
+			log.Info("Job is up to date", "job", j.Name, "checkpoint", mark.Format(time.RFC3339))
+			result.Window = kql.TimeWindow{Start: mark, End: mark}
+			return result, nil
+		}
+		window.Start = mark
+		result.Window = window
+	}
+
+	var step time.Duration
+	if j.Align != "" {
+		// Already validated by ParseTimeWindow
+		step, _ = kql.ParseDuration(j.Align)
+	}
+	windows := window.Split(step)
+	if len(windows) > 1 {
+		log.Info("Catching up from checkpoint", "job", j.Name, "window", window, "steps", len(windows))
+	}
+
+	for _, w := range windows {
+		res, err := r.RunWindow(ctx, j, w)
+		result.Rows += res.Rows
+		if errors.Is(err, kql.ErrNoRows) {
+			// An empty bin is not retried, otherwise the job would be stuck on it
+			log.Warn("No rows in window, moving checkpoint forward", "job", j.Name, "window", w)
+			err = nil
+		}
+		if err != nil {
+			result.Window.End = w.Start
+			return result, fmt.Errorf("window %s: %w", w, err)
+		}
+		if r.dryRun == nil {
+			if err := r.checkpoints.Set(key, w.End); err != nil {
+				return result, fmt.Errorf("failed to save checkpoint: %w", err)
+			}
+		}
+		result.Windows++
+	}
+	return result, nil
+}
+
+// ResetCheckpoint removes the checkpoint of the job, so that its next incremental run uses the configured window again.
+func (r *Runner) ResetCheckpoint(j Job) error {
+	if r.checkpoints == nil {
+		return nil
+	}
+	if err := r.checkpoints.Delete(j.CheckpointKey()); err != nil {
+		return fmt.Errorf("failed to reset checkpoint of job %s: %w", j.Name, err)
+	}
+	return nil
+}
+
+// Backfill runs the job over consecutive step sized windows of the given range, and saves each result with the start of
+// its window as timestamp. Custom metrics older than kql.CustomMetricsMaxAge are rejected by Azure Monitor, so for the
+// metric sink such windows are skipped with a warning. Windows with no rows are skipped as well.
+func (r *Runner) Backfill(ctx context.Context, j Job, window kql.TimeWindow, step time.Duration) (Result, error) {
+	result := Result{Job: j.Name, Window: kql.TimeWindow{Start: window.Start, End: window.Start}}
+
+	windows := window.Split(step)
+	var skipped int
+	for i, w := range windows {
+		if j.Sink.Type == SinkMetric && time.Since(w.Start) > kql.CustomMetricsMaxAge {
+			log.Warn("Skipping window older than custom metrics accept", "job", j.Name, "window", w, "maxAge", kql.CustomMetricsMaxAge)
+			skipped++
+			result.Window.End = w.End
+			continue
+		}
+
+		log.Info("Backfilling window", "job", j.Name, "window", w, "step", fmt.Sprintf("%d/%d", i+1, len(windows)))
+		res, err := r.runWindow(ctx, j, w, &w.Start)
+		result.Rows += res.Rows
+		if errors.Is(err, kql.ErrNoRows) {
+			log.Warn("No rows in window", "job", j.Name, "window", w)
+			err = nil
+		}
+		if err != nil {
+			return result, fmt.Errorf("window %s: %w", w, err)
+		}
+		result.Window.End = w.End
+		result.Windows++
+	}
+
+	if skipped == len(windows) {
+		return result, fmt.Errorf("all %d windows are older than custom metrics accept (%s)", skipped, kql.CustomMetricsMaxAge)
+	}
+	if skipped > 0 {
+		log.Warn("Skipped windows older than custom metrics accept", "job", j.Name, "skipped", skipped)
+	}
+	return result, nil
+}
+
+// RunWindow runs the query of the job over the given time window and saves the result to the sink of the job.
+func (r *Runner) RunWindow(ctx context.Context, j Job, window kql.TimeWindow) (Result, error) {
+	return r.runWindow(ctx, j, window, nil)
+}
+
+// runWindow runs the job over the window. If timestamp is set, it is used as the time of the saved metric,
+// and as the OriginalTimeGenerated of log entries without TimeGenerated.
+func (r *Runner) runWindow(ctx context.Context, j Job, window kql.TimeWindow, timestamp *time.Time) (Result, error) {
+	result := Result{Job: j.Name, Window: window, Windows: 1}
+
+	query, err := kql.ParseQuery(j.File)
+	if err != nil {
+		return result, fmt.Errorf("error parsing query from file %s: %w", j.File, err)
+	}
+	query, err = kql.RenderQuery(query, j.Params, window)
+	if err != nil {
+		return result, fmt.Errorf("error rendering query from file %s: %w", j.File, err)
+	}
+	if j.DeclareParams {
+		query = kql.DeclareQueryParameters(query, j.Params)
+	}
+
+	log.Infof("Running Query over %s:\n%s", window, query)
+
+	res, err := r.query(ctx, j, azquery.Body{
+		Query:    to.Ptr(query),
+		Timespan: to.Ptr(window.TimeInterval()),
+	})
+	if err != nil {
+		return result, err
+	}
+	result.Rows = len(res)
+	if len(res) == 0 {
+		return result, kql.ErrNoRows
+	}
+
+	j.Sink.Dimensions = j.sinkDimensions()
+	switch j.Sink.Type {
+	case SinkMetric:
+		err = r.sendMetric(ctx, j.Sink, res, timestamp)
+	case SinkLog:
+		err = r.sendLog(ctx, j.Sink, res, timestamp)
+	case SinkPrometheus:
+		err = r.sendPrometheus(ctx, j.Sink, res, timestamp)
+	case SinkOTLP:
+		err = r.sendOTLP(ctx, j.Sink, res, timestamp)
+	default:
+		err = fmt.Errorf("unknown sink type %q", j.Sink.Type)
+	}
+	return result, err
+}
+
+// query runs the query against the source of the job. Multiple workspaces or apps are queried as a union, or with
+// SplitWorkspaces each separately, with the workspace or app id added to the rows as a dimension.
+func (r *Runner) query(ctx context.Context, j Job, body azquery.Body) ([]kql.LogLine, error) {
+	targets, err := queryTargets(j)
+	if err != nil {
+		return nil, err
+	}
+	// Resource Graph queries without subscriptions have no target to split by
+	if !j.SplitWorkspaces || len(targets) == 0 {
+		wsClient, err := r.workspaceClient(j, targets)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create query client: %w", err)
+		}
+		res, err := aggregate(ctx, j, wsClient, body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to aggregate %s: %w", j.SourceType(), err)
+		}
+		return res, nil
+	}
+
+	dimension := j.splitDimension()
+	var res []kql.LogLine
+	for _, target := range targets {
+		wsClient, err := r.workspaceClient(j, []string{target})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create query client: %w", err)
+		}
+		lines, err := aggregate(ctx, j, wsClient, body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to aggregate %s %s: %w", j.SourceType(), target, err)
+		}
+		log.Info("Queried source", "source", j.SourceType(), "target", target, "rows", len(lines))
+		res = append(res, kql.WithDimension(lines, dimension, target)...)
+	}
+	return res, nil
+}
+
+// aggregate runs the query with the given client. All columns of the result are only read for passthrough log sinks.
+func aggregate(ctx context.Context, j Job, wsClient queryClient, body azquery.Body) ([]kql.LogLine, error) {
+	if j.Sink.Type == SinkLog && j.Sink.Passthrough {
+		return wsClient.QueryWorkspaceForAggregateValueWithColumns(ctx, body, nil)
+	}
+	return wsClient.QueryWorkspaceForAggregateValue(ctx, body, nil)
+}
+
+// queryTargets returns the query targets of the job, or an error if its source requires targets and none are given.
+func queryTargets(j Job) ([]string, error) {
+	targets := j.QueryTargets()
+	if len(targets) == 0 && j.SourceType() != SourceResourceGraph {
+		return nil, fmt.Errorf("no %s to query given", j.SourceType())
+	}
+	return targets, nil
+}
+
+func (r *Runner) sendMetric(ctx context.Context, sink Sink, res []kql.LogLine, timestamp *time.Time) error {
+	res, err := kql.SelectDimensions(res, sink.Dimensions)
+	if err != nil {
+		return fmt.Errorf("failed to select dimensions: %w", err)
+	}
+	body, err := kql.NewCustomMetricsBody(sink.Metric, res)
+	if err != nil {
+		return fmt.Errorf("failed to create custom metrics body: %w", err)
+	}
+	if sink.Namespace != "" {
+		body.Data.BaseData.Namespace = sink.Namespace
+	}
+	if timestamp != nil {
+		body.Time = timestamp.Format(time.RFC3339)
+	}
+
+	location, err := r.location(ctx, sink)
+	if err != nil {
+		return fmt.Errorf("failed to resolve location of the scope resource: %w", err)
+	}
+
+	cmClient, err := r.customMetricsClient()
+	if err != nil {
+		return fmt.Errorf("failed to create custom metrics client: %w", err)
+	}
+
+	if r.dryRun != nil {
+		return r.printDryRun(cmClient.MetricsURL(sink.ScopeResourceID, location), body)
+	}
+
+	log.Info("Sending custom metric")
+	if err := cmClient.SendCustomMetrics(ctx, sink.ScopeResourceID, location, body); err != nil {
+		return fmt.Errorf("failed to send custom metrics: %w", err)
+	}
+
+	log.Info("Saved custom metric", "metricName", sink.Metric, "dimensions", body.Data.BaseData.DimNames, "series", len(body.Data.BaseData.Series), "scope", sink.ScopeResourceID, "location", location)
+	return nil
+}
+
+func (r *Runner) sendLog(ctx context.Context, sink Sink, res []kql.LogLine, timestamp *time.Time) error {
+	logsClient, err := r.logsClient(sink)
+	if err != nil {
+		return fmt.Errorf("failed to create logs client: %w", err)
+	}
+
+	now := time.Now()
+	if sink.Passthrough {
+		rows := make([]map[string]any, len(res))
+		for i, line := range res {
+			rows[i], err = kql.NewPassthroughLogEntry(sink.Metric, sink.Unit, line, now, originalTimeGenerated(line, timestamp))
+			if err != nil {
+				return fmt.Errorf("failed to create log entry: %w", err)
+			}
+		}
+
+		if r.dryRun != nil {
+			return r.printDryRun(logsClient.UploadURL(), rows)
+		}
+
+		log.Info("Sending log")
+		if err := logsClient.SaveRowsToLogAnalytics(ctx, rows); err != nil {
+			return fmt.Errorf("failed to send log: %w", err)
+		}
+		log.Info("Saved log", "metricName", sink.Metric, "number of entries", len(rows), "passthrough", true)
+		return nil
+	}
+
+	var ag []kql.AggregateLogEntry
+	for _, line := range res {
+		ag = append(ag, kql.AggregateLogEntry{
+			TimeGenerated:         now,
+			OriginalTimeGenerated: originalTimeGenerated(line, timestamp),
+			Name:                  sink.Metric,
+			Value:                 line.MetricValue,
+			Unit:                  sink.Unit,
+		})
+	}
+
+	if r.dryRun != nil {
+		return r.printDryRun(logsClient.UploadURL(), ag)
+	}
+
+	log.Info("Sending log")
+	if err := logsClient.SaveLogEntryToLogAnalytics(ctx, ag); err != nil {
+		return fmt.Errorf("failed to send log: %w", err)
+	}
+	log.Info("Saved log", "metricName", sink.Metric, "number of entries", len(ag))
+	return nil
+}
+
+func (r *Runner) sendPrometheus(ctx context.Context, sink Sink, res []kql.LogLine, timestamp *time.Time) error {
+	res, err := kql.SelectDimensions(res, sink.Dimensions)
+	if err != nil {
+		return fmt.Errorf("failed to select dimensions: %w", err)
+	}
+	sampleTime := time.Now()
+	if timestamp != nil {
+		sampleTime = *timestamp
+	}
+	req, err := kql.NewRemoteWriteRequest(sink.Metric, res, sampleTime)
+	if err != nil {
+		return fmt.Errorf("failed to create remote write request: %w", err)
+	}
+
+	if r.dryRun != nil {
+		return r.printDryRun(sink.RemoteWriteURL, req)
+	}
+
+	rwClient, err := r.remoteWriteClient(sink)
+	if err != nil {
+		return fmt.Errorf("failed to create remote write client: %w", err)
+	}
+
+	log.Info("Sending Prometheus remote write")
+	if err := rwClient.Write(ctx, req); err != nil {
+		return fmt.Errorf("failed to send remote write: %w", err)
+	}
+	log.Info("Saved Prometheus metric", "metricName", sink.Metric, "series", len(req.Timeseries), "url", sink.RemoteWriteURL)
+	return nil
+}
+
+func (r *Runner) sendOTLP(ctx context.Context, sink Sink, res []kql.LogLine, timestamp *time.Time) error {
+	res, err := kql.SelectDimensions(res, sink.Dimensions)
+	if err != nil {
+		return fmt.Errorf("failed to select dimensions: %w", err)
+	}
+	pointTime := time.Now()
+	if timestamp != nil {
+		pointTime = *timestamp
+	}
+	metrics, err := kql.NewOTLPMetrics(sink.Metric, sink.Unit, sink.Attributes, res, pointTime)
+	if err != nil {
+		return fmt.Errorf("failed to create otlp metrics: %w", err)
+	}
+
+	if r.dryRun != nil {
+		url, err := kql.OTLPURL(kql.OTLPProtocol(sink.OTLPProtocol), sink.OTLPEndpoint)
+		if err != nil {
+			return fmt.Errorf("failed to create otlp url: %w", err)
+		}
+		return r.printDryRun(url, kql.NewOTLPMetricsView(metrics))
+	}
+
+	otlpClient, err := r.otlpClient(ctx, sink)
+	if err != nil {
+		return fmt.Errorf("failed to create otlp client: %w", err)
+	}
+
+	log.Info("Exporting OTLP metric")
+	if err := otlpClient.Export(ctx, metrics); err != nil {
+		return fmt.Errorf("failed to export otlp metric: %w", err)
+	}
+	log.Info("Exported OTLP metric", "metricName", sink.Metric, "dataPoints", len(res), "url", otlpClient.URL())
+	return nil
+}
+
+// originalTimeGenerated returns the TimeGenerated of the line, or timestamp if the query result has no TimeGenerated column.
+func originalTimeGenerated(line kql.LogLine, timestamp *time.Time) *time.Time {
+	if line.TimeGenerated != nil {
+		return line.TimeGenerated
+	}
+	return timestamp
+}
+
+// printDryRun prints the request that would be sent in a dry run.
+func (r *Runner) printDryRun(url string, payload any) error {
+	data, err := json.MarshalIndent(payload, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal payload: %w", err)
+	}
+	if _, err := fmt.Fprintf(r.dryRun, "POST %s\n%s\n", url, data); err != nil {
+		return fmt.Errorf("failed to print payload: %w", err)
+	}
+	log.Info("Dry run, nothing was sent", "url", url)
+	return nil
+}
+
+// workspaceClient returns a client querying the first target of the job's source, and the rest as additional workspaces or apps.
+func (r *Runner) workspaceClient(j Job, targets []string) (queryClient, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := fmt.Sprintf("%s/%s/%s/%s", j.SourceType(), j.Source.Cluster, j.Source.ManagementGroups, strings.Join(targets, ","))
+	if c, ok := r.wsClients[key]; ok {
+		return c, nil
+	}
+	c, err := r.clients.newQueryClient(j, targets)
+	if err != nil {
+		return nil, err
+	}
+	r.wsClients[key] = c
+	return c, nil
+}
+
+func (r *Runner) logsClient(sink Sink) (logsUploader, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	target := logsTarget{sink.DataCollectionEndpoint, sink.DataCollectionStreamName, sink.DataCollectionRuleID}
+	if c, ok := r.logsClients[target]; ok {
+		return c, nil
+	}
+	c, err := r.clients.newLogsUploader(target)
+	if err != nil {
+		return nil, err
+	}
+	r.logsClients[target] = c
+	return c, nil
+}
+
+func (r *Runner) remoteWriteClient(sink Sink) (remoteWriter, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	target := remoteWriteTarget{sink.RemoteWriteURL, sink.Username, sink.Password, sink.BearerToken}
+	if c, ok := r.rwClients[target]; ok {
+		return c, nil
+	}
+	c, err := r.clients.newRemoteWriter(target)
+	if err != nil {
+		return nil, err
+	}
+	r.rwClients[target] = c
+	return c, nil
+}
+
+// Close shuts down the OTLP exporters, flushing metrics they still hold. The runner can't export OTLP metrics afterwards.
+func (r *Runner) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), otlpShutdownTimeout)
+	defer cancel()
+	var errs []error
+	for target, c := range r.otlpClients {
+		if err := c.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("failed to shut down otlp client for %s: %w", c.URL(), err))
+		}
+		delete(r.otlpClients, target)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("Close: %w", err)
+	}
+	return nil
+}
+
+func (r *Runner) otlpClient(ctx context.Context, sink Sink) (otlpExporter, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	target := otlpTarget{sink.OTLPProtocol, sink.OTLPEndpoint}
+	if c, ok := r.otlpClients[target]; ok {
+		return c, nil
+	}
+	c, err := r.clients.newOTLPExporter(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	r.otlpClients[target] = c
+	return c, nil
+}
+
+func (r *Runner) customMetricsClient() (metricsSender, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.cmClient != nil {
+		return r.cmClient, nil
+	}
+	c, err := r.clients.newMetricsSender()
+	if err != nil {
+		return nil, err
+	}
+	r.cmClient = c
+	return c, nil
+}
+
+// location returns the location configured in the sink, or resolves it from the scope resource.
+func (r *Runner) location(ctx context.Context, sink Sink) (string, error) {
+	if sink.Location != "" {
+		return sink.Location, nil
+	}
+
+	r.mu.Lock()
+	location, ok := r.locations[sink.ScopeResourceID]
+	r.mu.Unlock()
+	if ok {
+		return location, nil
+	}
+
+	// Jobs saving to the same resource at the same time share one lookup
+	v, err, _ := r.locationLookups.Do(sink.ScopeResourceID, func() (any, error) {
+		c, err := r.locationClient()
+		if err != nil {
+			return "", err
+		}
+		location, err := c.GetLocation(ctx, sink.ScopeResourceID)
+		if err != nil {
+			return "", err
+		}
+		r.mu.Lock()
+		r.locations[sink.ScopeResourceID] = location
+		r.mu.Unlock()
+		return location, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return v.(string), nil
+}
+
+// locationClient returns the client locations of resources are looked up with, creating it on first use.
+func (r *Runner) locationClient() (*kql.ResourceClient, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.resourceClient == nil {
+		opts := []kql.ResourceClientOption{kql.WithResourceAuthClient(r.authClient), kql.WithResourceCloud(r.cloud)}
+		if r.locationCachePath != "" {
+			opts = append(opts, kql.WithLocationCache(r.locationCachePath))
+		}
+		c, err := kql.NewResourceClient(opts...)
+		if err != nil {
+			return nil, err
+		}
+		r.resourceClient = c
+	}
+	return r.resourceClient, nil
+}
@@ -0,0 +1,393 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/monitor/azquery"
+	"github.com/DrBushytop/amag/pkg/kql"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type fakeCredential struct{}
+
+func (fakeCredential) GetToken(_ context.Context, _ policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	return azcore.AccessToken{Token: "token", ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+// fakeQueryClient returns the result of rows for the time window of each query, and records the windows.
+type fakeQueryClient struct {
+	rows    func(window azquery.TimeInterval) ([]kql.LogLine, error)
+	windows []azquery.TimeInterval
+}
+
+func (c *fakeQueryClient) QueryWorkspaceForAggregateValue(_ context.Context, body azquery.Body, _ *azquery.LogsClientQueryWorkspaceOptions) ([]kql.LogLine, error) {
+	c.windows = append(c.windows, *body.Timespan)
+	return c.rows(*body.Timespan)
+}
+
+func (c *fakeQueryClient) QueryWorkspaceForAggregateValueWithColumns(ctx context.Context, body azquery.Body, options *azquery.LogsClientQueryWorkspaceOptions) ([]kql.LogLine, error) {
+	return c.QueryWorkspaceForAggregateValue(ctx, body, options)
+}
+
+// fakeSinks records the log entries and custom metrics saved by the runner. Saves fail with err if set.
+type fakeSinks struct {
+	entries []kql.AggregateLogEntry
+	metrics []kql.CustomMetricBody
+	err     error
+}
+
+func (s *fakeSinks) UploadURL() string {
+	return "https://dce/upload"
+}
+
+func (s *fakeSinks) SaveLogEntryToLogAnalytics(_ context.Context, entry []kql.AggregateLogEntry) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.entries = append(s.entries, entry...)
+	return nil
+}
+
+func (s *fakeSinks) SaveRowsToLogAnalytics(_ context.Context, _ []map[string]any) error {
+	return errors.New("not implemented")
+}
+
+func (s *fakeSinks) MetricsURL(scopeResourceId string, location string) string {
+	return "https://" + location + ".monitoring.azure.com" + scopeResourceId + "/metrics"
+}
+
+func (s *fakeSinks) SendCustomMetrics(_ context.Context, _ string, _ string, body kql.CustomMetricBody) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.metrics = append(s.metrics, body)
+	return nil
+}
+
+// fakeClients creates the fake query client and sinks for all targets.
+type fakeClients struct {
+	query *fakeQueryClient
+	sinks *fakeSinks
+}
+
+func (c fakeClients) newQueryClient(_ Job, _ []string) (queryClient, error) {
+	return c.query, nil
+}
+
+func (c fakeClients) newLogsUploader(_ logsTarget) (logsUploader, error) {
+	return c.sinks, nil
+}
+
+func (c fakeClients) newMetricsSender() (metricsSender, error) {
+	return c.sinks, nil
+}
+
+func (c fakeClients) newRemoteWriter(_ remoteWriteTarget) (remoteWriter, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (c fakeClients) newOTLPExporter(_ context.Context, _ otlpTarget) (otlpExporter, error) {
+	return nil, errors.New("not implemented")
+}
+
+// newTestRunner creates a runner that queries and saves with the fake clients.
+func newTestRunner(t *testing.T, clients fakeClients, opts ...RunnerOption) *Runner {
+	t.Helper()
+	r, err := NewRunner(append([]RunnerOption{WithCredential(fakeCredential{})}, opts...)...)
+	if err != nil {
+		t.Fatalf("NewRunner() error = %v", err)
+	}
+	r.clients = clients
+	return r
+}
+
+// newTestJob creates a job with a query file in a temporary folder, saving to the sink.
+func newTestJob(t *testing.T, sink Sink) Job {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "requests.kql")
+	if err := os.WriteFile(file, []byte("requests | summarize MetricValue = count() by cloud_RoleName"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return Job{Name: "requests", File: file, WorkspaceID: "ws", Sink: sink}
+}
+
+func TestBackfill(t *testing.T) {
+	logSink := Sink{Type: SinkLog, Metric: "Requests", DataCollectionEndpoint: "https://dce", DataCollectionStreamName: "Custom-Aggregates_CL", DataCollectionRuleID: "dcr-1"}
+	metricSink := Sink{Type: SinkMetric, Metric: "Requests", ScopeResourceID: "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm", Location: "westeurope"}
+	start := time.Date(2024, 9, 20, 9, 0, 0, 0, time.UTC)
+	recent := time.Now().UTC().Truncate(time.Minute).Add(-45 * time.Minute)
+	original := time.Date(2024, 9, 20, 9, 30, 0, 0, time.UTC)
+	row := []kql.LogLine{{MetricValue: 1, Dimensions: []kql.Dimension{{Name: "cloud_RoleName", Value: "api"}}}}
+	tests := []struct {
+		name        string
+		sink        Sink
+		window      kql.TimeWindow
+		step        time.Duration
+		rows        func(window azquery.TimeInterval) ([]kql.LogLine, error)
+		wantQueries []kql.TimeWindow
+		want        Result
+		wantSaved   int
+		// wantOriginal are the OriginalTimeGenerated of the saved log entries
+		wantOriginal []time.Time
+		wantErr      bool
+	}{
+		{
+			name:   "split into windows",
+			sink:   logSink,
+			window: kql.TimeWindow{Start: start, End: start.Add(150 * time.Minute)},
+			step:   time.Hour,
+			wantQueries: []kql.TimeWindow{
+				{Start: start, End: start.Add(time.Hour)},
+				{Start: start.Add(time.Hour), End: start.Add(2 * time.Hour)},
+				{Start: start.Add(2 * time.Hour), End: start.Add(150 * time.Minute)},
+			},
+			want:         Result{Job: "requests", Window: kql.TimeWindow{Start: start, End: start.Add(150 * time.Minute)}, Windows: 3, Rows: 3},
+			wantSaved:    3,
+			wantOriginal: []time.Time{start, start.Add(time.Hour), start.Add(2 * time.Hour)},
+		},
+		{
+			name:   "original time generated of rows kept",
+			sink:   logSink,
+			window: kql.TimeWindow{Start: start, End: start.Add(time.Hour)},
+			step:   time.Hour,
+			rows: func(azquery.TimeInterval) ([]kql.LogLine, error) {
+				return []kql.LogLine{{MetricValue: 1, TimeGenerated: &original}, {MetricValue: 2}}, nil
+			},
+			wantQueries:  []kql.TimeWindow{{Start: start, End: start.Add(time.Hour)}},
+			want:         Result{Job: "requests", Window: kql.TimeWindow{Start: start, End: start.Add(time.Hour)}, Windows: 1, Rows: 2},
+			wantSaved:    2,
+			wantOriginal: []time.Time{original, start},
+		},
+		{
+			name:   "windows without rows skipped",
+			sink:   logSink,
+			window: kql.TimeWindow{Start: start, End: start.Add(2 * time.Hour)},
+			step:   time.Hour,
+			rows: func(window azquery.TimeInterval) ([]kql.LogLine, error) {
+				if window == (kql.TimeWindow{Start: start, End: start.Add(time.Hour)}).TimeInterval() {
+					return nil, nil
+				}
+				return row, nil
+			},
+			wantQueries: []kql.TimeWindow{
+				{Start: start, End: start.Add(time.Hour)},
+				{Start: start.Add(time.Hour), End: start.Add(2 * time.Hour)},
+			},
+			want:         Result{Job: "requests", Window: kql.TimeWindow{Start: start, End: start.Add(2 * time.Hour)}, Windows: 2, Rows: 1},
+			wantSaved:    1,
+			wantOriginal: []time.Time{start.Add(time.Hour)},
+		},
+		{
+			name:        "metric sink skips windows older than custom metrics accept",
+			sink:        metricSink,
+			window:      kql.TimeWindow{Start: recent, End: recent.Add(45 * time.Minute)},
+			step:        30 * time.Minute,
+			wantQueries: []kql.TimeWindow{{Start: recent.Add(30 * time.Minute), End: recent.Add(45 * time.Minute)}},
+			want:        Result{Job: "requests", Window: kql.TimeWindow{Start: recent, End: recent.Add(45 * time.Minute)}, Windows: 1, Rows: 1},
+			wantSaved:   1,
+		},
+		{
+			name:    "metric sink with all windows too old",
+			sink:    metricSink,
+			window:  kql.TimeWindow{Start: start, End: start.Add(2 * time.Hour)},
+			step:    time.Hour,
+			want:    Result{Job: "requests", Window: kql.TimeWindow{Start: start, End: start.Add(2 * time.Hour)}},
+			wantErr: true,
+		},
+		{
+			name:        "query error stops the backfill",
+			sink:        logSink,
+			window:      kql.TimeWindow{Start: start, End: start.Add(2 * time.Hour)},
+			step:        time.Hour,
+			rows:        func(azquery.TimeInterval) ([]kql.LogLine, error) { return nil, kql.ErrQueryFailed },
+			wantQueries: []kql.TimeWindow{{Start: start, End: start.Add(time.Hour)}},
+			want:        Result{Job: "requests", Window: kql.TimeWindow{Start: start, End: start}},
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			rows := tt.rows
+			if rows == nil {
+				rows = func(azquery.TimeInterval) ([]kql.LogLine, error) { return row, nil }
+			}
+			clients := fakeClients{query: &fakeQueryClient{rows: rows}, sinks: &fakeSinks{}}
+			r := newTestRunner(t, clients)
+
+			got, err := r.Backfill(context.Background(), newTestJob(t, tt.sink), tt.window, tt.step)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Backfill() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Backfill() = %+v, want %+v", got, tt.want)
+			}
+
+			var wantQueries []azquery.TimeInterval
+			for _, w := range tt.wantQueries {
+				wantQueries = append(wantQueries, w.TimeInterval())
+			}
+			if !reflect.DeepEqual(clients.query.windows, wantQueries) {
+				t.Errorf("Backfill() queried %v, want %v", clients.query.windows, wantQueries)
+			}
+
+			saved := len(clients.sinks.entries) + len(clients.sinks.metrics)
+			if saved != tt.wantSaved {
+				t.Errorf("Backfill() saved %d entries and metrics, want %d", saved, tt.wantSaved)
+			}
+			if tt.wantOriginal != nil {
+				var gotOriginal []time.Time
+				for _, entry := range clients.sinks.entries {
+					gotOriginal = append(gotOriginal, *entry.OriginalTimeGenerated)
+				}
+				if !reflect.DeepEqual(gotOriginal, tt.wantOriginal) {
+					t.Errorf("Backfill() saved OriginalTimeGenerated %v, want %v", gotOriginal, tt.wantOriginal)
+				}
+			}
+		})
+	}
+}
+
+func TestRunIncremental(t *testing.T) {
+	logSink := Sink{Type: SinkLog, Metric: "Requests", DataCollectionEndpoint: "https://dce", DataCollectionStreamName: "Custom-Aggregates_CL", DataCollectionRuleID: "dcr-1"}
+	start := time.Date(2024, 9, 20, 9, 0, 0, 0, time.UTC)
+	hour := func(n int) time.Time { return start.Add(time.Duration(n) * time.Hour) }
+	window := func(from, to int) kql.TimeWindow { return kql.TimeWindow{Start: hour(from), End: hour(to)} }
+	row := []kql.LogLine{{MetricValue: 1}}
+	tests := []struct {
+		name       string
+		checkpoint *time.Time
+		// rows returns the result of the query by window, one row by default
+		rows           func(window azquery.TimeInterval) ([]kql.LogLine, error)
+		saveErr        error
+		dryRun         bool
+		wantQueries    []kql.TimeWindow
+		want           Result
+		wantCheckpoint *time.Time
+		wantErr        bool
+	}{
+		{
+			name:           "first run over the configured window",
+			wantQueries:    []kql.TimeWindow{window(0, 1), window(1, 2), window(2, 3)},
+			want:           Result{Job: "requests", Window: window(0, 3), Windows: 3, Rows: 3},
+			wantCheckpoint: to.Ptr(hour(3)),
+		},
+		{
+			name:           "catch up from checkpoint",
+			checkpoint:     to.Ptr(hour(1)),
+			wantQueries:    []kql.TimeWindow{window(1, 2), window(2, 3)},
+			want:           Result{Job: "requests", Window: window(1, 3), Windows: 2, Rows: 2},
+			wantCheckpoint: to.Ptr(hour(3)),
+		},
+		{
+			name:           "up to date",
+			checkpoint:     to.Ptr(hour(3)),
+			want:           Result{Job: "requests", Window: window(3, 3)},
+			wantCheckpoint: to.Ptr(hour(3)),
+		},
+		{
+			name:       "window without rows moves the checkpoint",
+			checkpoint: to.Ptr(hour(1)),
+			rows: func(w azquery.TimeInterval) ([]kql.LogLine, error) {
+				if w == window(1, 2).TimeInterval() {
+					return nil, nil
+				}
+				return row, nil
+			},
+			wantQueries:    []kql.TimeWindow{window(1, 2), window(2, 3)},
+			want:           Result{Job: "requests", Window: window(1, 3), Windows: 2, Rows: 1},
+			wantCheckpoint: to.Ptr(hour(3)),
+		},
+		{
+			name:       "failed query keeps the checkpoint at the failed window",
+			checkpoint: to.Ptr(hour(0)),
+			rows: func(w azquery.TimeInterval) ([]kql.LogLine, error) {
+				if w == window(1, 2).TimeInterval() {
+					return nil, kql.ErrQueryFailed
+				}
+				return row, nil
+			},
+			wantQueries:    []kql.TimeWindow{window(0, 1), window(1, 2)},
+			want:           Result{Job: "requests", Window: window(0, 1), Windows: 1, Rows: 1},
+			wantCheckpoint: to.Ptr(hour(1)),
+			wantErr:        true,
+		},
+		{
+			name:           "failed save keeps the checkpoint",
+			checkpoint:     to.Ptr(hour(1)),
+			saveErr:        kql.ErrUploadRejected,
+			wantQueries:    []kql.TimeWindow{window(1, 2)},
+			want:           Result{Job: "requests", Window: window(1, 1), Rows: 1},
+			wantCheckpoint: to.Ptr(hour(1)),
+			wantErr:        true,
+		},
+		{
+			name:        "dry run leaves the checkpoint",
+			dryRun:      true,
+			wantQueries: []kql.TimeWindow{window(0, 1), window(1, 2), window(2, 3)},
+			want:        Result{Job: "requests", Window: window(0, 3), Windows: 3, Rows: 3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			path := filepath.Join(t.TempDir(), "checkpoints.json")
+			j := newTestJob(t, logSink)
+			j.Incremental = true
+			j.Start = hour(0).Format(time.RFC3339)
+			j.End = hour(3).Format(time.RFC3339)
+			j.Align = "1h"
+			if tt.checkpoint != nil {
+				if err := NewCheckpointStore(path).Set(j.CheckpointKey(), *tt.checkpoint); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			rows := tt.rows
+			if rows == nil {
+				rows = func(azquery.TimeInterval) ([]kql.LogLine, error) { return row, nil }
+			}
+			clients := fakeClients{query: &fakeQueryClient{rows: rows}, sinks: &fakeSinks{err: tt.saveErr}}
+			opts := []RunnerOption{WithCheckpoints(path)}
+			if tt.dryRun {
+				opts = append(opts, WithDryRun(io.Discard))
+			}
+			r := newTestRunner(t, clients, opts...)
+
+			got, err := r.Run(context.Background(), j)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Run() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Run() = %+v, want %+v", got, tt.want)
+			}
+
+			var wantQueries []azquery.TimeInterval
+			for _, w := range tt.wantQueries {
+				wantQueries = append(wantQueries, w.TimeInterval())
+			}
+			if !reflect.DeepEqual(clients.query.windows, wantQueries) {
+				t.Errorf("Run() queried %v, want %v", clients.query.windows, wantQueries)
+			}
+
+			mark, ok, err := NewCheckpointStore(path).Get(j.CheckpointKey())
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch {
+			case tt.wantCheckpoint == nil && ok:
+				t.Errorf("Run() saved checkpoint %v, want none", mark)
+			case tt.wantCheckpoint != nil && (!ok || !mark.Equal(*tt.wantCheckpoint)):
+				t.Errorf("Run() saved checkpoint %v (%v), want %v", mark, ok, *tt.wantCheckpoint)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package job
+
+import (
+	"context"
+	"fmt"
+	"github.com/DrBushytop/amag/pkg/kql"
+	"github.com/charmbracelet/log"
+	"github.com/robfig/cron/v3"
+	"math/rand/v2"
+	"time"
+)
+
+// Scheduler runs jobs on their cron schedules until stopped. A job is skipped if its previous run is still in progress.
+type Scheduler struct {
+	runner *Runner
+	cron   *cron.Cron
+	ctx    context.Context
+}
+
+// NewScheduler creates a scheduler for the given jobs. Every job must have a valid schedule and jitter.
+func NewScheduler(runner *Runner, jobs []Job) (*Scheduler, error) {
+	logger := cronLogger{}
+	s := Scheduler{
+		runner: runner,
+		cron: cron.New(
+			cron.WithLocation(time.UTC),
+			cron.WithLogger(logger),
+			cron.WithChain(cron.Recover(logger)),
+		),
+		ctx: context.Background(),
+	}
+
+	for _, j := range jobs {
+		if j.Schedule == "" {
+			return nil, fmt.Errorf("NewScheduler: job %s has no schedule", j.Name)
+		}
+
+		var jitter time.Duration
+		if j.Jitter != "" {
+			d, err := kql.ParseDuration(j.Jitter)
+			if err != nil {
+				return nil, fmt.Errorf("NewScheduler: job %s has invalid jitter: %w", j.Name, err)
+			}
+			jitter = d
+		}
+
+		// Each job gets its own chain, so that a long-running job only blocks its own next run.
+		wrapped := cron.NewChain(cron.SkipIfStillRunning(logger)).Then(s.newCronJob(j, jitter))
+		if _, err := s.cron.AddJob(j.Schedule, wrapped); err != nil {
+			return nil, fmt.Errorf("NewScheduler: job %s has invalid schedule %q: %w", j.Name, j.Schedule, err)
+		}
+		log.Info("Scheduled job", "job", j.Name, "schedule", j.Schedule, "jitter", jitter)
+	}
+
+	return &s, nil
+}
+
+// Run starts the scheduler and blocks until ctx is cancelled. Runs in progress are cancelled and waited for before returning.
+func (s *Scheduler) Run(ctx context.Context) {
+	s.ctx = ctx
+	s.cron.Start()
+
+	<-ctx.Done()
+	log.Info("Stopping scheduler, waiting for running jobs to finish")
+	<-s.cron.Stop().Done()
+}
+
+func (s *Scheduler) newCronJob(j Job, jitter time.Duration) cron.Job {
+	return cron.FuncJob(func() {
+		if jitter > 0 {
+			select {
+			case <-time.After(rand.N(jitter)):
+			case <-s.ctx.Done():
+				return
+			}
+		}
+
+		log.Info("Running job", "job", j.Name)
+		res, err := s.runner.Run(s.ctx, j)
+		if err != nil {
+			log.Error("Job failed", "job", j.Name, "err", err)
+			return
+		}
+		log.Info("Job succeeded", "job", j.Name, "window", res.Window, "windows", res.Windows, "rows", res.Rows)
+	})
+}
+
+// cronLogger adapts the cron library logging to the charmbracelet logger.
+type cronLogger struct{}
+
+func (cronLogger) Info(msg string, keysAndValues ...interface{}) {
+	log.Debug(msg, keysAndValues...)
+}
+
+func (cronLogger) Error(err error, msg string, keysAndValues ...interface{}) {
+	log.Error(msg, append(keysAndValues, "err", err)...)
+}
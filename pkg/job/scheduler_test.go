@@ -0,0 +1,83 @@
+package job
+
+import (
+	"github.com/Azure/azure-sdk-for-go/sdk/monitor/azquery"
+	"github.com/DrBushytop/amag/pkg/kql"
+	"testing"
+	"time"
+)
+
+func TestNewScheduler(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule string
+		jitter   string
+		wantErr  bool
+	}{
+		{name: "cron expression", schedule: "*/5 * * * *", jitter: "30s"},
+		{name: "descriptor", schedule: "@every 5m"},
+		{name: "no schedule", wantErr: true},
+		{name: "invalid cron expression", schedule: "*/5 * * *", wantErr: true},
+		{name: "invalid descriptor", schedule: "@every five minutes", wantErr: true},
+		{name: "invalid jitter", schedule: "@every 5m", jitter: "soon", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			j := Job{Name: "requests", Schedule: tt.schedule, Jitter: tt.jitter}
+			_, err := NewScheduler(newTestRunner(t, fakeClients{}), []Job{j})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewScheduler() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSchedulerSkipsOverlappingRun(t *testing.T) {
+	t.Parallel()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	query := &fakeQueryClient{rows: func(azquery.TimeInterval) ([]kql.LogLine, error) {
+		started <- struct{}{}
+		<-release
+		return []kql.LogLine{{MetricValue: 1}}, nil
+	}}
+	r := newTestRunner(t, fakeClients{query: query, sinks: &fakeSinks{}})
+
+	j := newTestJob(t, Sink{Type: SinkLog, Metric: "Requests", DataCollectionEndpoint: "https://dce", DataCollectionStreamName: "Custom-Aggregates_CL", DataCollectionRuleID: "dcr-1"})
+	j.Timespan = "1h"
+	j.Schedule = "@every 1h"
+	s, err := NewScheduler(r, []Job{j})
+	if err != nil {
+		t.Fatalf("NewScheduler() error = %v", err)
+	}
+	// Runs the job like cron does when it is due, without waiting for the schedule
+	run := s.cron.Entries()[0].WrappedJob.Run
+
+	done := make(chan struct{})
+	go func() {
+		run()
+		close(done)
+	}()
+	<-started
+
+	// The second run returns right away, since the first one is still running
+	skipped := make(chan struct{})
+	go func() {
+		run()
+		close(skipped)
+	}()
+	select {
+	case <-skipped:
+	case <-started:
+		t.Fatal("overlapping run was not skipped")
+	case <-time.After(5 * time.Second):
+		t.Fatal("overlapping run did not return")
+	}
+
+	close(release)
+	<-done
+	if len(query.windows) != 1 {
+		t.Errorf("job queried %d times, want 1", len(query.windows))
+	}
+}
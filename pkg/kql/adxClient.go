@@ -0,0 +1,118 @@
+package kql
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/monitor/azquery"
+	"github.com/DrBushytop/amag/pkg/auth"
+	"net/http"
+	"strings"
+)
+
+// NewADXClient returns a client running queries against a database of an Azure Data Explorer cluster, through the
+// REST API v1 query endpoint. The database is given in place of the workspace id.
+//
+// The query timespan is not sent, since the REST API has none, so queries have to filter on the time window themselves
+// with the {{ .Start }} and {{ .End }} placeholders. Additional workspaces are ignored.
+func NewADXClient(clusterURI string, database string, opts ...WsOption) (*WorkspaceClient, error) {
+	wsc, err := newWorkspaceClient(database, opts)
+	if err != nil {
+		return nil, fmt.Errorf("NewADXClient: %w", err)
+	}
+
+	if wsc.client == nil {
+		if clusterURI == "" {
+			return nil, fmt.Errorf("NewADXClient: cluster uri is required")
+		}
+		authClient, err := auth.NewAuthClient(auth.WithCredential(wsc.cred))
+		if err != nil {
+			return nil, fmt.Errorf("NewADXClient: failed to create auth client: %w", err)
+		}
+		clusterURI = strings.TrimSuffix(clusterURI, "/")
+		wsc.client = &adxQueryClient{
+			restClient: restClient{authClient: authClient, httpClient: http.DefaultClient},
+			clusterURI: clusterURI,
+			scope:      clusterURI + "/.default",
+		}
+	}
+
+	return wsc, nil
+}
+
+// adxQueryClient queries Azure Data Explorer databases through the REST API v1. It implements queryClient with databases
+// in place of workspace ids, converting the v1 response into the Log Analytics result format.
+type adxQueryClient struct {
+	restClient
+	clusterURI string
+	scope      string
+}
+
+type adxQueryBody struct {
+	DB  string `json:"db"`
+	CSL string `json:"csl"`
+}
+
+// adxResponse is the response of the v1 query endpoint. Query results are followed by tables with query properties and
+// status, and a table of contents listing the kind of each table, if there is more than one table.
+type adxResponse struct {
+	Tables []struct {
+		TableName string `json:"TableName"`
+		Columns   []struct {
+			ColumnName string `json:"ColumnName"`
+			ColumnType string `json:"ColumnType"`
+			DataType   string `json:"DataType"`
+		} `json:"Columns"`
+		Rows [][]any `json:"Rows"`
+	} `json:"Tables"`
+}
+
+func (c *adxQueryClient) queryURL() string {
+	return c.clusterURI + "/v1/rest/query"
+}
+
+func (c *adxQueryClient) QueryWorkspace(ctx context.Context, database string, body azquery.Body, _ *azquery.LogsClientQueryWorkspaceOptions) (azquery.LogsClientQueryWorkspaceResponse, error) {
+	var response adxResponse
+	if err := c.postJSON(ctx, c.scope, c.queryURL(), adxQueryBody{DB: database, CSL: stringValue(body.Query)}, &response); err != nil {
+		return azquery.LogsClientQueryWorkspaceResponse{}, err
+	}
+	return azquery.LogsClientQueryWorkspaceResponse{Results: response.results()}, nil
+}
+
+// results converts the query result tables of the response into the Log Analytics result format.
+func (r adxResponse) results() azquery.Results {
+	resultTables := []int{0}
+	if len(r.Tables) > 1 {
+		// The last table is the table of contents, with the ordinal of each table in the first column, and its kind in the second.
+		resultTables = nil
+		for _, row := range r.Tables[len(r.Tables)-1].Rows {
+			if len(row) < 2 || row[1] != "QueryResult" {
+				continue
+			}
+			if ordinal, ok := row[0].(json.Number); ok {
+				if i, err := ordinal.Int64(); err == nil {
+					resultTables = append(resultTables, int(i))
+				}
+			}
+		}
+	}
+
+	var results azquery.Results
+	for _, i := range resultTables {
+		if i < 0 || i >= len(r.Tables) {
+			continue
+		}
+		table := r.Tables[i]
+		t := &azquery.Table{Name: to.Ptr(table.TableName)}
+		for _, col := range table.Columns {
+			columnType := azquery.LogsColumnType(strings.ToLower(col.ColumnType))
+			t.Columns = append(t.Columns, &azquery.Column{Name: to.Ptr(col.ColumnName), Type: &columnType})
+		}
+		for _, row := range table.Rows {
+			t.Rows = append(t.Rows, row)
+		}
+		results.Tables = append(results.Tables, t)
+	}
+	return results
+}
@@ -0,0 +1,89 @@
+package kql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/monitor/azquery"
+	"github.com/DrBushytop/amag/pkg/auth"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestADXQuery(t *testing.T) {
+	const resultTable = `{"TableName":"Table_0","Columns":[{"ColumnName":"Region","DataType":"String","ColumnType":"string"},{"ColumnName":"MetricValue","DataType":"Double","ColumnType":"real"}],"Rows":[["eu",2.5],["us",1]]}`
+	lines := []LogLine{
+		{MetricValue: 2.5, Dimensions: []Dimension{{"Region", "eu"}}, Columns: map[string]any{"Region": "eu", "MetricValue": 2.5}},
+		{MetricValue: 1, Dimensions: []Dimension{{"Region", "us"}}, Columns: map[string]any{"Region": "us", "MetricValue": 1.0}},
+	}
+	tests := []struct {
+		name     string
+		status   int
+		response string
+		want     []LogLine
+		wantErr  error
+	}{
+		{
+			name:     "single table",
+			status:   http.StatusOK,
+			response: `{"Tables":[` + resultTable + `]}`,
+			want:     lines,
+		},
+		{
+			name:   "query result selected by table of contents",
+			status: http.StatusOK,
+			response: `{"Tables":[` + resultTable + `,
+				{"TableName":"Table_1","Columns":[{"ColumnName":"Value","DataType":"String","ColumnType":"string"}],"Rows":[["{}"]]},
+				{"TableName":"Table_2","Columns":[{"ColumnName":"Ordinal","DataType":"Int64","ColumnType":"long"},{"ColumnName":"Kind","DataType":"String","ColumnType":"string"}],"Rows":[[0,"QueryResult"],[1,"QueryProperties"]]}
+			]}`,
+			want: lines,
+		},
+		{name: "unauthorized", status: http.StatusUnauthorized, response: `{}`, wantErr: ErrAuthFailed},
+		{name: "bad request", status: http.StatusBadRequest, response: `{"error":{"code":"General_BadRequest"}}`, wantErr: ErrQueryFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/v1/rest/query" {
+					t.Errorf("request path = %s, want /v1/rest/query", r.URL.Path)
+				}
+				if got := r.Header.Get("Authorization"); got != "Bearer token" {
+					t.Errorf("Authorization header = %q, want Bearer token", got)
+				}
+				var body adxQueryBody
+				if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+					t.Errorf("failed to decode request body: %v", err)
+				}
+				if want := (adxQueryBody{DB: "telemetry", CSL: "Metrics"}); body != want {
+					t.Errorf("request body = %+v, want %+v", body, want)
+				}
+
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.response))
+			}))
+			defer server.Close()
+
+			authClient, err := auth.NewAuthClient(auth.WithCredential(fakeCredential{}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := &adxQueryClient{restClient: restClient{authClient: authClient, httpClient: server.Client()}, clusterURI: server.URL, scope: "scope"}
+			wsc, err := NewADXClient(server.URL, "telemetry", WithQueryClient(client))
+			if err != nil {
+				t.Fatalf("NewADXClient() error = %v", err)
+			}
+
+			got, err := wsc.QueryWorkspaceForAggregateValueWithColumns(context.Background(), azquery.Body{Query: to.Ptr("Metrics")}, nil)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("QueryWorkspaceForAggregateValueWithColumns() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("QueryWorkspaceForAggregateValueWithColumns() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
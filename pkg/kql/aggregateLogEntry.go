@@ -1,6 +1,9 @@
 package kql
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
 
 // AggregateLogEntry represents a single log entry to be saved in Log Analytics.
 // It contains the time the log was generated and the current time, due to logs being able to be
@@ -10,4 +13,28 @@ type AggregateLogEntry struct {
 	OriginalTimeGenerated *time.Time `json:"OriginalTimeGenerated"`
 	Name                  string     `json:"Name"`
 	Value                 float64    `json:"Value"`
+	// Unit of the value, from the metadata of the query file. Omitted if not set.
+	Unit string `json:"Unit,omitempty"`
+}
+
+// NewPassthroughLogEntry creates a log entry that holds all columns of the line, alongside the fields of AggregateLogEntry.
+// The TimeGenerated column of the query result is saved as OriginalTimeGenerated, like in AggregateLogEntry.
+// Unit is added as a column when set. Other columns named like a field of AggregateLogEntry are rejected, instead of
+// being overwritten.
+func NewPassthroughLogEntry(name string, unit string, line LogLine, timeGenerated time.Time, originalTimeGenerated *time.Time) (map[string]any, error) {
+	entry := make(map[string]any, len(line.Columns)+5)
+	for k, v := range line.Columns {
+		if k == "OriginalTimeGenerated" || k == "Name" || k == "Value" || (k == "Unit" && unit != "") {
+			return nil, fmt.Errorf("NewPassthroughLogEntry: column %s conflicts with the field of the same name, rename it in the query", k)
+		}
+		entry[k] = v
+	}
+	entry["TimeGenerated"] = timeGenerated
+	entry["OriginalTimeGenerated"] = originalTimeGenerated
+	entry["Name"] = name
+	entry["Value"] = line.MetricValue
+	if unit != "" {
+		entry["Unit"] = unit
+	}
+	return entry, nil
 }
\ No newline at end of file
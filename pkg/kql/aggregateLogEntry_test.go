@@ -0,0 +1,53 @@
+package kql
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNewPassthroughLogEntry(t *testing.T) {
+	now := time.Date(2024, 9, 20, 12, 0, 0, 0, time.UTC)
+	original := time.Date(2024, 9, 20, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		unit    string
+		columns map[string]any
+		want    map[string]any
+		wantErr bool
+	}{
+		{
+			name:    "columns and fields",
+			columns: map[string]any{"TimeGenerated": original, "cloud_RoleName": "api", "MetricValue": 1.5},
+			want:    map[string]any{"TimeGenerated": now, "OriginalTimeGenerated": &original, "Name": "requests", "Value": 1.5, "cloud_RoleName": "api", "MetricValue": 1.5},
+		},
+		{
+			name:    "unit",
+			unit:    "Count",
+			columns: map[string]any{"MetricValue": 1.5},
+			want:    map[string]any{"TimeGenerated": now, "OriginalTimeGenerated": &original, "Name": "requests", "Value": 1.5, "Unit": "Count", "MetricValue": 1.5},
+		},
+		{
+			name:    "unit column without unit",
+			columns: map[string]any{"Unit": "ms", "MetricValue": 1.5},
+			want:    map[string]any{"TimeGenerated": now, "OriginalTimeGenerated": &original, "Name": "requests", "Value": 1.5, "Unit": "ms", "MetricValue": 1.5},
+		},
+		{name: "name column", columns: map[string]any{"Name": "api", "MetricValue": 1.5}, wantErr: true},
+		{name: "value column", columns: map[string]any{"Value": 3.0, "MetricValue": 1.5}, wantErr: true},
+		{name: "original time generated column", columns: map[string]any{"OriginalTimeGenerated": original, "MetricValue": 1.5}, wantErr: true},
+		{name: "unit column with unit", unit: "Count", columns: map[string]any{"Unit": "ms", "MetricValue": 1.5}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			line := LogLine{TimeGenerated: &original, MetricValue: 1.5, Columns: tt.columns}
+			got, err := NewPassthroughLogEntry("requests", tt.unit, line, now, &original)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewPassthroughLogEntry() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NewPassthroughLogEntry() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
\ No newline at end of file
@@ -0,0 +1,81 @@
+package kql
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/monitor/azquery"
+	"github.com/DrBushytop/amag/pkg/auth"
+	"net/http"
+	"net/url"
+)
+
+// NewAppInsightsClient returns a client running queries against a classic Application Insights app by its app id,
+// through the Application Insights REST API. The app id is given in place of the workspace id, and additional
+// workspaces set with WithAdditionalWorkspaces are queried as additional apps.
+func NewAppInsightsClient(appId string, opts ...WsOption) (*WorkspaceClient, error) {
+	wsc, err := newWorkspaceClient(appId, opts)
+	if err != nil {
+		return nil, fmt.Errorf("NewAppInsightsClient: %w", err)
+	}
+
+	if wsc.client == nil {
+		endpoints, err := wsc.cloud.Endpoints()
+		if err != nil {
+			return nil, fmt.Errorf("NewAppInsightsClient: %w", err)
+		}
+		authClient, err := auth.NewAuthClient(auth.WithCredential(wsc.cred))
+		if err != nil {
+			return nil, fmt.Errorf("NewAppInsightsClient: failed to create auth client: %w", err)
+		}
+		wsc.client = &appInsightsQueryClient{
+			restClient: restClient{authClient: authClient, httpClient: http.DefaultClient},
+			baseURL:    endpoints.AppInsightsAPI,
+			scope:      endpoints.AppInsightsScope(),
+		}
+	}
+
+	return wsc, nil
+}
+
+// appInsightsQueryClient queries Application Insights apps through the REST API. It implements queryClient with app ids
+// in place of workspace ids, since the API returns results in the same format as Log Analytics.
+type appInsightsQueryClient struct {
+	restClient
+	baseURL string
+	scope   string
+}
+
+type appInsightsQueryBody struct {
+	Query        string   `json:"query"`
+	Timespan     string   `json:"timespan,omitempty"`
+	Applications []string `json:"applications,omitempty"`
+}
+
+// queryURL returns the URL queries against the given app are sent to.
+func (c *appInsightsQueryClient) queryURL(appId string) string {
+	return fmt.Sprintf("%s/v1/apps/%s/query", c.baseURL, url.PathEscape(appId))
+}
+
+func (c *appInsightsQueryClient) QueryWorkspace(ctx context.Context, appId string, body azquery.Body, _ *azquery.LogsClientQueryWorkspaceOptions) (azquery.LogsClientQueryWorkspaceResponse, error) {
+	requestBody := appInsightsQueryBody{Query: stringValue(body.Query)}
+	if body.Timespan != nil {
+		requestBody.Timespan = string(*body.Timespan)
+	}
+	for _, app := range body.AdditionalWorkspaces {
+		requestBody.Applications = append(requestBody.Applications, stringValue(app))
+	}
+	var data json.RawMessage
+	if err := c.postJSON(ctx, c.scope, c.queryURL(appId), requestBody, &data); err != nil {
+		return azquery.LogsClientQueryWorkspaceResponse{}, err
+	}
+	var results azquery.Results
+	if err := json.Unmarshal(data, &results); err != nil {
+		return azquery.LogsClientQueryWorkspaceResponse{}, fmt.Errorf("failed to unmarshal response body: %w", err)
+	}
+	// Results decodes numbers as float64, see logsQueryClient
+	if err := decodeRows(data, &results); err != nil {
+		return azquery.LogsClientQueryWorkspaceResponse{}, err
+	}
+	return azquery.LogsClientQueryWorkspaceResponse{Results: results}, nil
+}
@@ -0,0 +1,86 @@
+package kql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/monitor/azquery"
+	"github.com/DrBushytop/amag/pkg/auth"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestAppInsightsQuery(t *testing.T) {
+	line := LogLine{
+		MetricValue: 1.5,
+		Dimensions:  []Dimension{{"cloud_RoleName", "api"}, {"Requests", "9007199254740993"}},
+		Columns:     map[string]any{"cloud_RoleName": "api", "MetricValue": 1.5, "Requests": int64(9007199254740993)},
+	}
+	tests := []struct {
+		name       string
+		status     int
+		additional []string
+		want       []LogLine
+		wantErr    error
+	}{
+		{
+			name:   "single app",
+			status: http.StatusOK,
+			want:   []LogLine{line},
+		},
+		{
+			name:       "additional apps",
+			status:     http.StatusOK,
+			additional: []string{"app2"},
+			want:       []LogLine{line},
+		},
+		{name: "forbidden", status: http.StatusForbidden, wantErr: ErrAuthFailed},
+		{name: "bad request", status: http.StatusBadRequest, wantErr: ErrQueryFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/v1/apps/app1/query" {
+					t.Errorf("request path = %s, want /v1/apps/app1/query", r.URL.Path)
+				}
+				if got := r.Header.Get("Authorization"); got != "Bearer token" {
+					t.Errorf("Authorization header = %q, want Bearer token", got)
+				}
+				var body appInsightsQueryBody
+				if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+					t.Errorf("failed to decode request body: %v", err)
+				}
+				want := appInsightsQueryBody{Query: "requests", Timespan: "PT1H", Applications: tt.additional}
+				if !reflect.DeepEqual(body, want) {
+					t.Errorf("request body = %+v, want %+v", body, want)
+				}
+
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(`{"tables":[{"name":"PrimaryResult","columns":[{"name":"cloud_RoleName","type":"string"},{"name":"MetricValue","type":"real"},{"name":"Requests","type":"long"}],"rows":[["api",1.5,9007199254740993]]}]}`))
+			}))
+			defer server.Close()
+
+			authClient, err := auth.NewAuthClient(auth.WithCredential(fakeCredential{}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := &appInsightsQueryClient{restClient: restClient{authClient: authClient, httpClient: server.Client()}, baseURL: server.URL, scope: "scope"}
+			wsc, err := NewAppInsightsClient("app1", WithQueryClient(client), WithAdditionalWorkspaces(tt.additional...))
+			if err != nil {
+				t.Fatalf("NewAppInsightsClient() error = %v", err)
+			}
+
+			got, err := wsc.QueryWorkspaceForAggregateValueWithColumns(context.Background(), azquery.Body{Query: to.Ptr("requests"), Timespan: to.Ptr(azquery.TimeInterval("PT1H"))}, nil)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("QueryWorkspaceForAggregateValueWithColumns() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("QueryWorkspaceForAggregateValueWithColumns() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -3,31 +3,110 @@ package kql
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/DrBushytop/amag/pkg/auth"
 	"golang.org/x/net/context"
 	"io"
+	"log"
+	"math/rand/v2"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"time"
+)
+
+const (
+	// defaultRequestTimeout limits each attempt of a request to the custom metrics API.
+	defaultRequestTimeout = 30 * time.Second
+	defaultUserAgent      = "amag"
 )
 
 type CustomMetricsClient struct {
-	authClient *auth.Client
-	httpClient *http.Client
+	authClient     *auth.Client
+	httpClient     *http.Client
+	retryPolicy    RetryPolicy
+	requestTimeout time.Duration
+	baseURL        string
+	userAgent      string
+	cloud          auth.Cloud
+	endpoints      auth.Endpoints
+}
+
+// RetryPolicy controls how failed requests are retried. Requests are retried on network errors, and on 408, 429 and 5xx
+// responses. The delay doubles after each attempt, starting from InitialDelay up to MaxDelay, unless the response
+// sets a Retry-After header.
+type RetryPolicy struct {
+	MaxRetries   int
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+}
+
+// DefaultRetryPolicy returns the retry policy used when none is set.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxRetries:   3,
+		InitialDelay: time.Second,
+		MaxDelay:     30 * time.Second,
+	}
+}
+
+// CustomMetricsError is returned when the custom metrics API responds with an error status.
+// Code and Message are parsed from the error body when it is in the standard Azure error format.
+type CustomMetricsError struct {
+	StatusCode int
+	Status     string
+	Code       string
+	Message    string
+	Body       string
+}
+
+func (e *CustomMetricsError) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("status %d, %s: %s", e.StatusCode, e.Code, e.Message)
+	}
+	return fmt.Sprintf("status %d, %s, response body: %s", e.StatusCode, e.Status, e.Body)
+}
+
+// Unwrap returns ErrAuthFailed for 401 and 403 responses, and ErrUploadRejected otherwise.
+func (e *CustomMetricsError) Unwrap() error {
+	if isAuthStatus(e.StatusCode) {
+		return ErrAuthFailed
+	}
+	return ErrUploadRejected
 }
 
 func NewCustomMetricsClient(opts ...CustomMetricClientOption) (*CustomMetricsClient, error) {
-	authClient, err := auth.NewAuthClient()
+	client := CustomMetricsClient{
+		httpClient:     http.DefaultClient,
+		retryPolicy:    DefaultRetryPolicy(),
+		requestTimeout: defaultRequestTimeout,
+		userAgent:      defaultUserAgent,
+	}
+
+	for _, opt := range opts {
+		err := opt(&client)
+		if err != nil {
+			return nil, fmt.Errorf("NewCustomMetricsClient: failed to apply option: %w", err)
+		}
+	}
+
+	endpoints, err := client.cloud.Endpoints()
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("NewCustomMetricsClient: %w", err)
 	}
+	client.endpoints = endpoints
 
-	httpClient := http.DefaultClient
+	if client.authClient == nil {
+		authClient, err := auth.NewAuthClient()
+		if err != nil {
+			return nil, fmt.Errorf("NewCustomMetricsClient: failed to create auth client: %w", err)
+		}
+		client.authClient = authClient
+	}
 
-	return &CustomMetricsClient{
-		authClient: authClient,
-		httpClient: httpClient,
-	}, nil
+	return &client, nil
 }
 
 type CustomMetricClientOption func(client *CustomMetricsClient) error
@@ -41,35 +120,130 @@ func WithAuthClient(authClient *auth.Client) CustomMetricClientOption {
 
 func WithHttpClient(httpClient *http.Client) CustomMetricClientOption {
 	return func(client *CustomMetricsClient) error {
+		if httpClient == nil {
+			return fmt.Errorf("http client must not be nil")
+		}
 		client.httpClient = httpClient
 		return nil
 	}
 }
 
+// WithBaseURL sends metrics to the given URL instead of the regional endpoint, e.g. https://<location>.monitoring.azure.com.
+// The scope resource id and /metrics are appended to it.
+func WithBaseURL(baseURL string) CustomMetricClientOption {
+	return func(client *CustomMetricsClient) error {
+		u, err := url.Parse(baseURL)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("invalid base url %q", baseURL)
+		}
+		client.baseURL = strings.TrimSuffix(baseURL, "/")
+		return nil
+	}
+}
+
+// WithCustomMetricsCloud sends metrics to the regional endpoints of the given cloud. Defaults to the public cloud.
+func WithCustomMetricsCloud(cloud auth.Cloud) CustomMetricClientOption {
+	return func(client *CustomMetricsClient) error {
+		client.cloud = cloud
+		return nil
+	}
+}
+
+// WithUserAgent sets the User-Agent header of the requests. Defaults to amag.
+func WithUserAgent(userAgent string) CustomMetricClientOption {
+	return func(client *CustomMetricsClient) error {
+		client.userAgent = userAgent
+		return nil
+	}
+}
+
+// WithRetryPolicy sets how failed requests are retried. A policy with MaxRetries 0 disables retries.
+func WithRetryPolicy(policy RetryPolicy) CustomMetricClientOption {
+	return func(client *CustomMetricsClient) error {
+		if policy.MaxRetries < 0 || policy.InitialDelay < 0 || policy.MaxDelay < 0 {
+			return fmt.Errorf("invalid retry policy: %+v", policy)
+		}
+		client.retryPolicy = policy
+		return nil
+	}
+}
+
+// WithRequestTimeout sets the timeout of each attempt of a request. Zero disables the timeout.
+func WithRequestTimeout(timeout time.Duration) CustomMetricClientOption {
+	return func(client *CustomMetricsClient) error {
+		if timeout < 0 {
+			return fmt.Errorf("request timeout must not be negative, got %s", timeout)
+		}
+		client.requestTimeout = timeout
+		return nil
+	}
+}
+
+// MetricsURL returns the URL SendCustomMetrics posts the metrics of the given scope resource to.
+func (c *CustomMetricsClient) MetricsURL(scopeResourceId string, location string) string {
+	// Remove the leading slash from the resourceId as expected by the API
+	scopeResourceId, _ = strings.CutPrefix(scopeResourceId, "/")
+	baseURL := c.baseURL
+	if baseURL == "" {
+		baseURL = c.endpoints.MetricsIngestionURL(location)
+	}
+	return fmt.Sprintf("%s/%s/metrics", baseURL, scopeResourceId)
+}
+
 func (c *CustomMetricsClient) SendCustomMetrics(ctx context.Context, scopeResourceId string, location string, body CustomMetricBody) error {
-	token, err := c.authClient.GetAccessToken([]string{"https://monitoring.azure.com/"})
+	token, err := c.authClient.GetAccessToken([]string{c.endpoints.MetricsIngestionScope()})
 	if err != nil {
 		return fmt.Errorf("SendCustomMetrics: failed to get access token: %w", err)
 	}
 
-	// Remove the leading slash from the resourceId as expected by the API
-	scopeResourceId, _ = strings.CutPrefix(scopeResourceId, "/")
-	uriString := fmt.Sprintf("https://%s.monitoring.azure.com/%s/metrics", location, scopeResourceId)
+	uriString := c.MetricsURL(scopeResourceId, location)
 	jsonBody, err := json.Marshal(body)
 	if err != nil {
 		return fmt.Errorf("SendCustomMetrics: failed to marshal body: %w", err)
 	}
 
-	request, err := http.NewRequestWithContext(ctx, "POST", uriString, bytes.NewBuffer(jsonBody))
+	for attempt := 0; ; attempt++ {
+		retryAfter, err := c.send(ctx, uriString, token, jsonBody)
+		if err == nil {
+			return nil
+		}
+		if retryAfter < 0 || attempt >= c.retryPolicy.MaxRetries || ctx.Err() != nil {
+			return fmt.Errorf("SendCustomMetrics: %w", err)
+		}
+
+		delay := c.retryPolicy.delay(attempt, retryAfter)
+		log.Printf("SendCustomMetrics: attempt %d failed, retrying in %s: %s\n", attempt+1, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return fmt.Errorf("SendCustomMetrics: %w", errors.Join(err, ctx.Err()))
+		}
+	}
+}
+
+// send makes a single attempt of the request. If the request failed, the returned duration is the delay requested by
+// the Retry-After header or zero if there was none, or negative if the request should not be retried.
+func (c *CustomMetricsClient) send(ctx context.Context, uriString string, token string, jsonBody []byte) (time.Duration, error) {
+	if c.requestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.requestTimeout)
+		defer cancel()
+	}
+
+	request, err := http.NewRequestWithContext(ctx, "POST", uriString, bytes.NewReader(jsonBody))
 	if err != nil {
-		return fmt.Errorf("SendCustomMetrics: failed to create request: %w", err)
+		return -1, fmt.Errorf("failed to create request: %w", err)
 	}
 	request.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
 	request.Header.Add("Content-Type", "application/json")
+	if c.userAgent != "" {
+		request.Header.Set("User-Agent", c.userAgent)
+	}
 
 	res, err := c.httpClient.Do(request)
 	if err != nil {
-		return fmt.Errorf("SendCustomMetrics: failed to send request: %w", err)
+		// Network errors and timeouts of the attempt are retried. They aren't rejections, as the service never answered.
+		return 0, fmt.Errorf("failed to send request: %w", err)
 	}
 	defer func(Body io.ReadCloser) {
 		_ = Body.Close()
@@ -77,16 +251,70 @@ func (c *CustomMetricsClient) SendCustomMetrics(ctx context.Context, scopeResour
 
 	bodyBytes, err := io.ReadAll(res.Body)
 	if err != nil {
-		return fmt.Errorf("SendCustomMetrics: failed to read response body: %w", err)
+		return 0, fmt.Errorf("failed to read response body: %w", err)
 	}
 
-	// Convert body to string for logging or error message
-	bodyString := string(bodyBytes)
+	if res.StatusCode == http.StatusOK {
+		return 0, nil
+	}
+
+	respErr := newCustomMetricsError(res, bodyBytes)
+	if !isRetryableStatus(res.StatusCode) {
+		return -1, respErr
+	}
+	return parseRetryAfter(res.Header.Get("Retry-After"), time.Now()), respErr
+}
 
-	// Check for non-200 status code
-	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("SendCustomMetrics: request failed: status %d, %s, response body: %s", res.StatusCode, res.Status, bodyString)
+func newCustomMetricsError(res *http.Response, body []byte) *CustomMetricsError {
+	respErr := CustomMetricsError{
+		StatusCode: res.StatusCode,
+		Status:     res.Status,
+		Body:       string(body),
+	}
+	var errBody struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(body, &errBody) == nil {
+		respErr.Code = errBody.Error.Code
+		respErr.Message = errBody.Error.Message
 	}
+	return &respErr
+}
 
-	return nil
+func isRetryableStatus(statusCode int) bool {
+	return statusCode == http.StatusRequestTimeout || statusCode == http.StatusTooManyRequests || statusCode >= 500
+}
+
+// parseRetryAfter parses a Retry-After header given in seconds or as a http date. Returns zero if the header is not set or invalid.
+func parseRetryAfter(header string, now time.Time) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(header); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
+
+// delay returns how long to wait before the next attempt. Retry-After takes precedence over the exponential backoff.
+func (p RetryPolicy) delay(attempt int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		return retryAfter
+	}
+	delay := p.InitialDelay
+	for i := 0; i < attempt && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, p.MaxDelay)
+	// Up to 10% jitter, so that concurrent clients don't retry in lockstep
+	if delay > 0 {
+		delay += rand.N(delay/10 + 1)
+	}
+	return delay
 }
\ No newline at end of file
@@ -0,0 +1,276 @@
+package kql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/DrBushytop/amag/pkg/auth"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type fakeCredential struct{}
+
+func (fakeCredential) GetToken(_ context.Context, _ policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	return azcore.AccessToken{Token: "token", ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+func TestSendCustomMetrics(t *testing.T) {
+	const scope = "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm"
+	tests := []struct {
+		name      string
+		responses []int
+		header    http.Header
+		wantCalls int32
+		wantErr   error
+	}{
+		{name: "success", responses: []int{http.StatusOK}, wantCalls: 1},
+		{name: "retried after throttling", responses: []int{http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusOK}, wantCalls: 3},
+		{name: "retries exhausted", responses: []int{http.StatusInternalServerError}, wantCalls: 3, wantErr: ErrUploadRejected},
+		{name: "bad request not retried", responses: []int{http.StatusBadRequest}, wantCalls: 1, wantErr: ErrUploadRejected},
+		{name: "forbidden not retried", responses: []int{http.StatusForbidden}, wantCalls: 1, wantErr: ErrAuthFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			var calls atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				call := calls.Add(1)
+				if r.URL.Path != scope+"/metrics" {
+					t.Errorf("request path = %s, want %s/metrics", r.URL.Path, scope)
+				}
+				if got := r.Header.Get("Authorization"); got != "Bearer token" {
+					t.Errorf("Authorization header = %q, want Bearer token", got)
+				}
+				if got := r.Header.Get("User-Agent"); got != "amag-test" {
+					t.Errorf("User-Agent header = %q, want amag-test", got)
+				}
+				var body CustomMetricBody
+				if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+					t.Errorf("failed to decode request body: %v", err)
+				}
+
+				status := tt.responses[min(int(call), len(tt.responses))-1]
+				if status != http.StatusOK {
+					w.Header().Set("Retry-After", "0")
+					w.WriteHeader(status)
+					_, _ = w.Write([]byte(`{"error":{"code":"Error","message":"failed"}}`))
+				}
+			}))
+			defer server.Close()
+
+			authClient, err := auth.NewAuthClient(auth.WithCredential(fakeCredential{}))
+			if err != nil {
+				t.Fatalf("NewAuthClient() error = %v", err)
+			}
+			c, err := NewCustomMetricsClient(
+				WithAuthClient(authClient),
+				WithHttpClient(server.Client()),
+				WithBaseURL(server.URL),
+				WithUserAgent("amag-test"),
+				WithRetryPolicy(RetryPolicy{MaxRetries: 2, InitialDelay: time.Millisecond, MaxDelay: time.Millisecond}),
+			)
+			if err != nil {
+				t.Fatalf("NewCustomMetricsClient() error = %v", err)
+			}
+
+			err = c.SendCustomMetrics(context.Background(), scope, "westeurope", CustomMetricBody{})
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+				t.Errorf("SendCustomMetrics() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				var respErr *CustomMetricsError
+				if !errors.As(err, &respErr) || respErr.Code != "Error" {
+					t.Errorf("SendCustomMetrics() error = %v, want CustomMetricsError with parsed body", err)
+				}
+			}
+			if got := calls.Load(); got != tt.wantCalls {
+				t.Errorf("SendCustomMetrics() calls = %d, want %d", got, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestSendCustomMetricsUnreachable(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewServer(http.NotFoundHandler())
+	baseURL := server.URL
+	server.Close()
+
+	authClient, err := auth.NewAuthClient(auth.WithCredential(fakeCredential{}))
+	if err != nil {
+		t.Fatalf("NewAuthClient() error = %v", err)
+	}
+	c, err := NewCustomMetricsClient(
+		WithAuthClient(authClient),
+		WithBaseURL(baseURL),
+		WithRetryPolicy(RetryPolicy{MaxRetries: 1, InitialDelay: time.Millisecond, MaxDelay: time.Millisecond}),
+	)
+	if err != nil {
+		t.Fatalf("NewCustomMetricsClient() error = %v", err)
+	}
+	err = c.SendCustomMetrics(context.Background(), "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm", "westeurope", CustomMetricBody{})
+	if err == nil {
+		t.Fatal("SendCustomMetrics() error = nil, want connection error")
+	}
+	if errors.Is(err, ErrUploadRejected) {
+		t.Errorf("SendCustomMetrics() error = %v, want no %v for an unreachable service", err, ErrUploadRejected)
+	}
+}
+
+func TestMetricsURL(t *testing.T) {
+	const scope = "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm"
+	authClient, err := auth.NewAuthClient(auth.WithCredential(fakeCredential{}))
+	if err != nil {
+		t.Fatalf("NewAuthClient() error = %v", err)
+	}
+	tests := []struct {
+		name string
+		opts []CustomMetricClientOption
+		want string
+	}{
+		{name: "public cloud", want: "https://westeurope.monitoring.azure.com" + scope + "/metrics"},
+		{name: "us government", opts: []CustomMetricClientOption{WithCustomMetricsCloud(auth.CloudUSGovernment)}, want: "https://westeurope.monitoring.azure.us" + scope + "/metrics"},
+		{name: "base url", opts: []CustomMetricClientOption{WithBaseURL("http://localhost:8080/")}, want: "http://localhost:8080" + scope + "/metrics"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			c, err := NewCustomMetricsClient(append([]CustomMetricClientOption{WithAuthClient(authClient)}, tt.opts...)...)
+			if err != nil {
+				t.Fatalf("NewCustomMetricsClient() error = %v", err)
+			}
+			if got := c.MetricsURL(scope, "westeurope"); got != tt.want {
+				t.Errorf("MetricsURL() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewCustomMetricsClientOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []CustomMetricClientOption
+		wantErr bool
+	}{
+		{name: "valid options", opts: []CustomMetricClientOption{WithBaseURL("http://localhost:8080/"), WithUserAgent("test")}},
+		{name: "invalid base url", opts: []CustomMetricClientOption{WithBaseURL("localhost")}, wantErr: true},
+		{name: "negative retries", opts: []CustomMetricClientOption{WithRetryPolicy(RetryPolicy{MaxRetries: -1})}, wantErr: true},
+		{name: "nil http client", opts: []CustomMetricClientOption{WithHttpClient(nil)}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			authClient, err := auth.NewAuthClient(auth.WithCredential(fakeCredential{}))
+			if err != nil {
+				t.Fatalf("NewAuthClient() error = %v", err)
+			}
+			_, err = NewCustomMetricsClient(append([]CustomMetricClientOption{WithAuthClient(authClient)}, tt.opts...)...)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewCustomMetricsClient() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 9, 20, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		header string
+		want   time.Duration
+	}{
+		{name: "not set", header: "", want: 0},
+		{name: "seconds", header: "5", want: 5 * time.Second},
+		{name: "http date", header: "Fri, 20 Sep 2024 10:00:30 GMT", want: 30 * time.Second},
+		{name: "date in the past", header: "Fri, 20 Sep 2024 09:00:00 GMT", want: 0},
+		{name: "invalid", header: "soon", want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if got := parseRetryAfter(tt.header, now); got != tt.want {
+				t.Errorf("parseRetryAfter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{MaxRetries: 5, InitialDelay: time.Second, MaxDelay: 5 * time.Second}
+	tests := []struct {
+		name       string
+		attempt    int
+		retryAfter time.Duration
+		want       time.Duration
+	}{
+		{name: "first attempt", attempt: 0, want: time.Second},
+		{name: "doubles", attempt: 2, want: 4 * time.Second},
+		{name: "capped", attempt: 4, want: 5 * time.Second},
+		{name: "retry after", attempt: 4, retryAfter: 20 * time.Second, want: 20 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got := policy.delay(tt.attempt, tt.retryAfter)
+			// Allow for the jitter
+			if got < tt.want || got > tt.want+tt.want/10+1 {
+				t.Errorf("delay() = %v, want %v plus up to 10%%", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCustomMetricsError(t *testing.T) {
+	tests := []struct {
+		name        string
+		statusCode  int
+		body        string
+		wantCode    string
+		wantMessage string
+		wantErr     error
+	}{
+		{
+			name:        "azure error body",
+			statusCode:  http.StatusBadRequest,
+			body:        `{"error":{"code":"InvalidPayload","message":"The metric is too old"}}`,
+			wantCode:    "InvalidPayload",
+			wantMessage: "The metric is too old",
+			wantErr:     ErrUploadRejected,
+		},
+		{
+			name:       "plain body",
+			statusCode: http.StatusInternalServerError,
+			body:       "oops",
+			wantErr:    ErrUploadRejected,
+		},
+		{
+			name:       "forbidden",
+			statusCode: http.StatusForbidden,
+			body:       `{"error":{"code":"AuthorizationFailed","message":"no access"}}`,
+			wantCode:   "AuthorizationFailed",
+			wantErr:    ErrAuthFailed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			res := &http.Response{StatusCode: tt.statusCode, Status: http.StatusText(tt.statusCode)}
+			got := newCustomMetricsError(res, []byte(tt.body))
+			if got.Code != tt.wantCode || (tt.wantMessage != "" && got.Message != tt.wantMessage) {
+				t.Errorf("newCustomMetricsError() = %+v, want code %q message %q", got, tt.wantCode, tt.wantMessage)
+			}
+			if got.Body != tt.body {
+				t.Errorf("newCustomMetricsError() body = %q, want %q", got.Body, tt.body)
+			}
+			if !errors.Is(got, tt.wantErr) {
+				t.Errorf("newCustomMetricsError() does not match %v", tt.wantErr)
+			}
+		})
+	}
+}
@@ -1,6 +1,10 @@
 package kql
 
-import "time"
+import (
+	"fmt"
+	"slices"
+	"time"
+)
 
 type CustomMetricBody struct {
 	Time string `json:"time"`
@@ -16,27 +20,105 @@ type CustomMetricBody struct {
 
 type customMetricValues struct {
 	DimValues []string `json:"dimValues"`
-	Min       int      `json:"min"`
-	Max       int      `json:"max"`
-	Sum       int      `json:"sum"`
-	Count     int      `json:"count"`
+	Min       float64  `json:"min"`
+	Max       float64  `json:"max"`
+	Sum       float64  `json:"sum"`
+	Count     int64    `json:"count"`
 }
 
-func NewCustomMetricsBody(metricName string, metricValue float64) CustomMetricBody {
+// newCustomMetricValues creates a series from the pre-aggregated values of the line.
+// Missing min and max default to MetricValue. If MetricSum is missing, MetricValue is treated as the average of MetricCount values.
+func newCustomMetricValues(dimValues []string, line LogLine) customMetricValues {
+	values := customMetricValues{
+		DimValues: dimValues,
+		Min:       line.MetricValue,
+		Max:       line.MetricValue,
+		Count:     1,
+	}
+	if line.MetricCount != nil {
+		values.Count = *line.MetricCount
+	}
+	values.Sum = line.MetricValue * float64(values.Count)
+	if line.MetricSum != nil {
+		values.Sum = *line.MetricSum
+	}
+	if line.MetricMin != nil {
+		values.Min = *line.MetricMin
+	}
+	if line.MetricMax != nil {
+		values.Max = *line.MetricMax
+	}
+	return values
+}
+
+// SelectDimensions returns copies of the lines with only the named dimensions, in the given order.
+// A name that is not a dimension of the lines is an error wrapping ErrColumnMissing.
+func SelectDimensions(lines []LogLine, names []string) ([]LogLine, error) {
+	if len(names) == 0 {
+		return lines, nil
+	}
+
+	selected := make([]LogLine, len(lines))
+	for i, line := range lines {
+		dimensions := make([]Dimension, len(names))
+		for j, name := range names {
+			idx := slices.IndexFunc(line.Dimensions, func(d Dimension) bool { return d.Name == name })
+			if idx < 0 {
+				return nil, fmt.Errorf("SelectDimensions: %w: dimension %s not found in the result", ErrColumnMissing, name)
+			}
+			dimensions[j] = line.Dimensions[idx]
+		}
+		line.Dimensions = dimensions
+		selected[i] = line
+	}
+	return selected, nil
+}
+
+// CustomMetricsMaxAge is how far in the past the timestamp of a custom metric can be. Older metrics are rejected by Azure Monitor.
+const CustomMetricsMaxAge = 20 * time.Minute
+
+// MaxCustomMetricDimensions is the maximum number of dimensions a custom metric can have.
+const MaxCustomMetricDimensions = 10
+
+// NewCustomMetricsBody creates the request body for saving the given lines as a custom metric.
+// The dimensions of the lines are used as the dimension names of the metric, and each line is saved as its own series.
+// If the lines have no dimensions, only the first line is saved, using the metric name as the only dimension.
+func NewCustomMetricsBody(metricName string, lines []LogLine) (CustomMetricBody, error) {
 	body := CustomMetricBody{}
+	if len(lines) == 0 {
+		return body, fmt.Errorf("NewCustomMetricsBody: no values to save: %w", ErrNoRows)
+	}
 
 	body.Time = time.Now().Format(time.RFC3339)
 	body.Data.BaseData.Metric = metricName
 	body.Data.BaseData.Namespace = "CustomMetrics"
-	body.Data.BaseData.DimNames = []string{metricName}
-	body.Data.BaseData.Series = []customMetricValues{
-		{
-			DimValues: []string{metricName},
-			Min:       int(metricValue),
-			Max:       int(metricValue),
-			Sum:       int(metricValue),
-			Count:     1,
-		},
-	}
-	return body
+
+	if len(lines[0].Dimensions) == 0 {
+		body.Data.BaseData.DimNames = []string{metricName}
+		body.Data.BaseData.Series = []customMetricValues{newCustomMetricValues([]string{metricName}, lines[0])}
+		return body, nil
+	}
+
+	if len(lines[0].Dimensions) > MaxCustomMetricDimensions {
+		return body, fmt.Errorf("NewCustomMetricsBody: custom metrics support at most %d dimensions, got %d", MaxCustomMetricDimensions, len(lines[0].Dimensions))
+	}
+
+	dimNames := make([]string, len(lines[0].Dimensions))
+	for i, dim := range lines[0].Dimensions {
+		dimNames[i] = dim.Name
+	}
+	body.Data.BaseData.DimNames = dimNames
+
+	body.Data.BaseData.Series = make([]customMetricValues, len(lines))
+	for i, line := range lines {
+		if len(line.Dimensions) != len(dimNames) {
+			return body, fmt.Errorf("NewCustomMetricsBody: row %d has %d dimensions, expected %d", i, len(line.Dimensions), len(dimNames))
+		}
+		dimValues := make([]string, len(line.Dimensions))
+		for j, dim := range line.Dimensions {
+			dimValues[j] = dim.Value
+		}
+		body.Data.BaseData.Series[i] = newCustomMetricValues(dimValues, line)
+	}
+	return body, nil
 }
\ No newline at end of file
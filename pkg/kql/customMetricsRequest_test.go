@@ -0,0 +1,141 @@
+package kql
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNewCustomMetricsBody(t *testing.T) {
+	tests := []struct {
+		name          string
+		lines         []LogLine
+		wantDimNames  []string
+		wantDimValues [][]string
+		wantErr       bool
+	}{
+		{
+			name:          "no dimensions uses first row",
+			lines:         []LogLine{{MetricValue: 1}, {MetricValue: 2}},
+			wantDimNames:  []string{"Latency"},
+			wantDimValues: [][]string{{"Latency"}},
+		},
+		{
+			name: "one series per row",
+			lines: []LogLine{
+				{MetricValue: 1, Dimensions: []Dimension{{"cloud_RoleName", "api"}, {"ResultCode", "200"}}},
+				{MetricValue: 2, Dimensions: []Dimension{{"cloud_RoleName", "web"}, {"ResultCode", "500"}}},
+			},
+			wantDimNames:  []string{"cloud_RoleName", "ResultCode"},
+			wantDimValues: [][]string{{"api", "200"}, {"web", "500"}},
+		},
+		{
+			name:    "no rows",
+			lines:   []LogLine{},
+			wantErr: true,
+		},
+		{
+			name: "too many dimensions",
+			lines: []LogLine{{Dimensions: []Dimension{
+				{"a", ""}, {"b", ""}, {"c", ""}, {"d", ""}, {"e", ""}, {"f", ""}, {"g", ""}, {"h", ""}, {"i", ""}, {"j", ""}, {"k", ""},
+			}}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := NewCustomMetricsBody("Latency", tt.lines)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewCustomMetricsBody() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(got.Data.BaseData.DimNames, tt.wantDimNames) {
+				t.Errorf("NewCustomMetricsBody() dimNames = %v, want %v", got.Data.BaseData.DimNames, tt.wantDimNames)
+			}
+			var dimValues [][]string
+			for _, series := range got.Data.BaseData.Series {
+				dimValues = append(dimValues, series.DimValues)
+			}
+			if !reflect.DeepEqual(dimValues, tt.wantDimValues) {
+				t.Errorf("NewCustomMetricsBody() dimValues = %v, want %v", dimValues, tt.wantDimValues)
+			}
+		})
+	}
+}
+
+func TestNewCustomMetricValues(t *testing.T) {
+	minValue, maxValue, sum, count := 0.5, 3.0, 12.0, int64(8)
+	tests := []struct {
+		name string
+		line LogLine
+		want customMetricValues
+	}{
+		{
+			name: "single float value",
+			line: LogLine{MetricValue: 0.93},
+			want: customMetricValues{Min: 0.93, Max: 0.93, Sum: 0.93, Count: 1},
+		},
+		{
+			name: "average with count",
+			line: LogLine{MetricValue: 1.5, MetricCount: &count},
+			want: customMetricValues{Min: 1.5, Max: 1.5, Sum: 12, Count: 8},
+		},
+		{
+			name: "pre-aggregated values",
+			line: LogLine{MetricValue: 1.5, MetricMin: &minValue, MetricMax: &maxValue, MetricSum: &sum, MetricCount: &count},
+			want: customMetricValues{Min: 0.5, Max: 3, Sum: 12, Count: 8},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if got := newCustomMetricValues(nil, tt.line); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("newCustomMetricValues() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectDimensions(t *testing.T) {
+	lines := []LogLine{
+		{MetricValue: 1, Dimensions: []Dimension{{"cloud_RoleName", "api"}, {"ResultCode", "200"}, {"Region", "eu"}}},
+	}
+	tests := []struct {
+		name    string
+		names   []string
+		want    []Dimension
+		wantErr bool
+	}{
+		{
+			name: "no names keeps all",
+			want: lines[0].Dimensions,
+		},
+		{
+			name:  "selected in given order",
+			names: []string{"Region", "cloud_RoleName"},
+			want:  []Dimension{{"Region", "eu"}, {"cloud_RoleName", "api"}},
+		},
+		{
+			name:    "missing dimension",
+			names:   []string{"Operation"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := SelectDimensions(lines, tt.names)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SelectDimensions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(got[0].Dimensions, tt.want) {
+				t.Errorf("SelectDimensions() dimensions = %v, want %v", got[0].Dimensions, tt.want)
+			}
+		})
+	}
+}
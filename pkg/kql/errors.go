@@ -0,0 +1,45 @@
+package kql
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/DrBushytop/amag/pkg/auth"
+	"net/http"
+)
+
+// Errors returned by the clients in this package. Returned errors wrap one of these, so they can be checked with errors.Is.
+var (
+	ErrQueryFailed    = errors.New("query failed")
+	ErrNoRows         = errors.New("query returned no rows")
+	ErrColumnMissing  = errors.New("column missing from query result")
+	ErrUploadRejected = errors.New("upload rejected")
+	ErrAuthFailed     = auth.ErrAuthFailed
+)
+
+// wrapError wraps err with the given sentinel error, or with ErrAuthFailed if the service rejected the credential.
+func wrapError(sentinel error, err error) error {
+	if errors.Is(err, ErrAuthFailed) {
+		return err
+	}
+	var respErr *azcore.ResponseError
+	if errors.As(err, &respErr) && isAuthStatus(respErr.StatusCode) {
+		sentinel = ErrAuthFailed
+	}
+	return fmt.Errorf("%w: %w", sentinel, err)
+}
+
+// wrapUploadError wraps the error of an Azure SDK upload with ErrUploadRejected if the service responded with an error,
+// or with ErrAuthFailed if it rejected the credential. Errors that never reached the service, e.g. network errors, are
+// returned as is.
+func wrapUploadError(err error) error {
+	var respErr *azcore.ResponseError
+	if !errors.Is(err, ErrAuthFailed) && !errors.As(err, &respErr) {
+		return err
+	}
+	return wrapError(ErrUploadRejected, err)
+}
+
+func isAuthStatus(statusCode int) bool {
+	return statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden
+}
@@ -5,8 +5,9 @@ import (
 	"os"
 )
 
+// ParseQuery reads the query from a query file, without its front matter block. See QueryMetadata.
 func ParseQuery(relativePath string) (kqlQuery string, err error) {
-	kqlQuery, err = readKqlFile(relativePath)
+	kqlQuery, _, err = ParseQueryFile(relativePath)
 	if err != nil {
 		return "", err
 	}
@@ -1,28 +1,48 @@
 package kql
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	"github.com/Azure/azure-sdk-for-go/sdk/monitor/ingestion/azlogs"
+	"github.com/DrBushytop/amag/pkg/auth"
+	"slices"
+	"strings"
+	"sync"
 )
 
 type ingestClient interface {
 	Upload(ctx context.Context, ruleID string, streamName string, logs []byte, options *azlogs.UploadOptions) (azlogs.UploadResponse, error)
 }
 
+const (
+	// defaultMaxBatchSize keeps uncompressed batches under the 1 MB payload limit of the Logs Ingestion API.
+	defaultMaxBatchSize = 1_000_000
+	// defaultUploadConcurrency is the number of batches uploaded at the same time.
+	defaultUploadConcurrency = 4
+)
+
 type LogsClient struct {
-	cred         azcore.TokenCredential
-	client       ingestClient
-	dcStreamName string
-	dcEndpoint   string
-	dcRuleId     string
+	cred              azcore.TokenCredential
+	client            ingestClient
+	dcStreamName      string
+	dcEndpoint        string
+	dcRuleId          string
+	maxBatchSize      int
+	uploadConcurrency int
+	cloud             auth.Cloud
 }
 
 func NewLogsClient(dcStreamName, dcEndpoint, dcRuleId string, opts ...LogsClientOption) (*LogsClient, error) {
-	logsClient := LogsClient{}
+	logsClient := LogsClient{
+		maxBatchSize:      defaultMaxBatchSize,
+		uploadConcurrency: defaultUploadConcurrency,
+	}
 
 	for _, opt := range opts {
 		err := opt(&logsClient)
@@ -40,7 +60,11 @@ func NewLogsClient(dcStreamName, dcEndpoint, dcRuleId string, opts ...LogsClient
 	}
 
 	if logsClient.client == nil {
-		azClient, err := azlogs.NewClient(dcEndpoint, logsClient.cred, nil)
+		endpoints, err := logsClient.cloud.Endpoints()
+		if err != nil {
+			return nil, fmt.Errorf("NewLogsClient: %w", err)
+		}
+		azClient, err := azlogs.NewClient(dcEndpoint, logsClient.cred, &azlogs.ClientOptions{ClientOptions: azcore.ClientOptions{Cloud: endpoints.Configuration}})
 		if err != nil {
 			return nil, fmt.Errorf("unable to create client: %w", err)
 		}
@@ -70,17 +94,183 @@ func WithIngestCredential(cred azcore.TokenCredential) LogsClientOption {
 	}
 }
 
+// WithIngestCloud requests tokens for the ingestion audience of the given cloud. Defaults to the public cloud.
+func WithIngestCloud(cloud auth.Cloud) LogsClientOption {
+	return func(logsClient *LogsClient) error {
+		logsClient.cloud = cloud
+		return nil
+	}
+}
+
+// WithMaxBatchSize sets the maximum uncompressed size in bytes of a single upload.
+func WithMaxBatchSize(size int) LogsClientOption {
+	return func(logsClient *LogsClient) error {
+		if size <= 0 {
+			return fmt.Errorf("max batch size must be positive, got %d", size)
+		}
+		logsClient.maxBatchSize = size
+		return nil
+	}
+}
+
+// WithUploadConcurrency sets the number of batches uploaded at the same time.
+func WithUploadConcurrency(n int) LogsClientOption {
+	return func(logsClient *LogsClient) error {
+		if n <= 0 {
+			return fmt.Errorf("upload concurrency must be positive, got %d", n)
+		}
+		logsClient.uploadConcurrency = n
+		return nil
+	}
+}
+
+// UploadURL returns the URL of the Logs Ingestion API the entries are uploaded to.
+func (lc *LogsClient) UploadURL() string {
+	return fmt.Sprintf("%s/dataCollectionRules/%s/streams/%s?api-version=2023-01-01", strings.TrimSuffix(lc.dcEndpoint, "/"), lc.dcRuleId, lc.dcStreamName)
+}
+
 func (lc *LogsClient) SaveLogEntryToLogAnalytics(ctx context.Context, entry []AggregateLogEntry) error {
+	return lc.upload(ctx, entry)
+}
+
+// SaveRowsToLogAnalytics saves each row as a log entry with a column for each key of the row.
+// The data collection rule and table must have a matching column for each key that should be kept.
+func (lc *LogsClient) SaveRowsToLogAnalytics(ctx context.Context, rows []map[string]any) error {
+	return lc.upload(ctx, rows)
+}
+
+// BatchUploadError is returned when some of the batches of an upload failed. Batches not listed were uploaded successfully.
+type BatchUploadError struct {
+	Batches int
+	Failed  []BatchError
+}
+
+// BatchError describes a failed batch by its index, and the range of entries it contained.
+type BatchError struct {
+	Batch      int
+	FirstEntry int
+	Entries    int
+	Err        error
+}
 
-	logs, err := json.Marshal(entry)
+func (e *BatchUploadError) Error() string {
+	msgs := make([]string, len(e.Failed))
+	for i, f := range e.Failed {
+		msgs[i] = fmt.Sprintf("batch %d (entries %d-%d): %v", f.Batch, f.FirstEntry, f.FirstEntry+f.Entries-1, f.Err)
+	}
+	return fmt.Sprintf("%d of %d batches failed: %s", len(e.Failed), e.Batches, strings.Join(msgs, "; "))
+}
+
+func (e *BatchUploadError) Unwrap() []error {
+	errs := make([]error, len(e.Failed))
+	for i, f := range e.Failed {
+		errs[i] = f.Err
+	}
+	return errs
+}
+
+type logBatch struct {
+	index      int
+	firstEntry int
+	entries    int
+	data       []byte
+}
+
+// upload splits the entries into batches of at most maxBatchSize bytes, and uploads them gzipped and concurrently.
+func (lc *LogsClient) upload(ctx context.Context, entries any) error {
+	batches, err := lc.batch(entries)
 	if err != nil {
-		return fmt.Errorf("unable to marshal log entry: %w", err)
+		return err
 	}
 
-	_, err = lc.client.Upload(ctx, lc.dcRuleId, lc.dcStreamName, logs, nil)
+	var (
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		failed []BatchError
+		sem    = make(chan struct{}, lc.uploadConcurrency)
+	)
+	for _, b := range batches {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			if err := lc.uploadBatch(ctx, b); err != nil {
+				mu.Lock()
+				failed = append(failed, BatchError{Batch: b.index, FirstEntry: b.firstEntry, Entries: b.entries, Err: err})
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(failed) > 0 {
+		slices.SortFunc(failed, func(a, b BatchError) int { return a.Batch - b.Batch })
+		return fmt.Errorf("unable to upload logs: %w", &BatchUploadError{Batches: len(batches), Failed: failed})
+	}
+	return nil
+}
+
+// batch marshals the entries into json arrays of at most maxBatchSize bytes.
+func (lc *LogsClient) batch(entries any) ([]logBatch, error) {
+	data, err := json.Marshal(entries)
 	if err != nil {
-		return fmt.Errorf("unable to upload logs: %w", err)
+		return nil, fmt.Errorf("unable to marshal log entry: %w", err)
+	}
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("unable to marshal log entry: %w", err)
 	}
 
+	var batches []logBatch
+	current := logBatch{}
+	buf := bytes.Buffer{}
+	flush := func() {
+		if current.entries == 0 {
+			return
+		}
+		buf.WriteByte(']')
+		current.data = slices.Clone(buf.Bytes())
+		batches = append(batches, current)
+		current = logBatch{index: len(batches), firstEntry: current.firstEntry + current.entries}
+		buf.Reset()
+	}
+
+	for i, entry := range raw {
+		// Opening bracket, separator and closing bracket
+		if len(entry)+2 > lc.maxBatchSize {
+			return nil, fmt.Errorf("log entry %d is %d bytes, larger than the maximum batch size of %d bytes", i, len(entry), lc.maxBatchSize)
+		}
+		if current.entries > 0 && buf.Len()+1+len(entry)+1 > lc.maxBatchSize {
+			flush()
+		}
+		if current.entries == 0 {
+			buf.WriteByte('[')
+		} else {
+			buf.WriteByte(',')
+		}
+		buf.Write(entry)
+		current.entries++
+	}
+	flush()
+
+	return batches, nil
+}
+
+func (lc *LogsClient) uploadBatch(ctx context.Context, b logBatch) error {
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	if _, err := zw.Write(b.data); err != nil {
+		return fmt.Errorf("unable to compress logs: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("unable to compress logs: %w", err)
+	}
+
+	_, err := lc.client.Upload(ctx, lc.dcRuleId, lc.dcStreamName, compressed.Bytes(), &azlogs.UploadOptions{ContentEncoding: to.Ptr("gzip")})
+	if err != nil {
+		return wrapUploadError(err)
+	}
 	return nil
 }
\ No newline at end of file
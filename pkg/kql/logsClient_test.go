@@ -0,0 +1,170 @@
+package kql
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/monitor/ingestion/azlogs"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+type fakeIngestClient struct {
+	mu      sync.Mutex
+	uploads [][]map[string]any
+	// failAt fails the upload of any batch containing an entry with this value
+	failAt float64
+	// err fails every upload
+	err error
+}
+
+func (f *fakeIngestClient) Upload(_ context.Context, _ string, _ string, logs []byte, options *azlogs.UploadOptions) (azlogs.UploadResponse, error) {
+	if f.err != nil {
+		return azlogs.UploadResponse{}, f.err
+	}
+	if options == nil || options.ContentEncoding == nil || *options.ContentEncoding != "gzip" {
+		return azlogs.UploadResponse{}, fmt.Errorf("expected gzip content encoding")
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(logs))
+	if err != nil {
+		return azlogs.UploadResponse{}, err
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		return azlogs.UploadResponse{}, err
+	}
+	var entries []map[string]any
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return azlogs.UploadResponse{}, err
+	}
+
+	for _, e := range entries {
+		if f.failAt != 0 && e["Value"] == f.failAt {
+			return azlogs.UploadResponse{}, &azcore.ResponseError{StatusCode: http.StatusBadRequest, ErrorCode: "InvalidStream"}
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.uploads = append(f.uploads, entries)
+	return azlogs.UploadResponse{}, nil
+}
+
+func TestUploadURL(t *testing.T) {
+	lc, err := NewLogsClient("Custom-Amag_CL", "https://amag-abcd.westeurope-1.ingest.monitor.azure.com/", "dcr-123", WithIngestClient(&fakeIngestClient{}))
+	if err != nil {
+		t.Fatalf("NewLogsClient() error = %v", err)
+	}
+	want := "https://amag-abcd.westeurope-1.ingest.monitor.azure.com/dataCollectionRules/dcr-123/streams/Custom-Amag_CL?api-version=2023-01-01"
+	if got := lc.UploadURL(); got != want {
+		t.Errorf("UploadURL() = %s, want %s", got, want)
+	}
+}
+
+func TestSaveRowsToLogAnalytics(t *testing.T) {
+	rows := make([]map[string]any, 10)
+	for i := range rows {
+		rows[i] = map[string]any{"Name": "metric", "Value": float64(i + 1)}
+	}
+	// {"Name":"metric","Value":1} is 27 bytes, so 3 rows fit in a batch of 100 bytes
+	tests := []struct {
+		name         string
+		maxBatchSize int
+		failAt       float64
+		wantUploads  int
+		wantFailed   []int
+		wantErr      bool
+	}{
+		{name: "single batch", maxBatchSize: defaultMaxBatchSize, wantUploads: 1},
+		{name: "multiple batches", maxBatchSize: 100, wantUploads: 4},
+		{name: "failed batch", maxBatchSize: 100, failAt: 5, wantUploads: 3, wantFailed: []int{1}, wantErr: true},
+		{name: "entry larger than batch", maxBatchSize: 20, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			fake := &fakeIngestClient{failAt: tt.failAt}
+			lc, err := NewLogsClient("stream", "endpoint", "rule", WithIngestClient(fake), WithMaxBatchSize(tt.maxBatchSize), WithUploadConcurrency(2))
+			if err != nil {
+				t.Fatalf("NewLogsClient() error = %v", err)
+			}
+
+			err = lc.SaveRowsToLogAnalytics(context.Background(), rows)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SaveRowsToLogAnalytics() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(fake.uploads) != tt.wantUploads {
+				t.Errorf("SaveRowsToLogAnalytics() uploads = %d, want %d", len(fake.uploads), tt.wantUploads)
+			}
+
+			if tt.wantFailed != nil {
+				var batchErr *BatchUploadError
+				if !errors.As(err, &batchErr) {
+					t.Fatalf("SaveRowsToLogAnalytics() error = %v, want BatchUploadError", err)
+				}
+				if len(batchErr.Failed) != len(tt.wantFailed) {
+					t.Fatalf("SaveRowsToLogAnalytics() failed = %+v, want batches %v", batchErr.Failed, tt.wantFailed)
+				}
+				for i, f := range batchErr.Failed {
+					if f.Batch != tt.wantFailed[i] {
+						t.Errorf("SaveRowsToLogAnalytics() failed batch = %d, want %d", f.Batch, tt.wantFailed[i])
+					}
+				}
+				if !errors.Is(err, ErrUploadRejected) {
+					t.Errorf("SaveRowsToLogAnalytics() error = %v, want ErrUploadRejected", err)
+				}
+				return
+			}
+
+			if !tt.wantErr {
+				uploaded := 0
+				for _, u := range fake.uploads {
+					uploaded += len(u)
+				}
+				if uploaded != len(rows) {
+					t.Errorf("SaveRowsToLogAnalytics() uploaded %d rows, want %d", uploaded, len(rows))
+				}
+			}
+		})
+	}
+}
+
+func TestSaveRowsToLogAnalyticsErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		// wantErr is the sentinel the error must match, none if nil
+		wantErr error
+	}{
+		{name: "error response", err: &azcore.ResponseError{StatusCode: http.StatusBadRequest}, wantErr: ErrUploadRejected},
+		{name: "forbidden", err: &azcore.ResponseError{StatusCode: http.StatusForbidden}, wantErr: ErrAuthFailed},
+		{name: "token not acquired", err: fmt.Errorf("%w: no token", ErrAuthFailed), wantErr: ErrAuthFailed},
+		{name: "unreachable", err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			lc, err := NewLogsClient("stream", "endpoint", "rule", WithIngestClient(&fakeIngestClient{err: tt.err}))
+			if err != nil {
+				t.Fatalf("NewLogsClient() error = %v", err)
+			}
+
+			err = lc.SaveRowsToLogAnalytics(context.Background(), []map[string]any{{"Name": "metric", "Value": 1.0}})
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("SaveRowsToLogAnalytics() error = %v, want %v", err, tt.err)
+			}
+			for _, sentinel := range []error{ErrUploadRejected, ErrAuthFailed} {
+				if errors.Is(err, sentinel) != (sentinel == tt.wantErr) {
+					t.Errorf("SaveRowsToLogAnalytics() error = %v, want sentinel %v", err, tt.wantErr)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package kql
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"strings"
+)
+
+// frontMatterDelimiter starts and ends the front matter block of a query file.
+const frontMatterDelimiter = "---"
+
+// QueryMetadata is the configuration a query file carries in its front matter: a leading block of // comments
+// between // --- lines, holding yaml. For example:
+//
+//	// ---
+//	// metric: LatencyP90
+//	// sink: metric
+//	// dimensions: [cloud_RoleName]
+//	// ---
+//	requests | summarize MetricValue = percentile(duration, 90) by cloud_RoleName
+type QueryMetadata struct {
+	Metric    string `yaml:"metric"`
+	Namespace string `yaml:"namespace"`
+	// Unit is saved with log entries and OTLP metrics. Custom metrics have no unit.
+	Unit string `yaml:"unit"`
+	// Dimensions limits the columns used as dimensions of a custom metric to the given ones, in the given order.
+	Dimensions      []string `yaml:"dimensions"`
+	Timespan        string   `yaml:"timespan"`
+	Sink            string   `yaml:"sink"`
+	WorkspaceID     string   `yaml:"workspaceid"`
+	ScopeResourceID string   `yaml:"scoperesourceid"`
+	// Attributes are the resource attributes of OTLP metrics, e.g. service.name.
+	Attributes map[string]string `yaml:"attributes"`
+}
+
+// ParseQueryFile reads a query file, and returns the query without its front matter block, and the metadata in the block.
+// Files without front matter return empty metadata.
+func ParseQueryFile(relativePath string) (string, QueryMetadata, error) {
+	content, err := readKqlFile(relativePath)
+	if err != nil {
+		return "", QueryMetadata{}, err
+	}
+	query, meta, err := parseFrontMatter(content)
+	if err != nil {
+		return "", QueryMetadata{}, fmt.Errorf("invalid front matter in %s: %w", relativePath, err)
+	}
+	return query, meta, nil
+}
+
+// parseFrontMatter splits the leading front matter block from the query. Empty lines before the block are allowed.
+func parseFrontMatter(content string) (string, QueryMetadata, error) {
+	lines := strings.SplitAfter(content, "\n")
+
+	start := 0
+	for start < len(lines) && strings.TrimSpace(lines[start]) == "" {
+		start++
+	}
+	if start == len(lines) || commentText(lines[start]) != frontMatterDelimiter {
+		return content, QueryMetadata{}, nil
+	}
+
+	var yamlLines []string
+	for i := start + 1; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if !strings.HasPrefix(line, "//") {
+			return "", QueryMetadata{}, fmt.Errorf("line %d: expected a // comment or the closing // %s", i+1, frontMatterDelimiter)
+		}
+		text := strings.TrimPrefix(line, "//")
+		if strings.TrimSpace(text) == frontMatterDelimiter {
+			meta, err := decodeMetadata(strings.Join(yamlLines, "\n"))
+			if err != nil {
+				return "", QueryMetadata{}, err
+			}
+			return strings.Join(lines[i+1:], ""), meta, nil
+		}
+		// Remove the single space usually written after //, keeping the indentation of the yaml
+		yamlLines = append(yamlLines, strings.TrimPrefix(text, " "))
+	}
+	return "", QueryMetadata{}, fmt.Errorf("missing closing // %s", frontMatterDelimiter)
+}
+
+func decodeMetadata(data string) (QueryMetadata, error) {
+	var meta QueryMetadata
+	dec := yaml.NewDecoder(bytes.NewBufferString(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&meta); err != nil && !errors.Is(err, io.EOF) {
+		return QueryMetadata{}, err
+	}
+	return meta, nil
+}
+
+// commentText returns the text of a // comment line, or an empty string if the line is not a comment.
+func commentText(line string) string {
+	text, ok := strings.CutPrefix(strings.TrimSpace(line), "//")
+	if !ok {
+		return ""
+	}
+	return strings.TrimSpace(text)
+}
@@ -0,0 +1,82 @@
+package kql
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseFrontMatter(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   string
+		wantQuery string
+		wantMeta  QueryMetadata
+		wantErr   bool
+	}{
+		{
+			name: "front matter",
+			content: `
+// ---
+// metric: LatencyP90
+// namespace: Web
+// unit: Milliseconds
+// timespan: 1h
+// sink: metric
+// dimensions:
+//   - cloud_RoleName
+//   - ResultCode
+// attributes:
+//   service.name: checkout
+// ---
+requests | summarize MetricValue = percentile(duration, 90) by cloud_RoleName, ResultCode`,
+			wantQuery: "requests | summarize MetricValue = percentile(duration, 90) by cloud_RoleName, ResultCode",
+			wantMeta: QueryMetadata{
+				Metric:     "LatencyP90",
+				Namespace:  "Web",
+				Unit:       "Milliseconds",
+				Dimensions: []string{"cloud_RoleName", "ResultCode"},
+				Timespan:   "1h",
+				Sink:       "metric",
+				Attributes: map[string]string{"service.name": "checkout"},
+			},
+		},
+		{
+			name:      "no front matter",
+			content:   "// latency\nrequests | summarize MetricValue = avg(duration)",
+			wantQuery: "// latency\nrequests | summarize MetricValue = avg(duration)",
+		},
+		{
+			name:      "empty front matter",
+			content:   "// ---\n// ---\nrequests",
+			wantQuery: "requests",
+		},
+		{
+			name:    "unknown field",
+			content: "// ---\n// metrc: Latency\n// ---\nrequests",
+			wantErr: true,
+		},
+		{
+			name:    "unclosed",
+			content: "// ---\n// metric: Latency\nrequests",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			query, meta, err := parseFrontMatter(tt.content)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseFrontMatter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if query != tt.wantQuery {
+				t.Errorf("parseFrontMatter() query = %q, want %q", query, tt.wantQuery)
+			}
+			if !reflect.DeepEqual(meta, tt.wantMeta) {
+				t.Errorf("parseFrontMatter() meta = %+v, want %+v", meta, tt.wantMeta)
+			}
+		})
+	}
+}
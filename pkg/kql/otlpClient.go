@@ -0,0 +1,204 @@
+package kql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// OTLPProtocol is the transport of the OTLP exporter, named as in OTEL_EXPORTER_OTLP_PROTOCOL.
+type OTLPProtocol string
+
+const (
+	OTLPProtocolHTTP OTLPProtocol = "http/protobuf"
+	OTLPProtocolGRPC OTLPProtocol = "grpc"
+)
+
+// otlpMetricsPath is appended to the endpoint of the HTTP exporter, unless the endpoint already ends with it.
+const otlpMetricsPath = "/v1/metrics"
+
+// OTLPClient exports metrics to an OpenTelemetry collector, or any other OTLP receiver.
+//
+// Without an endpoint, the endpoint is read from the OTEL_EXPORTER_OTLP_METRICS_ENDPOINT or OTEL_EXPORTER_OTLP_ENDPOINT
+// environment variables, and defaults to localhost. Headers, e.g. for authentication, are read from
+// OTEL_EXPORTER_OTLP_HEADERS, as are the other settings of the standard OTLP exporter environment variables.
+type OTLPClient struct {
+	protocol OTLPProtocol
+	endpoint string
+	headers  map[string]string
+	timeout  time.Duration
+	exporter sdkmetric.Exporter
+}
+
+func NewOTLPClient(ctx context.Context, protocol OTLPProtocol, endpoint string, opts ...OTLPOption) (*OTLPClient, error) {
+	protocol, endpoint, err := normalizeOTLPTarget(protocol, endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("NewOTLPClient: %w", err)
+	}
+	client := OTLPClient{
+		protocol: protocol,
+		endpoint: endpoint,
+		timeout:  defaultRequestTimeout,
+	}
+
+	for _, opt := range opts {
+		err := opt(&client)
+		if err != nil {
+			return nil, fmt.Errorf("NewOTLPClient: failed to apply option: %w", err)
+		}
+	}
+
+	switch client.protocol {
+	case OTLPProtocolHTTP:
+		httpOpts := []otlpmetrichttp.Option{otlpmetrichttp.WithTimeout(client.timeout)}
+		if client.endpoint != "" {
+			httpOpts = append(httpOpts, otlpmetrichttp.WithEndpointURL(client.endpoint))
+		}
+		if len(client.headers) > 0 {
+			httpOpts = append(httpOpts, otlpmetrichttp.WithHeaders(client.headers))
+		}
+		client.exporter, err = otlpmetrichttp.New(ctx, httpOpts...)
+	case OTLPProtocolGRPC:
+		grpcOpts := []otlpmetricgrpc.Option{otlpmetricgrpc.WithTimeout(client.timeout)}
+		if client.endpoint != "" {
+			grpcOpts = append(grpcOpts, otlpmetricgrpc.WithEndpointURL(client.endpoint))
+		}
+		if len(client.headers) > 0 {
+			grpcOpts = append(grpcOpts, otlpmetricgrpc.WithHeaders(client.headers))
+		}
+		client.exporter, err = otlpmetricgrpc.New(ctx, grpcOpts...)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("NewOTLPClient: failed to create exporter: %w", err)
+	}
+
+	return &client, nil
+}
+
+// normalizeOTLPTarget returns the protocol in lower case, defaulting to HTTP, and the endpoint with the metrics path
+// appended for HTTP.
+func normalizeOTLPTarget(protocol OTLPProtocol, endpoint string) (OTLPProtocol, string, error) {
+	protocol = OTLPProtocol(strings.ToLower(string(protocol)))
+	switch protocol {
+	case "":
+		protocol = OTLPProtocolHTTP
+	case OTLPProtocolHTTP, OTLPProtocolGRPC:
+	default:
+		return "", "", fmt.Errorf("unknown protocol %q, expected %q or %q", protocol, OTLPProtocolHTTP, OTLPProtocolGRPC)
+	}
+
+	if endpoint == "" {
+		return protocol, "", nil
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return "", "", fmt.Errorf("invalid endpoint %q, expected a url like http://localhost:4318", endpoint)
+	}
+	if protocol == OTLPProtocolHTTP && !strings.HasSuffix(u.Path, otlpMetricsPath) {
+		u.Path = strings.TrimSuffix(u.Path, "/") + otlpMetricsPath
+	}
+	return protocol, u.String(), nil
+}
+
+type OTLPOption func(client *OTLPClient) error
+
+// WithOTLPHeaders sends the given headers with each export, in addition to the ones in OTEL_EXPORTER_OTLP_HEADERS.
+func WithOTLPHeaders(headers map[string]string) OTLPOption {
+	return func(client *OTLPClient) error {
+		client.headers = headers
+		return nil
+	}
+}
+
+// WithOTLPTimeout sets the timeout of each export, including retries. Defaults to 30 seconds.
+func WithOTLPTimeout(timeout time.Duration) OTLPOption {
+	return func(client *OTLPClient) error {
+		if timeout <= 0 {
+			return fmt.Errorf("timeout must be positive, got %s", timeout)
+		}
+		client.timeout = timeout
+		return nil
+	}
+}
+
+// URL returns where the metrics are exported to, for logging. Without an endpoint, it is the one the exporter reads from
+// the environment or its default.
+func (c *OTLPClient) URL() string {
+	return otlpURL(c.protocol, c.endpoint)
+}
+
+// OTLPURL returns the URL an OTLPClient with the given protocol and endpoint exports to, without creating the client,
+// e.g. for dry runs.
+func OTLPURL(protocol OTLPProtocol, endpoint string) (string, error) {
+	protocol, endpoint, err := normalizeOTLPTarget(protocol, endpoint)
+	if err != nil {
+		return "", fmt.Errorf("OTLPURL: %w", err)
+	}
+	return otlpURL(protocol, endpoint), nil
+}
+
+func otlpURL(protocol OTLPProtocol, endpoint string) string {
+	if endpoint != "" {
+		return endpoint
+	}
+	for _, env := range []string{"OTEL_EXPORTER_OTLP_METRICS_ENDPOINT", "OTEL_EXPORTER_OTLP_ENDPOINT"} {
+		if endpoint := os.Getenv(env); endpoint != "" {
+			return endpoint
+		}
+	}
+	if protocol == OTLPProtocolGRPC {
+		return "localhost:4317"
+	}
+	return "localhost:4318" + otlpMetricsPath
+}
+
+// Export sends the metrics. Throttled and unavailable responses are retried by the exporter until the timeout.
+// Errors of metrics the receiver rejected wrap ErrUploadRejected, unlike errors of receivers that can't be reached.
+func (c *OTLPClient) Export(ctx context.Context, metrics *metricdata.ResourceMetrics) error {
+	if err := c.exporter.Export(ctx, metrics); err != nil {
+		if isOTLPRejection(err) {
+			return fmt.Errorf("Export: %w: %w", ErrUploadRejected, err)
+		}
+		return fmt.Errorf("Export: %w", err)
+	}
+	return nil
+}
+
+// isOTLPRejection reports whether the export failed with a response of the receiver. The HTTP exporter has no error type
+// for responses, so all errors but connection failures and timeouts are responses. Unavailable gRPC receivers can't be
+// told apart from ones that can't be reached, so they don't count.
+func isOTLPRejection(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return false
+	}
+	if s, ok := status.FromError(err); ok {
+		switch s.Code() {
+		case codes.Unavailable, codes.DeadlineExceeded, codes.Canceled:
+			return false
+		}
+	}
+	return true
+}
+
+// Shutdown closes the connection of the exporter.
+func (c *OTLPClient) Shutdown(ctx context.Context) error {
+	if err := c.exporter.Shutdown(ctx); err != nil {
+		return fmt.Errorf("Shutdown: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+package kql
+
+import (
+	"context"
+	"errors"
+	colmetricpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// otlpReceiver records the requests of OTLP exporters, over HTTP and gRPC.
+type otlpReceiver struct {
+	colmetricpb.UnimplementedMetricsServiceServer
+	requests chan *colmetricpb.ExportMetricsServiceRequest
+	// reject makes the receiver reject all requests, with a 400 response or an InvalidArgument status.
+	reject bool
+}
+
+func (r *otlpReceiver) Export(_ context.Context, req *colmetricpb.ExportMetricsServiceRequest) (*colmetricpb.ExportMetricsServiceResponse, error) {
+	if r.reject {
+		return nil, status.Error(codes.InvalidArgument, "invalid metric")
+	}
+	r.requests <- req
+	return &colmetricpb.ExportMetricsServiceResponse{}, nil
+}
+
+func (r *otlpReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != otlpMetricsPath {
+		http.NotFound(w, req)
+		return
+	}
+	if r.reject {
+		http.Error(w, "invalid metric", http.StatusBadRequest)
+		return
+	}
+	body, _ := io.ReadAll(req.Body)
+	var export colmetricpb.ExportMetricsServiceRequest
+	if err := proto.Unmarshal(body, &export); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.requests <- &export
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.WriteHeader(http.StatusOK)
+}
+
+// startOTLPReceiver serves the receiver with the protocol until the test ends, and returns its endpoint.
+func startOTLPReceiver(t *testing.T, protocol OTLPProtocol, receiver *otlpReceiver) string {
+	t.Helper()
+	if protocol == OTLPProtocolHTTP {
+		server := httptest.NewServer(receiver)
+		t.Cleanup(server.Close)
+		return server.URL
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	colmetricpb.RegisterMetricsServiceServer(server, receiver)
+	go func() { _ = server.Serve(lis) }()
+	t.Cleanup(server.Stop)
+	return "http://" + lis.Addr().String()
+}
+
+func TestOTLPExport(t *testing.T) {
+	tests := []struct {
+		name     string
+		protocol OTLPProtocol
+	}{
+		{name: "http", protocol: OTLPProtocolHTTP},
+		{name: "grpc", protocol: OTLPProtocolGRPC},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			receiver := &otlpReceiver{requests: make(chan *colmetricpb.ExportMetricsServiceRequest, 1)}
+			endpoint := startOTLPReceiver(t, tt.protocol, receiver)
+
+			ctx := context.Background()
+			c, err := NewOTLPClient(ctx, tt.protocol, endpoint, WithOTLPTimeout(5*time.Second))
+			if err != nil {
+				t.Fatalf("NewOTLPClient() error = %v", err)
+			}
+			defer func() { _ = c.Shutdown(ctx) }()
+
+			lines := []LogLine{{MetricValue: 1.5, Dimensions: []Dimension{{"cloud_RoleName", "api"}}}}
+			metrics, err := NewOTLPMetrics("latency", "ms", map[string]string{"service.name": "checkout"}, lines, time.Now())
+			if err != nil {
+				t.Fatalf("NewOTLPMetrics() error = %v", err)
+			}
+			if err := c.Export(ctx, metrics); err != nil {
+				t.Fatalf("Export() error = %v", err)
+			}
+
+			req := <-receiver.requests
+			rm := req.GetResourceMetrics()[0]
+			if got := rm.GetResource().GetAttributes()[0]; got.GetKey() != "service.name" || got.GetValue().GetStringValue() != "checkout" {
+				t.Errorf("resource attribute = %v, want service.name=checkout", got)
+			}
+			metric := rm.GetScopeMetrics()[0].GetMetrics()[0]
+			if metric.GetName() != "latency" || metric.GetUnit() != "ms" {
+				t.Errorf("metric = %s (%s), want latency (ms)", metric.GetName(), metric.GetUnit())
+			}
+			point := metric.GetGauge().GetDataPoints()[0]
+			if point.GetAsDouble() != 1.5 {
+				t.Errorf("data point value = %v, want 1.5", point.GetAsDouble())
+			}
+			if attr := point.GetAttributes()[0]; attr.GetKey() != "cloud_RoleName" || attr.GetValue().GetStringValue() != "api" {
+				t.Errorf("data point attribute = %v, want cloud_RoleName=api", attr)
+			}
+		})
+	}
+}
+
+func TestOTLPExportErrors(t *testing.T) {
+	tests := []struct {
+		name         string
+		protocol     OTLPProtocol
+		unreachable  bool
+		wantRejected bool
+	}{
+		{name: "http rejected", protocol: OTLPProtocolHTTP, wantRejected: true},
+		{name: "grpc rejected", protocol: OTLPProtocolGRPC, wantRejected: true},
+		{name: "http unreachable", protocol: OTLPProtocolHTTP, unreachable: true},
+		{name: "grpc unreachable", protocol: OTLPProtocolGRPC, unreachable: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			var endpoint string
+			if tt.unreachable {
+				lis, err := net.Listen("tcp", "127.0.0.1:0")
+				if err != nil {
+					t.Fatal(err)
+				}
+				endpoint = "http://" + lis.Addr().String()
+				_ = lis.Close()
+			} else {
+				receiver := &otlpReceiver{requests: make(chan *colmetricpb.ExportMetricsServiceRequest, 1), reject: true}
+				endpoint = startOTLPReceiver(t, tt.protocol, receiver)
+			}
+
+			ctx := context.Background()
+			c, err := NewOTLPClient(ctx, tt.protocol, endpoint, WithOTLPTimeout(time.Second))
+			if err != nil {
+				t.Fatalf("NewOTLPClient() error = %v", err)
+			}
+			defer func() { _ = c.Shutdown(ctx) }()
+
+			lines := []LogLine{{MetricValue: 1.5}}
+			metrics, err := NewOTLPMetrics("latency", "ms", nil, lines, time.Now())
+			if err != nil {
+				t.Fatalf("NewOTLPMetrics() error = %v", err)
+			}
+			err = c.Export(ctx, metrics)
+			if err == nil {
+				t.Fatal("Export() error = nil, want an error")
+			}
+			if got := errors.Is(err, ErrUploadRejected); got != tt.wantRejected {
+				t.Errorf("Export() error = %v, rejected = %v, want %v", err, got, tt.wantRejected)
+			}
+		})
+	}
+}
+
+func TestNewOTLPMetricsView(t *testing.T) {
+	now := time.Date(2024, 9, 20, 12, 0, 0, 0, time.UTC)
+	lines := []LogLine{{MetricValue: 1.5, Dimensions: []Dimension{{"cloud_RoleName", "api"}}}, {MetricValue: 2}}
+	metrics, err := NewOTLPMetrics("latency", "ms", map[string]string{"service.name": "checkout", "env": "prod"}, lines, now)
+	if err != nil {
+		t.Fatalf("NewOTLPMetrics() error = %v", err)
+	}
+	want := OTLPMetricsView{
+		Resource: map[string]string{"service.name": "checkout", "env": "prod"},
+		DataPoints: []OTLPDataPoint{
+			{Name: "latency", Unit: "ms", Time: now, Value: 1.5, Attributes: map[string]string{"cloud_RoleName": "api"}},
+			{Name: "latency", Unit: "ms", Time: now, Value: 2},
+		},
+	}
+	if got := NewOTLPMetricsView(metrics); !reflect.DeepEqual(got, want) {
+		t.Errorf("NewOTLPMetricsView() = %+v, want %+v", got, want)
+	}
+}
+
+func TestNewOTLPClient(t *testing.T) {
+	tests := []struct {
+		name     string
+		protocol OTLPProtocol
+		endpoint string
+		wantURL  string
+		wantErr  bool
+	}{
+		{name: "http appends metrics path", protocol: OTLPProtocolHTTP, endpoint: "http://collector:4318", wantURL: "http://collector:4318/v1/metrics"},
+		{name: "http keeps metrics path", protocol: "", endpoint: "https://collector/otlp/v1/metrics", wantURL: "https://collector/otlp/v1/metrics"},
+		{name: "grpc", protocol: "GRPC", endpoint: "https://collector:4317", wantURL: "https://collector:4317"},
+		{name: "invalid endpoint", protocol: OTLPProtocolHTTP, endpoint: "collector", wantErr: true},
+		{name: "unknown protocol", protocol: "http/json", endpoint: "http://collector:4318", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			c, err := NewOTLPClient(context.Background(), tt.protocol, tt.endpoint)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewOTLPClient() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := c.URL(); got != tt.wantURL {
+				t.Errorf("URL() = %s, want %s", got, tt.wantURL)
+			}
+			if got, err := OTLPURL(tt.protocol, tt.endpoint); err != nil || got != tt.wantURL {
+				t.Errorf("OTLPURL() = %s, %v, want %s", got, err, tt.wantURL)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package kql
+
+import (
+	"fmt"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/resource"
+	"maps"
+	"slices"
+	"time"
+)
+
+const (
+	// otlpScopeName is the name of the instrumentation scope of exported metrics.
+	otlpScopeName = "github.com/DrBushytop/amag"
+	// serviceNameAttribute is the resource attribute naming the service. It defaults to amag.
+	serviceNameAttribute = "service.name"
+)
+
+// NewOTLPMetrics creates the OTLP resource metrics of a gauge with a data point for each line. The dimensions of a line
+// are the attributes of its data point, and resourceAttributes the attributes of the resource. The TimeGenerated of a line
+// is used as the time of its data point, or timestamp if the line has none.
+func NewOTLPMetrics(metricName string, unit string, resourceAttributes map[string]string, lines []LogLine, timestamp time.Time) (*metricdata.ResourceMetrics, error) {
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("NewOTLPMetrics: %w", ErrNoRows)
+	}
+	if metricName == "" {
+		return nil, fmt.Errorf("NewOTLPMetrics: metric name is required")
+	}
+
+	points := make([]metricdata.DataPoint[float64], len(lines))
+	for i, line := range lines {
+		attrs := make([]attribute.KeyValue, len(line.Dimensions))
+		for k, dim := range line.Dimensions {
+			attrs[k] = attribute.String(dim.Name, dim.Value)
+		}
+		pointTime := timestamp
+		if line.TimeGenerated != nil {
+			pointTime = *line.TimeGenerated
+		}
+		points[i] = metricdata.DataPoint[float64]{
+			Attributes: attribute.NewSet(attrs...),
+			Time:       pointTime,
+			Value:      line.MetricValue,
+		}
+	}
+
+	return &metricdata.ResourceMetrics{
+		Resource: newOTLPResource(resourceAttributes),
+		ScopeMetrics: []metricdata.ScopeMetrics{{
+			Scope: instrumentation.Scope{Name: otlpScopeName},
+			Metrics: []metricdata.Metrics{{
+				Name: metricName,
+				Unit: unit,
+				Data: metricdata.Gauge[float64]{DataPoints: points},
+			}},
+		}},
+	}, nil
+}
+
+func newOTLPResource(attributes map[string]string) *resource.Resource {
+	attrs := []attribute.KeyValue{attribute.String(serviceNameAttribute, defaultUserAgent)}
+	for _, key := range slices.Sorted(maps.Keys(attributes)) {
+		// Later attributes of the same key take precedence, so the default service name is overridden
+		attrs = append(attrs, attribute.String(key, attributes[key]))
+	}
+	return resource.NewSchemaless(attrs...)
+}
+
+// OTLPDataPoint is a data point of exported metrics in a readable form, for dry runs.
+type OTLPDataPoint struct {
+	Name       string            `json:"name"`
+	Unit       string            `json:"unit,omitempty"`
+	Time       time.Time         `json:"time"`
+	Value      float64           `json:"value"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// OTLPMetricsView is the readable form of resource metrics, which don't marshal to json with their attributes.
+type OTLPMetricsView struct {
+	Resource   map[string]string `json:"resource"`
+	DataPoints []OTLPDataPoint   `json:"dataPoints"`
+}
+
+// NewOTLPMetricsView returns the resource attributes and gauge data points of metrics created by NewOTLPMetrics.
+func NewOTLPMetricsView(metrics *metricdata.ResourceMetrics) OTLPMetricsView {
+	view := OTLPMetricsView{Resource: attributeMap(metrics.Resource.Iter())}
+	for _, scope := range metrics.ScopeMetrics {
+		for _, metric := range scope.Metrics {
+			gauge, ok := metric.Data.(metricdata.Gauge[float64])
+			if !ok {
+				continue
+			}
+			for _, point := range gauge.DataPoints {
+				view.DataPoints = append(view.DataPoints, OTLPDataPoint{
+					Name:       metric.Name,
+					Unit:       metric.Unit,
+					Time:       point.Time,
+					Value:      point.Value,
+					Attributes: attributeMap(point.Attributes.Iter()),
+				})
+			}
+		}
+	}
+	return view
+}
+
+func attributeMap(iter attribute.Iterator) map[string]string {
+	if iter.Len() == 0 {
+		return nil
+	}
+	attrs := make(map[string]string, iter.Len())
+	for iter.Next() {
+		kv := iter.Attribute()
+		attrs[string(kv.Key)] = kv.Value.Emit()
+	}
+	return attrs
+}
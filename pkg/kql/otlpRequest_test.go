@@ -0,0 +1,76 @@
+package kql
+
+import (
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNewOTLPMetrics(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	generated := now.Add(-time.Hour)
+	tests := []struct {
+		name         string
+		attributes   map[string]string
+		lines        []LogLine
+		wantResource map[string]string
+		wantPoints   []metricdata.DataPoint[float64]
+		wantErr      bool
+	}{
+		{
+			name:         "dimensions as attributes",
+			lines:        []LogLine{{MetricValue: 1.5, Dimensions: []Dimension{{"cloud_RoleName", "api"}}}, {MetricValue: 2, TimeGenerated: &generated}},
+			wantResource: map[string]string{"service.name": "amag"},
+			wantPoints: []metricdata.DataPoint[float64]{
+				{Attributes: attribute.NewSet(attribute.String("cloud_RoleName", "api")), Time: now, Value: 1.5},
+				{Attributes: attribute.NewSet(), Time: generated, Value: 2},
+			},
+		},
+		{
+			name:         "resource attributes override service name",
+			attributes:   map[string]string{"service.name": "checkout", "deployment.environment": "prod"},
+			lines:        []LogLine{{MetricValue: 1}},
+			wantResource: map[string]string{"service.name": "checkout", "deployment.environment": "prod"},
+			wantPoints:   []metricdata.DataPoint[float64]{{Attributes: attribute.NewSet(), Time: now, Value: 1}},
+		},
+		{
+			name:    "no rows",
+			lines:   []LogLine{},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := NewOTLPMetrics("latency", "ms", tt.attributes, tt.lines, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewOTLPMetrics() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			resource := map[string]string{}
+			for _, kv := range got.Resource.Attributes() {
+				resource[string(kv.Key)] = kv.Value.AsString()
+			}
+			if !reflect.DeepEqual(resource, tt.wantResource) {
+				t.Errorf("NewOTLPMetrics() resource = %v, want %v", resource, tt.wantResource)
+			}
+
+			metric := got.ScopeMetrics[0].Metrics[0]
+			if metric.Name != "latency" || metric.Unit != "ms" {
+				t.Errorf("NewOTLPMetrics() metric = %s (%s), want latency (ms)", metric.Name, metric.Unit)
+			}
+			gauge, ok := metric.Data.(metricdata.Gauge[float64])
+			if !ok {
+				t.Fatalf("NewOTLPMetrics() data = %T, want a gauge", metric.Data)
+			}
+			if !reflect.DeepEqual(gauge.DataPoints, tt.wantPoints) {
+				t.Errorf("NewOTLPMetrics() data points = %+v, want %+v", gauge.DataPoints, tt.wantPoints)
+			}
+		})
+	}
+}
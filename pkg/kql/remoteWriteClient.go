@@ -0,0 +1,212 @@
+package kql
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/golang/snappy"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// RemoteWriteClient sends metrics to a Prometheus remote write endpoint, such as Prometheus, Mimir, Thanos or the Azure
+// Monitor managed service for Prometheus.
+type RemoteWriteClient struct {
+	url            string
+	httpClient     *http.Client
+	retryPolicy    RetryPolicy
+	requestTimeout time.Duration
+	userAgent      string
+	username       string
+	password       string
+	bearerToken    string
+}
+
+// RemoteWriteError is returned when the remote write endpoint responds with an error status.
+type RemoteWriteError struct {
+	StatusCode int
+	Status     string
+	Body       string
+}
+
+func (e *RemoteWriteError) Error() string {
+	return fmt.Sprintf("status %d, %s, response body: %s", e.StatusCode, e.Status, e.Body)
+}
+
+// Unwrap returns ErrAuthFailed for 401 and 403 responses, and ErrUploadRejected otherwise.
+func (e *RemoteWriteError) Unwrap() error {
+	if isAuthStatus(e.StatusCode) {
+		return ErrAuthFailed
+	}
+	return ErrUploadRejected
+}
+
+func NewRemoteWriteClient(remoteWriteURL string, opts ...RemoteWriteOption) (*RemoteWriteClient, error) {
+	u, err := url.Parse(remoteWriteURL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("NewRemoteWriteClient: invalid remote write url %q", remoteWriteURL)
+	}
+
+	client := RemoteWriteClient{
+		url:            remoteWriteURL,
+		httpClient:     http.DefaultClient,
+		retryPolicy:    DefaultRetryPolicy(),
+		requestTimeout: defaultRequestTimeout,
+		userAgent:      defaultUserAgent,
+	}
+
+	for _, opt := range opts {
+		err := opt(&client)
+		if err != nil {
+			return nil, fmt.Errorf("NewRemoteWriteClient: failed to apply option: %w", err)
+		}
+	}
+
+	if client.username != "" && client.bearerToken != "" {
+		return nil, fmt.Errorf("NewRemoteWriteClient: basic auth and bearer token are mutually exclusive")
+	}
+
+	return &client, nil
+}
+
+type RemoteWriteOption func(client *RemoteWriteClient) error
+
+// WithBasicAuth authenticates the requests with the given username and password.
+func WithBasicAuth(username, password string) RemoteWriteOption {
+	return func(client *RemoteWriteClient) error {
+		if username == "" {
+			return fmt.Errorf("username must not be empty")
+		}
+		client.username = username
+		client.password = password
+		return nil
+	}
+}
+
+// WithBearerToken authenticates the requests with the given bearer token.
+func WithBearerToken(token string) RemoteWriteOption {
+	return func(client *RemoteWriteClient) error {
+		if token == "" {
+			return fmt.Errorf("bearer token must not be empty")
+		}
+		client.bearerToken = token
+		return nil
+	}
+}
+
+func WithRemoteWriteHttpClient(httpClient *http.Client) RemoteWriteOption {
+	return func(client *RemoteWriteClient) error {
+		if httpClient == nil {
+			return fmt.Errorf("http client must not be nil")
+		}
+		client.httpClient = httpClient
+		return nil
+	}
+}
+
+// WithRemoteWriteRetryPolicy sets how failed requests are retried. A policy with MaxRetries 0 disables retries.
+func WithRemoteWriteRetryPolicy(policy RetryPolicy) RemoteWriteOption {
+	return func(client *RemoteWriteClient) error {
+		if policy.MaxRetries < 0 || policy.InitialDelay < 0 || policy.MaxDelay < 0 {
+			return fmt.Errorf("invalid retry policy: %+v", policy)
+		}
+		client.retryPolicy = policy
+		return nil
+	}
+}
+
+// WithRemoteWriteUserAgent sets the User-Agent header of the requests. Defaults to amag.
+func WithRemoteWriteUserAgent(userAgent string) RemoteWriteOption {
+	return func(client *RemoteWriteClient) error {
+		client.userAgent = userAgent
+		return nil
+	}
+}
+
+// WithRemoteWriteRequestTimeout sets the timeout of each attempt of a request. Zero disables the timeout.
+func WithRemoteWriteRequestTimeout(timeout time.Duration) RemoteWriteOption {
+	return func(client *RemoteWriteClient) error {
+		if timeout < 0 {
+			return fmt.Errorf("request timeout must not be negative, got %s", timeout)
+		}
+		client.requestTimeout = timeout
+		return nil
+	}
+}
+
+// Write sends the request as a snappy compressed protobuf message. Failed requests are retried according to the retry policy.
+func (c *RemoteWriteClient) Write(ctx context.Context, req RemoteWriteRequest) error {
+	body := snappy.Encode(nil, req.Marshal())
+
+	for attempt := 0; ; attempt++ {
+		retryAfter, err := c.send(ctx, body)
+		if err == nil {
+			return nil
+		}
+		if retryAfter < 0 || attempt >= c.retryPolicy.MaxRetries || ctx.Err() != nil {
+			return fmt.Errorf("Write: %w", err)
+		}
+
+		delay := c.retryPolicy.delay(attempt, retryAfter)
+		log.Printf("Write: attempt %d failed, retrying in %s: %s\n", attempt+1, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return fmt.Errorf("Write: %w", errors.Join(err, ctx.Err()))
+		}
+	}
+}
+
+// send makes a single attempt of the request. See CustomMetricsClient.send for the returned duration.
+func (c *RemoteWriteClient) send(ctx context.Context, body []byte) (time.Duration, error) {
+	if c.requestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.requestTimeout)
+		defer cancel()
+	}
+
+	request, err := http.NewRequestWithContext(ctx, "POST", c.url, bytes.NewReader(body))
+	if err != nil {
+		return -1, fmt.Errorf("failed to create request: %w", err)
+	}
+	request.Header.Set("Content-Encoding", "snappy")
+	request.Header.Set("Content-Type", "application/x-protobuf")
+	request.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if c.userAgent != "" {
+		request.Header.Set("User-Agent", c.userAgent)
+	}
+	switch {
+	case c.username != "":
+		request.SetBasicAuth(c.username, c.password)
+	case c.bearerToken != "":
+		request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.bearerToken))
+	}
+
+	res, err := c.httpClient.Do(request)
+	if err != nil {
+		// Network errors and timeouts of the attempt are retried. They aren't rejections, as the endpoint never answered.
+		return 0, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(res.Body)
+
+	bodyBytes, err := io.ReadAll(res.Body)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return 0, nil
+	}
+
+	respErr := &RemoteWriteError{StatusCode: res.StatusCode, Status: res.Status, Body: string(bodyBytes)}
+	if !isRetryableStatus(res.StatusCode) {
+		return -1, respErr
+	}
+	return parseRetryAfter(res.Header.Get("Retry-After"), time.Now()), respErr
+}
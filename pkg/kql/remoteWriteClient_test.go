@@ -0,0 +1,126 @@
+package kql
+
+import (
+	"context"
+	"errors"
+	"github.com/golang/snappy"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRemoteWrite(t *testing.T) {
+	req := RemoteWriteRequest{Timeseries: []TimeSeries{{
+		Labels:  []Label{{Name: "__name__", Value: "latency"}, {Name: "role", Value: "api"}},
+		Samples: []Sample{{Value: 1.5, Timestamp: 1714564800000}},
+	}}}
+	tests := []struct {
+		name      string
+		opts      []RemoteWriteOption
+		wantAuth  string
+		responses []int
+		wantCalls int32
+		wantErr   error
+	}{
+		{name: "success without auth", responses: []int{http.StatusNoContent}, wantCalls: 1},
+		{name: "basic auth", opts: []RemoteWriteOption{WithBasicAuth("user", "pass")}, wantAuth: "Basic dXNlcjpwYXNz", responses: []int{http.StatusOK}, wantCalls: 1},
+		{name: "bearer token", opts: []RemoteWriteOption{WithBearerToken("token")}, wantAuth: "Bearer token", responses: []int{http.StatusOK}, wantCalls: 1},
+		{name: "retried after throttling", responses: []int{http.StatusTooManyRequests, http.StatusOK}, wantCalls: 2},
+		{name: "retries exhausted", responses: []int{http.StatusServiceUnavailable}, wantCalls: 3, wantErr: ErrUploadRejected},
+		{name: "bad request not retried", responses: []int{http.StatusBadRequest}, wantCalls: 1, wantErr: ErrUploadRejected},
+		{name: "unauthorized not retried", responses: []int{http.StatusUnauthorized}, wantCalls: 1, wantErr: ErrAuthFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			var calls atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				call := calls.Add(1)
+				if got := r.Header.Get("Authorization"); got != tt.wantAuth {
+					t.Errorf("Authorization header = %q, want %q", got, tt.wantAuth)
+				}
+				if got := r.Header.Get("Content-Encoding"); got != "snappy" {
+					t.Errorf("Content-Encoding header = %q, want snappy", got)
+				}
+				if got := r.Header.Get("X-Prometheus-Remote-Write-Version"); got != "0.1.0" {
+					t.Errorf("X-Prometheus-Remote-Write-Version header = %q, want 0.1.0", got)
+				}
+				compressed, _ := io.ReadAll(r.Body)
+				body, err := snappy.Decode(nil, compressed)
+				if err != nil {
+					t.Errorf("failed to decompress request body: %v", err)
+				}
+				got, err := unmarshalRemoteWriteRequest(body)
+				if err != nil || !reflect.DeepEqual(got, req) {
+					t.Errorf("request body = %+v, %v, want %+v", got, err, req)
+				}
+
+				status := tt.responses[min(int(call), len(tt.responses))-1]
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(status)
+			}))
+			defer server.Close()
+
+			opts := append([]RemoteWriteOption{
+				WithRemoteWriteHttpClient(server.Client()),
+				WithRemoteWriteRetryPolicy(RetryPolicy{MaxRetries: 2, InitialDelay: time.Millisecond, MaxDelay: time.Millisecond}),
+			}, tt.opts...)
+			c, err := NewRemoteWriteClient(server.URL+"/api/v1/write", opts...)
+			if err != nil {
+				t.Fatalf("NewRemoteWriteClient() error = %v", err)
+			}
+
+			err = c.Write(context.Background(), req)
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+				t.Errorf("Write() error = %v, want %v", err, tt.wantErr)
+			}
+			if got := calls.Load(); got != tt.wantCalls {
+				t.Errorf("Write() made %d calls, want %d", got, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestRemoteWriteUnreachable(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewServer(http.NotFoundHandler())
+	url := server.URL + "/api/v1/write"
+	server.Close()
+
+	c, err := NewRemoteWriteClient(url, WithRemoteWriteRetryPolicy(RetryPolicy{MaxRetries: 1, InitialDelay: time.Millisecond, MaxDelay: time.Millisecond}))
+	if err != nil {
+		t.Fatalf("NewRemoteWriteClient() error = %v", err)
+	}
+	err = c.Write(context.Background(), RemoteWriteRequest{})
+	if err == nil {
+		t.Fatal("Write() error = nil, want connection error")
+	}
+	if errors.Is(err, ErrUploadRejected) {
+		t.Errorf("Write() error = %v, want no %v for an unreachable endpoint", err, ErrUploadRejected)
+	}
+}
+
+func TestNewRemoteWriteClient(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		opts    []RemoteWriteOption
+		wantErr bool
+	}{
+		{name: "valid", url: "https://prometheus.example.com/api/v1/write"},
+		{name: "invalid url", url: "prometheus", wantErr: true},
+		{name: "basic auth and bearer token", url: "https://prometheus.example.com/api/v1/write", opts: []RemoteWriteOption{WithBasicAuth("user", "pass"), WithBearerToken("token")}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if _, err := NewRemoteWriteClient(tt.url, tt.opts...); (err != nil) != tt.wantErr {
+				t.Errorf("NewRemoteWriteClient() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package kql
+
+import (
+	"fmt"
+	"google.golang.org/protobuf/encoding/protowire"
+	"math"
+	"slices"
+	"strings"
+	"time"
+)
+
+// RemoteWriteRequest is a Prometheus remote write request, in the 1.0 protocol. Marshal encodes it as protobuf.
+type RemoteWriteRequest struct {
+	Timeseries []TimeSeries `json:"timeseries"`
+}
+
+// TimeSeries is a single series of a remote write request. Labels are sorted by name, and include the metric name as __name__.
+type TimeSeries struct {
+	Labels  []Label  `json:"labels"`
+	Samples []Sample `json:"samples"`
+}
+
+type Label struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Sample is a value of a series, with the timestamp in milliseconds since the epoch.
+type Sample struct {
+	Value     float64 `json:"value"`
+	Timestamp int64   `json:"timestamp"`
+}
+
+// metricNameLabel is the label holding the metric name of a series.
+const metricNameLabel = "__name__"
+
+// NewRemoteWriteRequest creates a remote write request with a series for each line, labelled with the dimensions of the line.
+// Metric and label names are sanitized to the characters Prometheus allows. The TimeGenerated of a line is used as the time
+// of its sample, or timestamp if the line has none.
+func NewRemoteWriteRequest(metricName string, lines []LogLine, timestamp time.Time) (RemoteWriteRequest, error) {
+	if len(lines) == 0 {
+		return RemoteWriteRequest{}, fmt.Errorf("NewRemoteWriteRequest: %w", ErrNoRows)
+	}
+	name := sanitizeMetricName(metricName)
+	if name == "" {
+		return RemoteWriteRequest{}, fmt.Errorf("NewRemoteWriteRequest: metric name is required")
+	}
+
+	req := RemoteWriteRequest{Timeseries: make([]TimeSeries, len(lines))}
+	for i, line := range lines {
+		labels := []Label{{Name: metricNameLabel, Value: name}}
+		for _, dim := range line.Dimensions {
+			labelName := sanitizeLabelName(dim.Name)
+			if slices.ContainsFunc(labels, func(l Label) bool { return l.Name == labelName }) {
+				return RemoteWriteRequest{}, fmt.Errorf("NewRemoteWriteRequest: dimension %s maps to the duplicate label %s", dim.Name, labelName)
+			}
+			labels = append(labels, Label{Name: labelName, Value: dim.Value})
+		}
+		slices.SortFunc(labels, func(a, b Label) int { return strings.Compare(a.Name, b.Name) })
+
+		sampleTime := timestamp
+		if line.TimeGenerated != nil {
+			sampleTime = *line.TimeGenerated
+		}
+		req.Timeseries[i] = TimeSeries{
+			Labels:  labels,
+			Samples: []Sample{{Value: line.MetricValue, Timestamp: sampleTime.UnixMilli()}},
+		}
+	}
+	return req, nil
+}
+
+// Marshal encodes the request as a prometheus.WriteRequest protobuf message.
+func (r RemoteWriteRequest) Marshal() []byte {
+	var b []byte
+	for _, ts := range r.Timeseries {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, ts.marshal())
+	}
+	return b
+}
+
+func (ts TimeSeries) marshal() []byte {
+	var b []byte
+	for _, l := range ts.Labels {
+		var label []byte
+		label = protowire.AppendTag(label, 1, protowire.BytesType)
+		label = protowire.AppendString(label, l.Name)
+		label = protowire.AppendTag(label, 2, protowire.BytesType)
+		label = protowire.AppendString(label, l.Value)
+
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, label)
+	}
+	for _, s := range ts.Samples {
+		var sample []byte
+		sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+		sample = protowire.AppendFixed64(sample, math.Float64bits(s.Value))
+		sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+		sample = protowire.AppendVarint(sample, uint64(s.Timestamp))
+
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendBytes(b, sample)
+	}
+	return b
+}
+
+// sanitizeMetricName replaces the characters not allowed in Prometheus metric names with underscores.
+func sanitizeMetricName(name string) string {
+	return sanitizeName(name, true)
+}
+
+// sanitizeLabelName replaces the characters not allowed in Prometheus label names with underscores.
+func sanitizeLabelName(name string) string {
+	return sanitizeName(name, false)
+}
+
+func sanitizeName(name string, allowColon bool) string {
+	var b strings.Builder
+	for i, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_', r == ':' && allowColon:
+			b.WriteRune(r)
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				b.WriteRune('_')
+			}
+			b.WriteRune(r)
+		default:
+			b.WriteRune('_')
+		}
+	}
+	return b.String()
+}
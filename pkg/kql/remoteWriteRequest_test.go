@@ -0,0 +1,166 @@
+package kql
+
+import (
+	"fmt"
+	"google.golang.org/protobuf/encoding/protowire"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNewRemoteWriteRequest(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	generated := now.Add(-time.Hour)
+	tests := []struct {
+		name       string
+		metricName string
+		lines      []LogLine
+		want       RemoteWriteRequest
+		wantErr    bool
+	}{
+		{
+			name:       "dimensions as sorted labels",
+			metricName: "request_latency",
+			lines: []LogLine{
+				{MetricValue: 1.5, Dimensions: []Dimension{{"cloud_RoleName", "api"}, {"ResultCode", "200"}}},
+				{MetricValue: 2, TimeGenerated: &generated},
+			},
+			want: RemoteWriteRequest{Timeseries: []TimeSeries{
+				{
+					Labels: []Label{
+						{Name: "ResultCode", Value: "200"},
+						{Name: "__name__", Value: "request_latency"},
+						{Name: "cloud_RoleName", Value: "api"},
+					},
+					Samples: []Sample{{Value: 1.5, Timestamp: now.UnixMilli()}},
+				},
+				{
+					Labels:  []Label{{Name: "__name__", Value: "request_latency"}},
+					Samples: []Sample{{Value: 2, Timestamp: generated.UnixMilli()}},
+				},
+			}},
+		},
+		{
+			name:       "names sanitized",
+			metricName: "Latency P90 (ms)",
+			lines:      []LogLine{{MetricValue: 1, Dimensions: []Dimension{{"1st.role", "api"}}}},
+			want: RemoteWriteRequest{Timeseries: []TimeSeries{{
+				Labels: []Label{
+					{Name: "_1st_role", Value: "api"},
+					{Name: "__name__", Value: "Latency_P90__ms_"},
+				},
+				Samples: []Sample{{Value: 1, Timestamp: now.UnixMilli()}},
+			}}},
+		},
+		{
+			name:       "duplicate labels",
+			metricName: "latency",
+			lines:      []LogLine{{Dimensions: []Dimension{{"role.name", "a"}, {"role_name", "b"}}}},
+			wantErr:    true,
+		},
+		{
+			name:       "no rows",
+			metricName: "latency",
+			lines:      []LogLine{},
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := NewRemoteWriteRequest(tt.metricName, tt.lines, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewRemoteWriteRequest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NewRemoteWriteRequest() = %+v, want %+v", got, tt.want)
+			}
+
+			decoded, err := unmarshalRemoteWriteRequest(got.Marshal())
+			if err != nil {
+				t.Fatalf("failed to decode marshalled request: %v", err)
+			}
+			if !reflect.DeepEqual(decoded, tt.want) {
+				t.Errorf("Marshal() decoded = %+v, want %+v", decoded, tt.want)
+			}
+		})
+	}
+}
+
+// unmarshalRemoteWriteRequest decodes a prometheus.WriteRequest message, for checking what Marshal encodes.
+func unmarshalRemoteWriteRequest(b []byte) (RemoteWriteRequest, error) {
+	var req RemoteWriteRequest
+	err := consumeMessage(b, func(num protowire.Number, v []byte, _ uint64) error {
+		var ts TimeSeries
+		err := consumeMessage(v, func(num protowire.Number, v []byte, _ uint64) error {
+			switch num {
+			case 1:
+				var l Label
+				err := consumeMessage(v, func(num protowire.Number, v []byte, _ uint64) error {
+					if num == 1 {
+						l.Name = string(v)
+					} else {
+						l.Value = string(v)
+					}
+					return nil
+				})
+				ts.Labels = append(ts.Labels, l)
+				return err
+			case 2:
+				var s Sample
+				err := consumeMessage(v, func(num protowire.Number, _ []byte, n uint64) error {
+					if num == 1 {
+						s.Value = math.Float64frombits(n)
+					} else {
+						s.Timestamp = int64(n)
+					}
+					return nil
+				})
+				ts.Samples = append(ts.Samples, s)
+				return err
+			}
+			return fmt.Errorf("unexpected field %d", num)
+		})
+		req.Timeseries = append(req.Timeseries, ts)
+		return err
+	})
+	return req, err
+}
+
+// consumeMessage calls field for each field of the message, with the bytes of length delimited fields or the value of
+// numeric fields.
+func consumeMessage(b []byte, field func(num protowire.Number, v []byte, n uint64) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		var (
+			v   []byte
+			val uint64
+		)
+		switch typ {
+		case protowire.BytesType:
+			v, n = protowire.ConsumeBytes(b)
+		case protowire.Fixed64Type:
+			val, n = protowire.ConsumeFixed64(b)
+		case protowire.VarintType:
+			val, n = protowire.ConsumeVarint(b)
+		default:
+			return fmt.Errorf("unexpected wire type %d", typ)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if err := field(num, v, val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+package kql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/DrBushytop/amag/pkg/auth"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+const armProviderAPIVersion = "2021-04-01"
+
+// ResourceClient reads resource information from the Azure Resource Manager API.
+type ResourceClient struct {
+	restClient
+	cachePath string
+	cloud     auth.Cloud
+	endpoints auth.Endpoints
+	// cacheMu guards the location cache file, so that concurrent lookups don't drop each other's locations
+	cacheMu sync.Mutex
+}
+
+func NewResourceClient(opts ...ResourceClientOption) (*ResourceClient, error) {
+	client := ResourceClient{
+		restClient: restClient{httpClient: http.DefaultClient},
+	}
+
+	for _, opt := range opts {
+		err := opt(&client)
+		if err != nil {
+			return nil, fmt.Errorf("NewResourceClient: failed to apply option: %w", err)
+		}
+	}
+
+	endpoints, err := client.cloud.Endpoints()
+	if err != nil {
+		return nil, fmt.Errorf("NewResourceClient: %w", err)
+	}
+	client.endpoints = endpoints
+
+	if client.authClient == nil {
+		authClient, err := auth.NewAuthClient()
+		if err != nil {
+			return nil, fmt.Errorf("NewResourceClient: failed to create auth client: %w", err)
+		}
+		client.authClient = authClient
+	}
+
+	return &client, nil
+}
+
+type ResourceClientOption func(client *ResourceClient) error
+
+func WithResourceAuthClient(authClient *auth.Client) ResourceClientOption {
+	return func(client *ResourceClient) error {
+		client.authClient = authClient
+		return nil
+	}
+}
+
+// WithLocationCache caches resolved resource locations in the given json file.
+func WithLocationCache(path string) ResourceClientOption {
+	return func(client *ResourceClient) error {
+		client.cachePath = path
+		return nil
+	}
+}
+
+// WithResourceCloud reads resources from the Azure Resource Manager endpoint of the given cloud. Defaults to the public cloud.
+func WithResourceCloud(cloud auth.Cloud) ResourceClientOption {
+	return func(client *ResourceClient) error {
+		client.cloud = cloud
+		return nil
+	}
+}
+
+// GetLocation returns the Azure region of the given resource. For subresources, the location of the parent resource is returned.
+// If a location cache is configured, previously resolved locations are read from it instead of calling the API.
+// A corrupt cache is ignored and overwritten.
+func (c *ResourceClient) GetLocation(ctx context.Context, resourceId string) (string, error) {
+	parentId, namespace, resourceType, err := parseResourceId(resourceId)
+	if err != nil {
+		return "", fmt.Errorf("GetLocation: %w", err)
+	}
+
+	c.cacheMu.Lock()
+	cache, err := c.readLocationCache()
+	c.cacheMu.Unlock()
+	if err != nil {
+		return "", fmt.Errorf("GetLocation: failed to read location cache: %w", err)
+	}
+	cacheKey := strings.ToLower(parentId)
+	if location, ok := cache[cacheKey]; ok {
+		return location, nil
+	}
+
+	subscriptionId := strings.Split(parentId, "/")[2]
+	apiVersion, err := c.getAPIVersion(ctx, subscriptionId, namespace, resourceType)
+	if err != nil {
+		return "", fmt.Errorf("GetLocation: %w", err)
+	}
+
+	var resource struct {
+		Location string `json:"location"`
+	}
+	if err := c.get(ctx, fmt.Sprintf("%s%s?api-version=%s", c.endpoints.ResourceManager, parentId, apiVersion), &resource); err != nil {
+		return "", fmt.Errorf("GetLocation: failed to get resource: %w", err)
+	}
+	if resource.Location == "" {
+		return "", fmt.Errorf("GetLocation: resource %s has no location", parentId)
+	}
+
+	// Locations are returned in the display format for some resource types, e.g. "West Europe".
+	location := strings.ToLower(strings.ReplaceAll(resource.Location, " ", ""))
+
+	if c.cachePath != "" {
+		if err := c.cacheLocation(cacheKey, location); err != nil {
+			return "", fmt.Errorf("GetLocation: failed to write location cache: %w", err)
+		}
+	}
+
+	return location, nil
+}
+
+// getAPIVersion returns the latest stable API version of the resource type, as reported by the resource provider.
+func (c *ResourceClient) getAPIVersion(ctx context.Context, subscriptionId string, namespace string, resourceType string) (string, error) {
+	var provider struct {
+		ResourceTypes []struct {
+			ResourceType string   `json:"resourceType"`
+			APIVersions  []string `json:"apiVersions"`
+		} `json:"resourceTypes"`
+	}
+	uri := fmt.Sprintf("%s/subscriptions/%s/providers/%s?api-version=%s", c.endpoints.ResourceManager, subscriptionId, namespace, armProviderAPIVersion)
+	if err := c.get(ctx, uri, &provider); err != nil {
+		return "", fmt.Errorf("failed to get resource provider %s: %w", namespace, err)
+	}
+
+	for _, rt := range provider.ResourceTypes {
+		if !strings.EqualFold(rt.ResourceType, resourceType) {
+			continue
+		}
+		// API versions are listed newest first
+		for _, v := range rt.APIVersions {
+			if !strings.Contains(v, "preview") {
+				return v, nil
+			}
+		}
+		if len(rt.APIVersions) > 0 {
+			return rt.APIVersions[0], nil
+		}
+	}
+	return "", fmt.Errorf("no api version found for resource type %s/%s", namespace, resourceType)
+}
+
+func (c *ResourceClient) get(ctx context.Context, uri string, v any) error {
+	return c.getJSON(ctx, c.endpoints.ResourceManagerScope(), uri, v)
+}
+
+func (c *ResourceClient) readLocationCache() (map[string]string, error) {
+	cache := map[string]string{}
+	if c.cachePath == "" {
+		return cache, nil
+	}
+
+	data, err := os.ReadFile(c.cachePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return cache, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &cache); err != nil {
+		// The cache is overwritten with the next resolved location
+		log.Printf("readLocationCache: ignoring corrupt location cache %s: %s\n", c.cachePath, err)
+		return map[string]string{}, nil
+	}
+	return cache, nil
+}
+
+// cacheLocation adds the location to the cache file. The file is read again first, as other lookups may have added
+// locations since it was read.
+func (c *ResourceClient) cacheLocation(key string, location string) error {
+	c.cacheMu.Lock()
+	defer c.cacheMu.Unlock()
+
+	cache, err := c.readLocationCache()
+	if err != nil {
+		return err
+	}
+	cache[key] = location
+	return c.writeLocationCache(cache)
+}
+
+func (c *ResourceClient) writeLocationCache(cache map[string]string) error {
+	data, err := json.MarshalIndent(cache, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(c.cachePath, data, 0o600)
+}
+
+// parseResourceId returns the id of the top level resource, its provider namespace and resource type from the given resource or subresource id.
+func parseResourceId(resourceId string) (parentId string, namespace string, resourceType string, err error) {
+	// /subscriptions/{sub}/resourceGroups/{rg}/providers/{namespace}/{type}/{name}[/{subtype}/{subname}...]
+	parts := strings.Split(strings.TrimSuffix(resourceId, "/"), "/")
+	if len(parts) < 9 || parts[0] != "" || !strings.EqualFold(parts[1], "subscriptions") || !strings.EqualFold(parts[3], "resourceGroups") || !strings.EqualFold(parts[5], "providers") {
+		return "", "", "", fmt.Errorf("invalid resource id %q", resourceId)
+	}
+	return strings.Join(parts[:9], "/"), parts[6], parts[7], nil
+}
@@ -0,0 +1,262 @@
+package kql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/DrBushytop/amag/pkg/auth"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestParseResourceId(t *testing.T) {
+	tests := []struct {
+		name          string
+		resourceId    string
+		wantParent    string
+		wantNamespace string
+		wantType      string
+		wantErr       bool
+	}{
+		{
+			name:          "resource",
+			resourceId:    "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm",
+			wantParent:    "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm",
+			wantNamespace: "Microsoft.Compute",
+			wantType:      "virtualMachines",
+		},
+		{
+			name:          "subresource",
+			resourceId:    "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Sql/servers/srv/databases/db/",
+			wantParent:    "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Sql/servers/srv",
+			wantNamespace: "Microsoft.Sql",
+			wantType:      "servers",
+		},
+		{
+			name:          "lower case segments",
+			resourceId:    "/SUBSCRIPTIONS/sub/resourcegroups/rg/PROVIDERS/Microsoft.Web/sites/app",
+			wantParent:    "/SUBSCRIPTIONS/sub/resourcegroups/rg/PROVIDERS/Microsoft.Web/sites/app",
+			wantNamespace: "Microsoft.Web",
+			wantType:      "sites",
+		},
+		{name: "resource group", resourceId: "/subscriptions/sub/resourceGroups/rg", wantErr: true},
+		{name: "no leading slash", resourceId: "subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm", wantErr: true},
+		{name: "no providers", resourceId: "/subscriptions/sub/resourceGroups/rg/resources/Microsoft.Compute/virtualMachines/vm", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			parent, namespace, resourceType, err := parseResourceId(tt.resourceId)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseResourceId() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if parent != tt.wantParent || namespace != tt.wantNamespace || resourceType != tt.wantType {
+				t.Errorf("parseResourceId() = %s, %s, %s, want %s, %s, %s", parent, namespace, resourceType, tt.wantParent, tt.wantNamespace, tt.wantType)
+			}
+		})
+	}
+}
+
+// armServer stands in for the Azure Resource Manager API, serving the Microsoft.Sql provider and its resources, and
+// records the api versions resources are read with.
+type armServer struct {
+	mu          sync.Mutex
+	calls       int
+	apiVersions []string
+}
+
+func (s *armServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+
+	switch r.URL.Path {
+	case "/subscriptions/sub/providers/Microsoft.Sql":
+		if got := r.URL.Query().Get("api-version"); got != armProviderAPIVersion {
+			http.Error(w, "unexpected api-version "+got, http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(`{"resourceTypes":[
+			{"resourceType":"servers","apiVersions":["2024-05-01-preview","2023-08-01","2021-11-01"]},
+			{"resourceType":"managedInstances","apiVersions":["2024-05-01-preview","2023-05-01-preview"]}
+		]}`))
+	case "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Sql/servers/srv",
+		"/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Sql/managedInstances/mi":
+		s.apiVersions = append(s.apiVersions, r.URL.Query().Get("api-version"))
+		_, _ = w.Write([]byte(`{"location":"West Europe"}`))
+	case "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Sql/servers/denied":
+		http.Error(w, `{"error":{"code":"AuthorizationFailed"}}`, http.StatusForbidden)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestGetLocation(t *testing.T) {
+	const serverId = "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Sql/servers/srv"
+	tests := []struct {
+		name       string
+		resourceId string
+		// cache is the content of the location cache file before the call, none if empty
+		cache           string
+		want            string
+		wantCalls       int
+		wantAPIVersions []string
+		wantCache       map[string]string
+		wantErr         error
+	}{
+		{
+			name:            "stable api version",
+			resourceId:      serverId,
+			want:            "westeurope",
+			wantCalls:       2,
+			wantAPIVersions: []string{"2023-08-01"},
+			wantCache:       map[string]string{"/subscriptions/sub/resourcegroups/rg/providers/microsoft.sql/servers/srv": "westeurope"},
+		},
+		{
+			name:            "preview api version without stable ones",
+			resourceId:      "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Sql/managedInstances/mi",
+			want:            "westeurope",
+			wantCalls:       2,
+			wantAPIVersions: []string{"2024-05-01-preview"},
+			wantCache:       map[string]string{"/subscriptions/sub/resourcegroups/rg/providers/microsoft.sql/managedinstances/mi": "westeurope"},
+		},
+		{
+			name:            "subresource",
+			resourceId:      serverId + "/databases/db",
+			want:            "westeurope",
+			wantCalls:       2,
+			wantAPIVersions: []string{"2023-08-01"},
+			wantCache:       map[string]string{"/subscriptions/sub/resourcegroups/rg/providers/microsoft.sql/servers/srv": "westeurope"},
+		},
+		{
+			name:       "cached",
+			resourceId: serverId,
+			cache:      `{"/subscriptions/sub/resourcegroups/rg/providers/microsoft.sql/servers/srv": "northeurope"}`,
+			want:       "northeurope",
+			wantCache:  map[string]string{"/subscriptions/sub/resourcegroups/rg/providers/microsoft.sql/servers/srv": "northeurope"},
+		},
+		{
+			name:            "corrupt cache",
+			resourceId:      serverId,
+			cache:           `{"/subscriptions/sub/resourcegroups/rg/providers/microsoft.sql/servers/srv": `,
+			want:            "westeurope",
+			wantCalls:       2,
+			wantAPIVersions: []string{"2023-08-01"},
+			wantCache:       map[string]string{"/subscriptions/sub/resourcegroups/rg/providers/microsoft.sql/servers/srv": "westeurope"},
+		},
+		{
+			name:       "forbidden",
+			resourceId: "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Sql/servers/denied",
+			wantCalls:  2,
+			wantErr:    ErrAuthFailed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			arm := &armServer{}
+			server := httptest.NewServer(arm)
+			defer server.Close()
+
+			cachePath := filepath.Join(t.TempDir(), "locations.json")
+			if tt.cache != "" {
+				if err := os.WriteFile(cachePath, []byte(tt.cache), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			authClient, err := auth.NewAuthClient(auth.WithCredential(fakeCredential{}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := &ResourceClient{
+				restClient: restClient{authClient: authClient, httpClient: server.Client()},
+				cachePath:  cachePath,
+				endpoints:  auth.Endpoints{ResourceManager: server.URL},
+			}
+
+			got, err := client.GetLocation(context.Background(), tt.resourceId)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("GetLocation() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("GetLocation() = %s, want %s", got, tt.want)
+			}
+			if arm.calls != tt.wantCalls {
+				t.Errorf("GetLocation() made %d requests, want %d", arm.calls, tt.wantCalls)
+			}
+			if !reflect.DeepEqual(arm.apiVersions, tt.wantAPIVersions) {
+				t.Errorf("GetLocation() read the resource with api versions %v, want %v", arm.apiVersions, tt.wantAPIVersions)
+			}
+
+			if tt.wantCache == nil {
+				return
+			}
+			data, err := os.ReadFile(cachePath)
+			if err != nil {
+				t.Fatalf("failed to read location cache: %v", err)
+			}
+			var cache map[string]string
+			if err := json.Unmarshal(data, &cache); err != nil {
+				t.Fatalf("failed to unmarshal location cache: %v", err)
+			}
+			if !reflect.DeepEqual(cache, tt.wantCache) {
+				t.Errorf("location cache = %v, want %v", cache, tt.wantCache)
+			}
+		})
+	}
+}
+
+func TestGetLocationConcurrent(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewServer(&armServer{})
+	defer server.Close()
+
+	authClient, err := auth.NewAuthClient(auth.WithCredential(fakeCredential{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cachePath := filepath.Join(t.TempDir(), "locations.json")
+	client := &ResourceClient{
+		restClient: restClient{authClient: authClient, httpClient: server.Client()},
+		cachePath:  cachePath,
+		endpoints:  auth.Endpoints{ResourceManager: server.URL},
+	}
+
+	resourceIds := []string{
+		"/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Sql/servers/srv",
+		"/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Sql/managedInstances/mi",
+	}
+	var wg sync.WaitGroup
+	for _, resourceId := range resourceIds {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := client.GetLocation(context.Background(), resourceId); err != nil {
+				t.Errorf("GetLocation(%s) error = %v", resourceId, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	data, err := os.ReadFile(cachePath)
+	if err != nil {
+		t.Fatalf("failed to read location cache: %v", err)
+	}
+	var cache map[string]string
+	if err := json.Unmarshal(data, &cache); err != nil {
+		t.Fatalf("failed to unmarshal location cache: %v", err)
+	}
+	want := map[string]string{
+		"/subscriptions/sub/resourcegroups/rg/providers/microsoft.sql/servers/srv":         "westeurope",
+		"/subscriptions/sub/resourcegroups/rg/providers/microsoft.sql/managedinstances/mi": "westeurope",
+	}
+	if !reflect.DeepEqual(cache, want) {
+		t.Errorf("location cache = %v, want %v", cache, want)
+	}
+}
@@ -0,0 +1,144 @@
+package kql
+
+import (
+	"context"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/monitor/azquery"
+	"github.com/DrBushytop/amag/pkg/auth"
+	"net/http"
+)
+
+const resourceGraphAPIVersion = "2021-03-01"
+
+// resourceGraphPageSize is the maximum number of rows the Resource Graph API returns per request.
+const resourceGraphPageSize = 1000
+
+// NewResourceGraphClient returns a client running queries against Azure Resource Graph. The subscription id is given in
+// place of the workspace id, and additional workspaces set with WithAdditionalWorkspaces are queried as additional
+// subscriptions. Without subscriptions or management groups, all subscriptions the credential can read are queried.
+//
+// As with NewADXClient, the query timespan is not sent.
+func NewResourceGraphClient(subscriptionId string, managementGroups []string, opts ...WsOption) (*WorkspaceClient, error) {
+	wsc, err := newWorkspaceClient(subscriptionId, opts)
+	if err != nil {
+		return nil, fmt.Errorf("NewResourceGraphClient: %w", err)
+	}
+
+	if wsc.client == nil {
+		endpoints, err := wsc.cloud.Endpoints()
+		if err != nil {
+			return nil, fmt.Errorf("NewResourceGraphClient: %w", err)
+		}
+		authClient, err := auth.NewAuthClient(auth.WithCredential(wsc.cred))
+		if err != nil {
+			return nil, fmt.Errorf("NewResourceGraphClient: failed to create auth client: %w", err)
+		}
+		wsc.client = &resourceGraphQueryClient{
+			restClient:       restClient{authClient: authClient, httpClient: http.DefaultClient},
+			baseURL:          endpoints.ResourceManager,
+			scope:            endpoints.ResourceManagerScope(),
+			managementGroups: managementGroups,
+		}
+	}
+
+	return wsc, nil
+}
+
+// resourceGraphQueryClient queries Azure Resource Graph through the Azure Resource Manager API. It implements queryClient
+// with a subscription id in place of the workspace id, converting the table formatted response into the Log Analytics
+// result format. All pages of the result are read.
+type resourceGraphQueryClient struct {
+	restClient
+	baseURL          string
+	scope            string
+	managementGroups []string
+}
+
+type resourceGraphRequest struct {
+	Query            string                      `json:"query"`
+	Subscriptions    []string                    `json:"subscriptions,omitempty"`
+	ManagementGroups []string                    `json:"managementGroups,omitempty"`
+	Options          resourceGraphRequestOptions `json:"options"`
+}
+
+type resourceGraphRequestOptions struct {
+	ResultFormat string `json:"resultFormat"`
+	Top          int    `json:"$top"`
+	SkipToken    string `json:"$skipToken,omitempty"`
+}
+
+type resourceGraphResponse struct {
+	Data struct {
+		Columns []struct {
+			Name string `json:"name"`
+			Type string `json:"type"`
+		} `json:"columns"`
+		Rows [][]any `json:"rows"`
+	} `json:"data"`
+	SkipToken string `json:"$skipToken"`
+}
+
+// resourceGraphColumnTypes maps the column types of Resource Graph results to the Log Analytics column types.
+var resourceGraphColumnTypes = map[string]azquery.LogsColumnType{
+	"string":   azquery.LogsColumnTypeString,
+	"integer":  azquery.LogsColumnTypeLong,
+	"number":   azquery.LogsColumnTypeReal,
+	"boolean":  azquery.LogsColumnTypeBool,
+	"datetime": azquery.LogsColumnTypeDatetime,
+	"object":   azquery.LogsColumnTypeDynamic,
+	"array":    azquery.LogsColumnTypeDynamic,
+}
+
+func (c *resourceGraphQueryClient) queryURL() string {
+	return fmt.Sprintf("%s/providers/Microsoft.ResourceGraph/resources?api-version=%s", c.baseURL, resourceGraphAPIVersion)
+}
+
+func (c *resourceGraphQueryClient) QueryWorkspace(ctx context.Context, subscriptionId string, body azquery.Body, _ *azquery.LogsClientQueryWorkspaceOptions) (azquery.LogsClientQueryWorkspaceResponse, error) {
+	request := resourceGraphRequest{
+		Query:            stringValue(body.Query),
+		ManagementGroups: c.managementGroups,
+		Options:          resourceGraphRequestOptions{ResultFormat: "table", Top: resourceGraphPageSize},
+	}
+	if subscriptionId != "" {
+		request.Subscriptions = append(request.Subscriptions, subscriptionId)
+	}
+	for _, subscription := range body.AdditionalWorkspaces {
+		request.Subscriptions = append(request.Subscriptions, stringValue(subscription))
+	}
+
+	table := &azquery.Table{Name: to.Ptr("PrimaryResult")}
+	for {
+		page, err := c.queryPage(ctx, request)
+		if err != nil {
+			return azquery.LogsClientQueryWorkspaceResponse{}, err
+		}
+		if table.Columns == nil {
+			for _, col := range page.Data.Columns {
+				columnType, ok := resourceGraphColumnTypes[col.Type]
+				if !ok {
+					columnType = azquery.LogsColumnTypeString
+				}
+				table.Columns = append(table.Columns, &azquery.Column{Name: to.Ptr(col.Name), Type: to.Ptr(columnType)})
+			}
+		}
+		for _, row := range page.Data.Rows {
+			table.Rows = append(table.Rows, row)
+		}
+
+		if page.SkipToken == "" {
+			break
+		}
+		request.Options.SkipToken = page.SkipToken
+	}
+
+	return azquery.LogsClientQueryWorkspaceResponse{Results: azquery.Results{Tables: []*azquery.Table{table}}}, nil
+}
+
+func (c *resourceGraphQueryClient) queryPage(ctx context.Context, body resourceGraphRequest) (resourceGraphResponse, error) {
+	var response resourceGraphResponse
+	if err := c.postJSON(ctx, c.scope, c.queryURL(), body, &response); err != nil {
+		return resourceGraphResponse{}, err
+	}
+	return response, nil
+}
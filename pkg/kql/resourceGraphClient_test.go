@@ -0,0 +1,95 @@
+package kql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/monitor/azquery"
+	"github.com/DrBushytop/amag/pkg/auth"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+)
+
+func TestResourceGraphQuery(t *testing.T) {
+	pages := []string{
+		`{"data":{"columns":[{"name":"subscriptionId","type":"string"},{"name":"MetricValue","type":"integer"}],"rows":[["sub1",3]]},"$skipToken":"next"}`,
+		`{"data":{"columns":[{"name":"subscriptionId","type":"string"},{"name":"MetricValue","type":"integer"}],"rows":[["sub2",5]]}}`,
+	}
+	tests := []struct {
+		name       string
+		status     int
+		additional []string
+		want       []LogLine
+		wantCalls  int32
+		wantErr    error
+	}{
+		{
+			name:       "all pages",
+			status:     http.StatusOK,
+			additional: []string{"sub2"},
+			want: []LogLine{
+				{MetricValue: 3, Dimensions: []Dimension{{"subscriptionId", "sub1"}}, Columns: map[string]any{"subscriptionId": "sub1", "MetricValue": int64(3)}},
+				{MetricValue: 5, Dimensions: []Dimension{{"subscriptionId", "sub2"}}, Columns: map[string]any{"subscriptionId": "sub2", "MetricValue": int64(5)}},
+			},
+			wantCalls: 2,
+		},
+		{name: "forbidden", status: http.StatusForbidden, wantCalls: 1, wantErr: ErrAuthFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			var calls atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				call := calls.Add(1)
+				if r.URL.Path != "/providers/Microsoft.ResourceGraph/resources" {
+					t.Errorf("request path = %s, want /providers/Microsoft.ResourceGraph/resources", r.URL.Path)
+				}
+				var body resourceGraphRequest
+				if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+					t.Errorf("failed to decode request body: %v", err)
+				}
+				want := resourceGraphRequest{
+					Query:            "resources | summarize MetricValue = count() by subscriptionId",
+					Subscriptions:    append([]string{"sub1"}, tt.additional...),
+					ManagementGroups: []string{"mg1"},
+					Options:          resourceGraphRequestOptions{ResultFormat: "table", Top: resourceGraphPageSize},
+				}
+				if call > 1 {
+					want.Options.SkipToken = "next"
+				}
+				if !reflect.DeepEqual(body, want) {
+					t.Errorf("request body = %+v, want %+v", body, want)
+				}
+
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(pages[min(int(call), len(pages))-1]))
+			}))
+			defer server.Close()
+
+			authClient, err := auth.NewAuthClient(auth.WithCredential(fakeCredential{}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := &resourceGraphQueryClient{restClient: restClient{authClient: authClient, httpClient: server.Client()}, baseURL: server.URL, scope: "scope", managementGroups: []string{"mg1"}}
+			wsc, err := NewResourceGraphClient("sub1", nil, WithQueryClient(client), WithAdditionalWorkspaces(tt.additional...))
+			if err != nil {
+				t.Fatalf("NewResourceGraphClient() error = %v", err)
+			}
+
+			got, err := wsc.QueryWorkspaceForAggregateValueWithColumns(context.Background(), azquery.Body{Query: to.Ptr("resources | summarize MetricValue = count() by subscriptionId")}, nil)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("QueryWorkspaceForAggregateValueWithColumns() error = %v, want %v", err, tt.wantErr)
+			}
+			if calls.Load() != tt.wantCalls {
+				t.Errorf("QueryWorkspaceForAggregateValueWithColumns() made %d requests, want %d", calls.Load(), tt.wantCalls)
+			}
+			if tt.wantErr == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("QueryWorkspaceForAggregateValueWithColumns() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
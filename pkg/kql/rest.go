@@ -0,0 +1,77 @@
+package kql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/DrBushytop/amag/pkg/auth"
+	"io"
+	"net/http"
+)
+
+// restClient sends authenticated json requests to the REST APIs of Azure services.
+type restClient struct {
+	authClient *auth.Client
+	httpClient *http.Client
+}
+
+// postJSON posts body as json to the given url, with an access token for scope, and unmarshals the response into out.
+// Numbers in untyped values of out are unmarshalled as json.Number.
+func (c restClient) postJSON(ctx context.Context, scope string, url string, body any, out any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request body: %w", err)
+	}
+	return c.do(ctx, http.MethodPost, scope, url, bytes.NewReader(data), out)
+}
+
+// getJSON gets the given url, with an access token for scope, and unmarshals the response into out.
+func (c restClient) getJSON(ctx context.Context, scope string, url string, out any) error {
+	return c.do(ctx, http.MethodGet, scope, url, nil, out)
+}
+
+func (c restClient) do(ctx context.Context, method string, scope string, url string, body io.Reader, out any) error {
+	token, err := c.authClient.GetAccessToken([]string{scope})
+	if err != nil {
+		return fmt.Errorf("failed to get access token: %w", err)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	request.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+	request.Header.Add("Accept", "application/json")
+	if body != nil {
+		request.Header.Add("Content-Type", "application/json")
+	}
+
+	res, err := c.httpClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(res.Body)
+
+	bodyBytes, err := io.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if res.StatusCode != http.StatusOK {
+		if isAuthStatus(res.StatusCode) {
+			return fmt.Errorf("request failed: %w: status %d, %s, response body: %s", ErrAuthFailed, res.StatusCode, res.Status, string(bodyBytes))
+		}
+		return fmt.Errorf("request failed: status %d, %s, response body: %s", res.StatusCode, res.Status, string(bodyBytes))
+	}
+
+	// Numbers are decoded as json.Number, float64 loses the precision of longs above 2^53
+	decoder := json.NewDecoder(bytes.NewReader(bodyBytes))
+	decoder.UseNumber()
+	if err := decoder.Decode(out); err != nil {
+		return fmt.Errorf("failed to unmarshal response body: %w", err)
+	}
+	return nil
+}
\ No newline at end of file
@@ -0,0 +1,142 @@
+package kql
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Names of the values RenderQuery sets from the time window. Parameters with the same names are overridden.
+const (
+	TemplateStart  = "Start"
+	TemplateEnd    = "End"
+	TemplateWindow = "Window"
+)
+
+// RenderQuery fills the placeholders of a query file, written as Go templates like {{ .Service }}, with the given parameters.
+// In addition to the parameters, {{ .Start }} and {{ .End }} are set to the bounds of the window as KQL datetime literals,
+// and {{ .Window }} to its length as a KQL timespan literal, e.g. bin(TimeGenerated, {{ .Window }}).
+// Placeholders without a value are an error. Queries without placeholders are returned as is.
+// Values are inserted as is. Use {{ quote .Service }} to insert a value as a KQL string literal, so that quotes in the
+// value can't end the string.
+func RenderQuery(query string, params map[string]string, window TimeWindow) (string, error) {
+	if !strings.Contains(query, "{{") {
+		return query, nil
+	}
+
+	tmpl, err := template.New("query").Option("missingkey=error").Funcs(templateFuncs).Parse(query)
+	if err != nil {
+		return "", fmt.Errorf("RenderQuery: failed to parse query template: %w", err)
+	}
+
+	data := make(map[string]string, len(params)+3)
+	maps.Copy(data, params)
+	data[TemplateStart] = formatKqlDatetime(window.Start)
+	data[TemplateEnd] = formatKqlDatetime(window.End)
+	data[TemplateWindow] = formatKqlTimespan(window.Duration())
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("RenderQuery: failed to fill query template: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// templateFuncs are the functions query templates can call besides the builtin ones.
+var templateFuncs = template.FuncMap{
+	"quote": quoteKqlString,
+}
+
+// windowPlaceholders match the {{ .Start }} and {{ .End }} placeholders, including ones passed to template functions.
+var windowPlaceholders = map[string]*regexp.Regexp{
+	TemplateStart: regexp.MustCompile(`\{\{[^}]*\.` + TemplateStart + `\b`),
+	TemplateEnd:   regexp.MustCompile(`\{\{[^}]*\.` + TemplateEnd + `\b`),
+}
+
+// UsesTimeWindow reports whether the query filters on the time window itself, with both the {{ .Start }} and {{ .End }}
+// placeholders.
+func UsesTimeWindow(query string) bool {
+	for _, placeholder := range windowPlaceholders {
+		if !placeholder.MatchString(query) {
+			return false
+		}
+	}
+	return true
+}
+
+// DeclareQueryParameters prepends a declare query_parameters statement to the query, declaring each parameter as a string
+// with its value as the default, so that the query can refer to the parameters by name, e.g. | where cloud_RoleName == Service.
+func DeclareQueryParameters(query string, params map[string]string) string {
+	if len(params) == 0 {
+		return query
+	}
+
+	declarations := make([]string, 0, len(params))
+	for _, name := range slices.Sorted(maps.Keys(params)) {
+		declarations = append(declarations, fmt.Sprintf("%s:string = %s", name, quoteKqlString(params[name])))
+	}
+	return fmt.Sprintf("declare query_parameters(%s);\n%s", strings.Join(declarations, ", "), query)
+}
+
+// paramName matches the names parameters can have, which are valid template field and KQL parameter names.
+var paramName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ValidateParamName returns an error if the name isn't a valid parameter name, made of letters, digits and underscores
+// and not starting with a digit.
+func ValidateParamName(name string) error {
+	if !paramName.MatchString(name) {
+		return fmt.Errorf("invalid parameter name %q, expected letters, digits and underscores, not starting with a digit", name)
+	}
+	return nil
+}
+
+// ParseParams parses key=value pairs into a map. Later pairs override earlier ones. Keys must be valid parameter names,
+// see ValidateParamName.
+func ParseParams(pairs []string) (map[string]string, error) {
+	params := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		key, value, ok := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid parameter %q, expected key=value", pair)
+		}
+		if err := ValidateParamName(key); err != nil {
+			return nil, err
+		}
+		params[key] = value
+	}
+	return params, nil
+}
+
+func formatKqlDatetime(t time.Time) string {
+	return fmt.Sprintf("datetime(%s)", t.UTC().Format(time.RFC3339Nano))
+}
+
+// formatKqlTimespan formats the duration in the largest whole unit KQL timespan literals support.
+func formatKqlTimespan(d time.Duration) string {
+	for _, unit := range []struct {
+		duration time.Duration
+		suffix   string
+	}{
+		{24 * time.Hour, "d"},
+		{time.Hour, "h"},
+		{time.Minute, "m"},
+		{time.Second, "s"},
+		{time.Millisecond, "ms"},
+	} {
+		if d%unit.duration == 0 {
+			return fmt.Sprintf("%d%s", d/unit.duration, unit.suffix)
+		}
+	}
+	return fmt.Sprintf("%dtick", d/100)
+}
+
+// quoteKqlString quotes the value as a KQL string literal.
+func quoteKqlString(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`).Replace(value) + `"`
+}
@@ -0,0 +1,158 @@
+package kql
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRenderQuery(t *testing.T) {
+	window := TimeWindow{
+		Start: time.Date(2024, 9, 20, 9, 0, 0, 0, time.UTC),
+		End:   time.Date(2024, 9, 20, 10, 0, 0, 0, time.UTC),
+	}
+	tests := []struct {
+		name    string
+		query   string
+		params  map[string]string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "no placeholders",
+			query: "requests | summarize MetricValue = count()",
+			want:  "requests | summarize MetricValue = count()",
+		},
+		{
+			name:   "parameter",
+			query:  `requests | where cloud_RoleName == "{{ .Service }}"`,
+			params: map[string]string{"Service": "api"},
+			want:   `requests | where cloud_RoleName == "api"`,
+		},
+		{
+			name:  "window values",
+			query: "requests | where TimeGenerated between ({{ .Start }} .. {{ .End }}) | summarize count() by bin(TimeGenerated, {{ .Window }})",
+			want:  "requests | where TimeGenerated between (datetime(2024-09-20T09:00:00Z) .. datetime(2024-09-20T10:00:00Z)) | summarize count() by bin(TimeGenerated, 1h)",
+		},
+		{
+			name:   "quoted parameter",
+			query:  `requests | where cloud_RoleName == {{ quote .Service }}`,
+			params: map[string]string{"Service": "api"},
+			want:   `requests | where cloud_RoleName == "api"`,
+		},
+		{
+			name:   "quoted hostile parameter",
+			query:  `requests | where cloud_RoleName == {{ quote .Service }} | summarize count()`,
+			params: map[string]string{"Service": `api" or 1 == 1 | union secrets | where "" == "`},
+			want:   `requests | where cloud_RoleName == "api\" or 1 == 1 | union secrets | where \"\" == \"" | summarize count()`,
+		},
+		{
+			name:    "missing parameter",
+			query:   `requests | where cloud_RoleName == "{{ .Service }}"`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid template",
+			query:   `requests | where cloud_RoleName == "{{ .Service "`,
+			params:  map[string]string{"Service": "api"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := RenderQuery(tt.query, tt.params, window)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RenderQuery() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("RenderQuery() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeclareQueryParameters(t *testing.T) {
+	tests := []struct {
+		name   string
+		params map[string]string
+		want   string
+	}{
+		{
+			name: "no parameters",
+			want: "requests",
+		},
+		{
+			name:   "sorted and quoted",
+			params: map[string]string{"Service": "api", "Label": `say "hi"`},
+			want:   "declare query_parameters(Label:string = \"say \\\"hi\\\"\", Service:string = \"api\");\nrequests",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if got := DeclareQueryParameters("requests", tt.params); got != tt.want {
+				t.Errorf("DeclareQueryParameters() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUsesTimeWindow(t *testing.T) {
+	tests := []struct {
+		query string
+		want  bool
+	}{
+		{"Metrics | where Timestamp between ({{ .Start }} .. {{ .End }})", true},
+		{"Metrics | where Timestamp >= {{.Start}} and Timestamp < {{- .End -}}", true},
+		{"Metrics | where Timestamp > {{ .Start }}", false},
+		{"Metrics | summarize by bin(Timestamp, {{ .Window }})", false},
+		{"Metrics | where Service == {{ .StartPage }} and Timestamp < {{ .End }}", false},
+		{"Metrics", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			t.Parallel()
+			if got := UsesTimeWindow(tt.query); got != tt.want {
+				t.Errorf("UsesTimeWindow() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatKqlTimespan(t *testing.T) {
+	tests := []struct {
+		duration time.Duration
+		want     string
+	}{
+		{48 * time.Hour, "2d"},
+		{90 * time.Minute, "90m"},
+		{time.Hour, "1h"},
+		{1500 * time.Millisecond, "1500ms"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			t.Parallel()
+			if got := formatKqlTimespan(tt.duration); got != tt.want {
+				t.Errorf("formatKqlTimespan() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseParams(t *testing.T) {
+	got, err := ParseParams([]string{"Service=api", "Filter=a=b", "Service=web"})
+	if err != nil {
+		t.Fatalf("ParseParams() error = %v", err)
+	}
+	if len(got) != 2 || got["Service"] != "web" || got["Filter"] != "a=b" {
+		t.Errorf("ParseParams() = %v", got)
+	}
+	if _, err := ParseParams([]string{"Service"}); err == nil {
+		t.Errorf("ParseParams() expected error for missing value")
+	}
+	for _, name := range []string{"1Service", "cloud-role", "Service Name", `Service"`, "{{ .End }}"} {
+		if _, err := ParseParams([]string{name + "=api"}); err == nil {
+			t.Errorf("ParseParams() expected error for invalid name %q", name)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package kql
+
+import (
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/monitor/azquery"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TimeWindow is the time range a query is run over.
+type TimeWindow struct {
+	Start time.Time
+	End   time.Time
+}
+
+// TimeInterval returns the window in the format expected by azquery.Body.Timespan.
+func (w TimeWindow) TimeInterval() azquery.TimeInterval {
+	return azquery.NewTimeInterval(w.Start, w.End)
+}
+
+// Duration returns the length of the window.
+func (w TimeWindow) Duration() time.Duration {
+	return w.End.Sub(w.Start)
+}
+
+func (w TimeWindow) String() string {
+	return fmt.Sprintf("%s - %s", w.Start.Format(time.RFC3339), w.End.Format(time.RFC3339))
+}
+
+// Split divides the window into consecutive windows of the given length. The last window is shorter if the window is not a multiple of step.
+func (w TimeWindow) Split(step time.Duration) []TimeWindow {
+	if step <= 0 {
+		return []TimeWindow{w}
+	}
+
+	var windows []TimeWindow
+	for start := w.Start; start.Before(w.End); start = start.Add(step) {
+		end := start.Add(step)
+		if end.After(w.End) {
+			end = w.End
+		}
+		windows = append(windows, TimeWindow{Start: start, End: end})
+	}
+	return windows
+}
+
+// ParseTimeWindow resolves the window a query is run over.
+//
+// start and end accept RFC3339 timestamps, "now" or relative expressions like "ago(6h)". An empty end means now,
+// and an empty start means end minus timespan. If align is given, both ends of the window are truncated to
+// multiples of it, so that scheduled runs cover consecutive bins. Durations accept Go duration syntax and a "d" suffix for days.
+func ParseTimeWindow(now time.Time, timespan, start, end, align string) (TimeWindow, error) {
+	var alignment time.Duration
+	if align != "" {
+		a, err := ParseDuration(align)
+		if err != nil {
+			return TimeWindow{}, fmt.Errorf("ParseTimeWindow: invalid align: %w", err)
+		}
+		if a <= 0 {
+			return TimeWindow{}, fmt.Errorf("ParseTimeWindow: align must be positive, got %s", align)
+		}
+		alignment = a
+	}
+
+	w := TimeWindow{End: now}
+	if end != "" {
+		e, err := ParseTime(now, end)
+		if err != nil {
+			return TimeWindow{}, fmt.Errorf("ParseTimeWindow: invalid end: %w", err)
+		}
+		w.End = e
+	}
+	if alignment > 0 {
+		w.End = w.End.Truncate(alignment)
+	}
+
+	if start != "" {
+		s, err := ParseTime(now, start)
+		if err != nil {
+			return TimeWindow{}, fmt.Errorf("ParseTimeWindow: invalid start: %w", err)
+		}
+		w.Start = s
+	} else {
+		if timespan == "" {
+			return TimeWindow{}, fmt.Errorf("ParseTimeWindow: either start or timespan must be set")
+		}
+		span, err := ParseDuration(timespan)
+		if err != nil {
+			return TimeWindow{}, fmt.Errorf("ParseTimeWindow: invalid timespan: %w", err)
+		}
+		if span <= 0 {
+			return TimeWindow{}, fmt.Errorf("ParseTimeWindow: timespan must be positive, got %s", timespan)
+		}
+		w.Start = w.End.Add(-span)
+	}
+	if alignment > 0 {
+		w.Start = w.Start.Truncate(alignment)
+	}
+
+	if !w.Start.Before(w.End) {
+		return TimeWindow{}, fmt.Errorf("ParseTimeWindow: start %s must be before end %s", w.Start.Format(time.RFC3339), w.End.Format(time.RFC3339))
+	}
+
+	return w, nil
+}
+
+var agoPattern = regexp.MustCompile(`^ago\(\s*([^)]+?)\s*\)$`)
+
+// ParseTime parses an RFC3339 timestamp, a date, "now" or a KQL style relative expression like "ago(6h)" against now.
+func ParseTime(now time.Time, value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "now" || value == "now()" {
+		return now, nil
+	}
+
+	if m := agoPattern.FindStringSubmatch(value); m != nil {
+		d, err := ParseDuration(m[1])
+		if err != nil {
+			return time.Time{}, err
+		}
+		return now.Add(-d), nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err == nil {
+		return t, nil
+	}
+	// Dates without a time are interpreted as midnight UTC
+	t, err = time.Parse(time.DateOnly, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("expected RFC3339 timestamp, date, now or ago(<duration>), got %q", value)
+	}
+	return t, nil
+}
+
+// ParseDuration parses a Go duration, additionally allowing a leading day component such as "1d" or "2d12h".
+func ParseDuration(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	days, rest, found := strings.Cut(value, "d")
+	if !found {
+		return time.ParseDuration(value)
+	}
+
+	n, err := strconv.Atoi(days)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	d := time.Duration(n) * 24 * time.Hour
+	if rest == "" {
+		return d, nil
+	}
+
+	r, err := time.ParseDuration(rest)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	return d + r, nil
+}
@@ -0,0 +1,129 @@
+package kql
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseTimeWindow(t *testing.T) {
+	now := time.Date(2024, 9, 20, 10, 7, 30, 0, time.UTC)
+	type args struct {
+		timespan string
+		start    string
+		end      string
+		align    string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    TimeWindow
+		wantErr bool
+	}{
+		{
+			name: "default timespan",
+			args: args{timespan: "24h"},
+			want: TimeWindow{Start: now.Add(-24 * time.Hour), End: now},
+		},
+		{
+			name: "timespan in days",
+			args: args{timespan: "2d"},
+			want: TimeWindow{Start: now.Add(-48 * time.Hour), End: now},
+		},
+		{
+			name: "relative start and end",
+			args: args{timespan: "24h", start: "ago(6h)", end: "ago(1h)"},
+			want: TimeWindow{Start: now.Add(-6 * time.Hour), End: now.Add(-1 * time.Hour)},
+		},
+		{
+			name: "absolute start",
+			args: args{start: "2024-09-19T00:00:00Z", end: "now"},
+			want: TimeWindow{Start: time.Date(2024, 9, 19, 0, 0, 0, 0, time.UTC), End: now},
+		},
+		{
+			name: "aligned to bin",
+			args: args{timespan: "1h", align: "1h"},
+			want: TimeWindow{Start: time.Date(2024, 9, 20, 9, 0, 0, 0, time.UTC), End: time.Date(2024, 9, 20, 10, 0, 0, 0, time.UTC)},
+		},
+		{
+			name: "aligned to 5 minutes",
+			args: args{timespan: "10m", align: "5m"},
+			want: TimeWindow{Start: time.Date(2024, 9, 20, 9, 55, 0, 0, time.UTC), End: time.Date(2024, 9, 20, 10, 5, 0, 0, time.UTC)},
+		},
+		{
+			name: "dates",
+			args: args{start: "2024-09-01", end: "2024-09-02"},
+			want: TimeWindow{Start: time.Date(2024, 9, 1, 0, 0, 0, 0, time.UTC), End: time.Date(2024, 9, 2, 0, 0, 0, 0, time.UTC)},
+		},
+		{
+			name:    "start after end",
+			args:    args{start: "ago(1h)", end: "ago(2h)"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid timespan",
+			args:    args{timespan: "yesterday"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid start",
+			args:    args{start: "19.9.2024"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := ParseTimeWindow(now, tt.args.timespan, tt.args.start, tt.args.end, tt.args.align)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTimeWindow() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && (!got.Start.Equal(tt.want.Start) || !got.End.Equal(tt.want.End)) {
+				t.Errorf("ParseTimeWindow() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTimeWindowSplit(t *testing.T) {
+	start := time.Date(2024, 9, 20, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		window TimeWindow
+		step   time.Duration
+		want   []TimeWindow
+	}{
+		{
+			name:   "even steps",
+			window: TimeWindow{Start: start, End: start.Add(2 * time.Hour)},
+			step:   time.Hour,
+			want: []TimeWindow{
+				{Start: start, End: start.Add(time.Hour)},
+				{Start: start.Add(time.Hour), End: start.Add(2 * time.Hour)},
+			},
+		},
+		{
+			name:   "shorter last step",
+			window: TimeWindow{Start: start, End: start.Add(90 * time.Minute)},
+			step:   time.Hour,
+			want: []TimeWindow{
+				{Start: start, End: start.Add(time.Hour)},
+				{Start: start.Add(time.Hour), End: start.Add(90 * time.Minute)},
+			},
+		},
+		{
+			name:   "no step",
+			window: TimeWindow{Start: start, End: start.Add(time.Hour)},
+			want:   []TimeWindow{{Start: start, End: start.Add(time.Hour)}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got := tt.window.Split(tt.step)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Split() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
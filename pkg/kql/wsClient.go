@@ -1,60 +1,214 @@
 package kql
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	"github.com/Azure/azure-sdk-for-go/sdk/monitor/azquery"
+	"github.com/DrBushytop/amag/pkg/auth"
 	"log"
+	"math"
+	"net/http"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 )
 
 type LogLine struct {
 	TimeGenerated *time.Time `json:"TimeGenerated"`
 	MetricValue   float64    `json:"MetricValue"`
+	// MetricMin, MetricMax, MetricSum and MetricCount are the optional pre-aggregated values of the row, read from the columns of the same name.
+	MetricMin   *float64 `json:"MetricMin,omitempty"`
+	MetricMax   *float64 `json:"MetricMax,omitempty"`
+	MetricSum   *float64 `json:"MetricSum,omitempty"`
+	MetricCount *int64   `json:"MetricCount,omitempty"`
+	// Dimensions holds the values of all non-reserved columns of the row, in the order they appear in the result.
+	Dimensions []Dimension `json:"Dimensions,omitempty"`
+	// Columns holds all columns of the row by name, converted to Go types according to the column types of the result.
+	// Only set by QueryWorkspaceForAggregateValueWithColumns.
+	Columns map[string]any `json:"Columns,omitempty"`
 }
 
+// Dimension is a single named dimension value of a LogLine.
+type Dimension struct {
+	Name  string `json:"Name"`
+	Value string `json:"Value"`
+}
+
+// Dimensions added with WithDimension to the lines of each queried workspace, Application Insights app, resource,
+// Azure Data Explorer database or subscription, when they are queried separately.
+const (
+	WorkspaceIDDimension    = "WorkspaceId"
+	AppIDDimension          = "AppId"
+	ResourceIDDimension     = "ResourceId"
+	DatabaseDimension       = "Database"
+	SubscriptionIDDimension = "SubscriptionId"
+)
+
+// reservedColumns are the columns with a special meaning in the query result. All other columns are treated as dimensions.
+var reservedColumns = []string{"TimeGenerated", "MetricValue", "MetricMin", "MetricMax", "MetricSum", "MetricCount"}
+
 type queryClient interface {
 	QueryWorkspace(ctx context.Context, workspaceID string, body azquery.Body, options *azquery.LogsClientQueryWorkspaceOptions) (azquery.LogsClientQueryWorkspaceResponse, error)
 }
 
+// WorkspaceClient runs queries and parses their results. Despite its name, it also queries the other sources created by
+// NewAppInsightsClient and NewResourceQueryClient, which return results of the same shape.
 type WorkspaceClient struct {
-	cred        azcore.TokenCredential
-	client      queryClient
-	workspaceId string
+	cred                 azcore.TokenCredential
+	client               queryClient
+	workspaceId          string
+	additionalWorkspaces []string
+	cloud                auth.Cloud
 }
 
 func NewWorkspaceClient(workspaceId string, opts ...WsOption) (*WorkspaceClient, error) {
+	wsc, err := newWorkspaceClient(workspaceId, opts)
+	if err != nil {
+		return nil, fmt.Errorf("NewWorkspaceClient: %w", err)
+	}
+
+	if wsc.client == nil {
+		client, err := wsc.newLogsClient()
+		if err != nil {
+			return nil, fmt.Errorf("NewWorkspaceClient: %w", err)
+		}
+		wsc.client = logsQueryClient{client: client}
+	}
+
+	return wsc, nil
+}
+
+// NewResourceQueryClient returns a client running queries against the logs of an Azure resource, e.g. a workspace based
+// Application Insights component, instead of a workspace. The resource id is given in place of the workspace id.
+func NewResourceQueryClient(resourceId string, opts ...WsOption) (*WorkspaceClient, error) {
+	wsc, err := newWorkspaceClient(resourceId, opts)
+	if err != nil {
+		return nil, fmt.Errorf("NewResourceQueryClient: %w", err)
+	}
+
+	if wsc.client == nil {
+		client, err := wsc.newLogsClient()
+		if err != nil {
+			return nil, fmt.Errorf("NewResourceQueryClient: %w", err)
+		}
+		wsc.client = resourceQueryClient{client: client}
+	}
+
+	return wsc, nil
+}
+
+// newWorkspaceClient applies the options, and creates a default credential if neither a credential nor a query client is set.
+func newWorkspaceClient(target string, opts []WsOption) (*WorkspaceClient, error) {
 	wsc := WorkspaceClient{}
 
 	for _, opt := range opts {
 		err := opt(&wsc)
 		if err != nil {
-			return nil, fmt.Errorf("NewWorkspaceClient: failed to apply option: %w", err)
+			return nil, fmt.Errorf("failed to apply option: %w", err)
 		}
 	}
 
 	if wsc.client == nil && wsc.cred == nil {
 		cred, err := azidentity.NewDefaultAzureCredential(nil)
 		if err != nil {
-			return nil, fmt.Errorf("NewWorkspaceClient: failed to create default azure credential: %w", err)
+			return nil, fmt.Errorf("failed to create default azure credential: %w", err)
 		}
 		wsc.cred = cred
 	}
 
-	if wsc.client == nil {
-		client, err := azquery.NewLogsClient(wsc.cred, &azquery.LogsClientOptions{})
-		if err != nil {
-			return nil, fmt.Errorf("NewWorkspaceClient: failed to create default logs client: %w", err)
-		}
-		wsc.client = client
+	wsc.workspaceId = target
+
+	return &wsc, nil
+}
+
+func (wsc *WorkspaceClient) newLogsClient() (*azquery.LogsClient, error) {
+	endpoints, err := wsc.cloud.Endpoints()
+	if err != nil {
+		return nil, err
+	}
+	client, err := azquery.NewLogsClient(wsc.cred, &azquery.LogsClientOptions{ClientOptions: azcore.ClientOptions{Cloud: endpoints.Configuration}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create default logs client: %w", err)
 	}
+	return client, nil
+}
 
-	wsc.workspaceId = workspaceId
+// logsQueryClient runs queries with the logs client. The client decodes numbers as float64, which loses the precision of
+// longs above 2^53, so the rows of the result are decoded again from the response, with numbers as json.Number.
+type logsQueryClient struct {
+	client *azquery.LogsClient
+}
 
-	return &wsc, nil
+func (c logsQueryClient) QueryWorkspace(ctx context.Context, workspaceID string, body azquery.Body, options *azquery.LogsClientQueryWorkspaceOptions) (azquery.LogsClientQueryWorkspaceResponse, error) {
+	var raw *http.Response
+	res, err := c.client.QueryWorkspace(runtime.WithCaptureResponse(ctx, &raw), workspaceID, body, options)
+	if err != nil {
+		return res, err
+	}
+	return res, decodeResponseRows(raw, &res.Results)
+}
+
+// resourceQueryClient adapts the resource-centric queries of the logs client to queryClient. Rows are decoded like in
+// logsQueryClient.
+type resourceQueryClient struct {
+	client *azquery.LogsClient
+}
+
+func (c resourceQueryClient) QueryWorkspace(ctx context.Context, resourceId string, body azquery.Body, options *azquery.LogsClientQueryWorkspaceOptions) (azquery.LogsClientQueryWorkspaceResponse, error) {
+	var resourceOptions *azquery.LogsClientQueryResourceOptions
+	if options != nil {
+		resourceOptions = &azquery.LogsClientQueryResourceOptions{Options: options.Options}
+	}
+	var raw *http.Response
+	res, err := c.client.QueryResource(runtime.WithCaptureResponse(ctx, &raw), resourceId, body, resourceOptions)
+	if err != nil {
+		return azquery.LogsClientQueryWorkspaceResponse{}, err
+	}
+	if err := decodeResponseRows(raw, &res.Results); err != nil {
+		return azquery.LogsClientQueryWorkspaceResponse{}, err
+	}
+	return azquery.LogsClientQueryWorkspaceResponse{Results: res.Results}, nil
+}
+
+// decodeResponseRows replaces the rows of the results with the rows decoded from the captured response.
+func decodeResponseRows(raw *http.Response, results *azquery.Results) error {
+	if raw == nil {
+		return nil
+	}
+	data, err := runtime.Payload(raw)
+	if err != nil {
+		return fmt.Errorf("failed to read response body: %w", err)
+	}
+	return decodeRows(data, results)
+}
+
+// decodeRows replaces the rows of the results with the rows decoded from the given response body, with numbers as
+// json.Number.
+func decodeRows(data []byte, results *azquery.Results) error {
+	var response struct {
+		Tables []struct {
+			Rows []azquery.Row `json:"rows"`
+		} `json:"tables"`
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&response); err != nil {
+		return fmt.Errorf("failed to decode result rows: %w", err)
+	}
+	if len(response.Tables) != len(results.Tables) {
+		return fmt.Errorf("failed to decode result rows: got %d tables, want %d", len(response.Tables), len(results.Tables))
+	}
+	for i, table := range response.Tables {
+		results.Tables[i].Rows = table.Rows
+	}
+	return nil
 }
 
 type WsOption func(client *WorkspaceClient) error
@@ -73,13 +227,80 @@ func WithCredential(cred azcore.TokenCredential) WsOption {
 	}
 }
 
+// WithCloud queries the Log Analytics endpoint of the given cloud. Defaults to the public cloud.
+func WithCloud(cloud auth.Cloud) WsOption {
+	return func(wsc *WorkspaceClient) error {
+		wsc.cloud = cloud
+		return nil
+	}
+}
+
+// WithAdditionalWorkspaces queries the given workspaces in addition to the primary one, as a union of the same query.
+// Ignored for queries whose body already lists additional workspaces.
+func WithAdditionalWorkspaces(workspaceIds ...string) WsOption {
+	return func(wsc *WorkspaceClient) error {
+		wsc.additionalWorkspaces = workspaceIds
+		return nil
+	}
+}
+
+// ParseWorkspaceIDs splits a comma separated list of workspace ids, ignoring whitespace and empty entries.
+func ParseWorkspaceIDs(workspaceIds string) []string {
+	var ids []string
+	for _, id := range strings.Split(workspaceIds, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// WithDimension returns copies of the lines with the given dimension added, e.g. to tell apart the results of separately
+// queried workspaces. The dimension is also added as a column to lines with columns.
+func WithDimension(lines []LogLine, name string, value string) []LogLine {
+	res := make([]LogLine, len(lines))
+	for i, line := range lines {
+		line.Dimensions = append(slices.Clone(line.Dimensions), Dimension{Name: name, Value: value})
+		if line.Columns == nil {
+			res[i] = line
+			continue
+		}
+		columns := make(map[string]any, len(line.Columns)+1)
+		for k, v := range line.Columns {
+			columns[k] = v
+		}
+		columns[name] = value
+		line.Columns = columns
+		res[i] = line
+	}
+	return res
+}
+
+// withWorkspaces sets the additional workspaces of the client on the body, unless it already has some.
+func (wsc *WorkspaceClient) withWorkspaces(body azquery.Body) azquery.Body {
+	if len(body.AdditionalWorkspaces) == 0 && len(wsc.additionalWorkspaces) > 0 {
+		body.AdditionalWorkspaces = to.SliceOfPtrs(wsc.additionalWorkspaces...)
+	}
+	return body
+}
+
 // QueryWorkspaceForAggregateValue queries the workspace with the given body and options and returns the first value of the result.
 // The result is expected to have columns named 'TimeGenerated' and 'MetricValue'. A slice of LogLine is returned, one for each row in the result.
-// If the columns are not found, an error is returned.
+// If the MetricValue column is not found, an error is returned. Any other columns are returned as dimensions of the row.
 func (wsc *WorkspaceClient) QueryWorkspaceForAggregateValue(ctx context.Context, body azquery.Body, options *azquery.LogsClientQueryWorkspaceOptions) ([]LogLine, error) {
-	result, err := wsc.client.QueryWorkspace(ctx, wsc.workspaceId, body, options)
+	return wsc.queryAggregateValue(ctx, body, options, false)
+}
+
+// QueryWorkspaceForAggregateValueWithColumns queries like QueryWorkspaceForAggregateValue, and also returns all columns of
+// each row in LogLine.Columns. Values that can't be converted to the type of their column are returned as is.
+func (wsc *WorkspaceClient) QueryWorkspaceForAggregateValueWithColumns(ctx context.Context, body azquery.Body, options *azquery.LogsClientQueryWorkspaceOptions) ([]LogLine, error) {
+	return wsc.queryAggregateValue(ctx, body, options, true)
+}
+
+func (wsc *WorkspaceClient) queryAggregateValue(ctx context.Context, body azquery.Body, options *azquery.LogsClientQueryWorkspaceOptions, withColumns bool) ([]LogLine, error) {
+	result, err := wsc.client.QueryWorkspace(ctx, wsc.workspaceId, wsc.withWorkspaces(body), options)
 	if err != nil {
-		return []LogLine{}, fmt.Errorf("QueryWorkspaceForAggregateValue: failed to query workspace: %w", err)
+		return []LogLine{}, fmt.Errorf("QueryWorkspaceForAggregateValue: failed to query workspace: %w", wrapError(ErrQueryFailed, err))
 	}
 
 	if result.Error != nil {
@@ -87,67 +308,275 @@ func (wsc *WorkspaceClient) QueryWorkspaceForAggregateValue(ctx context.Context,
 	}
 
 	if len(result.Tables) == 0 || len(result.Tables) > 1 {
-		return []LogLine{}, fmt.Errorf("QueryWorkspaceForAggregateValue: unexpected number of tables found in the result. Expected 1, got %d", len(result.Tables))
+		return []LogLine{}, fmt.Errorf("QueryWorkspaceForAggregateValue: %w: unexpected number of tables found in the result. Expected 1, got %d", ErrQueryFailed, len(result.Tables))
 	}
 
 	if len(result.Tables[0].Columns) == 0 {
-		return []LogLine{}, fmt.Errorf("QueryWorkspaceForAggregateValue: no columns found in the result")
+		return []LogLine{}, fmt.Errorf("QueryWorkspaceForAggregateValue: %w: no columns found in the result", ErrQueryFailed)
 	}
 
-	metricValueIndex := -1
-	timeGeneratedIndex := -1
+	columnIndexes := map[string]int{}
+	var dimensionIndexes []int
 	for i, col := range result.Tables[0].Columns {
-		if *col.Name == "MetricValue" {
-			metricValueIndex = i
-			continue
-		}
-		if *col.Name == "TimeGenerated" {
-			timeGeneratedIndex = i
+		if slices.Contains(reservedColumns, *col.Name) {
+			columnIndexes[*col.Name] = i
 			continue
 		}
+		dimensionIndexes = append(dimensionIndexes, i)
 	}
-	if metricValueIndex == -1 {
+	metricValueIndex, ok := columnIndexes["MetricValue"]
+	if !ok {
 		columnNames := make([]string, len(result.Tables[0].Columns))
 		for i, col := range result.Tables[0].Columns {
 			columnNames[i] = *col.Name
 		}
-		return []LogLine{}, fmt.Errorf("QueryWorkspaceForAggregateValue: 'MetricValue' column not found in the result. Found columns: %v", columnNames)
+		return []LogLine{}, fmt.Errorf("QueryWorkspaceForAggregateValue: %w: 'MetricValue' column not found in the result. Found columns: %v", ErrColumnMissing, columnNames)
 	}
 
-	layout := "2006-01-02T15:04:05Z"
+	var unconverted []string
 	res := make([]LogLine, len(result.Tables[0].Rows))
 	for i, row := range result.Tables[0].Rows {
 		var parsedTime *time.Time
-		if timeGeneratedIndex != -1 {
-			timeGenerated := row[timeGeneratedIndex].(string)
-			pTime, err := time.Parse(layout, timeGenerated)
+		// Rows with an empty TimeGenerated are saved without a time, same as results without the column
+		if timeGeneratedIndex, ok := columnIndexes["TimeGenerated"]; ok && row[timeGeneratedIndex] != nil {
+			timeGenerated, ok := row[timeGeneratedIndex].(string)
+			if !ok {
+				return []LogLine{}, fmt.Errorf("QueryWorkspaceForAggregateValue: TimeGenerated %v is not a datetime", row[timeGeneratedIndex])
+			}
+			pTime, err := time.Parse(time.RFC3339Nano, timeGenerated)
 			if err != nil {
 				return []LogLine{}, fmt.Errorf("QueryWorkspaceForAggregateValue: failed to parse TimeGenerated %v as time: %w", timeGenerated, err)
 			}
 			parsedTime = &pTime
 		}
 
-		metricValue := row[metricValueIndex]
-		switch v := metricValue.(type) {
-		case float64:
-			// Metric value is already a float
-			res[i] = LogLine{parsedTime, v}
-		case float32:
-			// Convert to float64 if it's a float32
-			res[i] = LogLine{parsedTime, float64(v)}
-		case int:
-			// Convert integer to float64
-			res[i] = LogLine{parsedTime, float64(v)}
-		case string:
-			// Try to parse the string as a float
-			value, err := strconv.ParseFloat(v, 64)
+		dimensions := make([]Dimension, len(dimensionIndexes))
+		for j, idx := range dimensionIndexes {
+			dimensions[j] = Dimension{
+				Name:  *result.Tables[0].Columns[idx].Name,
+				Value: formatDimensionValue(row[idx]),
+			}
+		}
+
+		metricValue, err := parseMetricValue(row[metricValueIndex])
+		if err != nil {
+			return []LogLine{}, fmt.Errorf("QueryWorkspaceForAggregateValue: failed to parse MetricValue: %w", err)
+		}
+
+		var columns map[string]any
+		if withColumns {
+			columns = make(map[string]any, len(row))
+			for j, col := range result.Tables[0].Columns {
+				value, err := convertColumnValue(row[j], col.Type)
+				if err != nil {
+					// Warn once per column, the other rows likely fail the same way
+					if !slices.Contains(unconverted, *col.Name) {
+						log.Printf("QueryWorkspaceForAggregateValue: failed to convert column %s, keeping the values as is: %s\n", *col.Name, err)
+						unconverted = append(unconverted, *col.Name)
+					}
+					value = row[j]
+				}
+				columns[*col.Name] = value
+			}
+		}
+
+		line := LogLine{
+			TimeGenerated: parsedTime,
+			MetricValue:   metricValue,
+			Dimensions:    dimensions,
+			Columns:       columns,
+		}
+		for name, target := range map[string]**float64{"MetricMin": &line.MetricMin, "MetricMax": &line.MetricMax, "MetricSum": &line.MetricSum} {
+			*target, err = parseOptionalMetricValue(row, columnIndexes, name)
 			if err != nil {
-				return []LogLine{}, fmt.Errorf("QueryWorkspaceForAggregateValue: failed to parse MetricValue %v as float: %w", metricValue, err)
+				return []LogLine{}, fmt.Errorf("QueryWorkspaceForAggregateValue: failed to parse %s: %w", name, err)
 			}
-			res[i] = LogLine{parsedTime, value}
-		default:
-			return []LogLine{}, fmt.Errorf("QueryWorkspaceForAggregateValue: unexpected MetricValue type %T, value: %v", metricValue, metricValue)
 		}
+		count, err := parseOptionalMetricValue(row, columnIndexes, "MetricCount")
+		if err != nil {
+			return []LogLine{}, fmt.Errorf("QueryWorkspaceForAggregateValue: failed to parse MetricCount: %w", err)
+		}
+		if count != nil {
+			line.MetricCount = to.Ptr(int64(math.Round(*count)))
+		}
+
+		res[i] = line
+	}
+	return res, nil
+}
+
+// QueryResult holds all tables of a query result, with the values converted by column type like in LogLine.Columns.
+type QueryResult struct {
+	Tables []ResultTable
+	// PartialError is set when the query partially failed, in which case the tables may be incomplete.
+	PartialError error
+}
+
+// ResultTable is a single table of a QueryResult.
+type ResultTable struct {
+	Name    string
+	Columns []ResultColumn
+	Rows    [][]any
+}
+
+// ResultColumn is the name and KQL type of a column in a ResultTable.
+type ResultColumn struct {
+	Name string
+	Type string
+}
+
+// Query queries the workspace with the given body and options and returns the whole result, without expecting any particular columns.
+func (wsc *WorkspaceClient) Query(ctx context.Context, body azquery.Body, options *azquery.LogsClientQueryWorkspaceOptions) (QueryResult, error) {
+	result, err := wsc.client.QueryWorkspace(ctx, wsc.workspaceId, wsc.withWorkspaces(body), options)
+	if err != nil {
+		return QueryResult{}, fmt.Errorf("Query: failed to query workspace: %w", wrapError(ErrQueryFailed, err))
+	}
+
+	res := QueryResult{Tables: make([]ResultTable, len(result.Tables))}
+	if result.Error != nil {
+		res.PartialError = result.Error
+	}
+
+	for i, table := range result.Tables {
+		t := ResultTable{
+			Name:    stringValue(table.Name),
+			Columns: make([]ResultColumn, len(table.Columns)),
+			Rows:    make([][]any, len(table.Rows)),
+		}
+		for j, col := range table.Columns {
+			t.Columns[j] = ResultColumn{Name: stringValue(col.Name)}
+			if col.Type != nil {
+				t.Columns[j].Type = string(*col.Type)
+			}
+		}
+		for j, row := range table.Rows {
+			values := make([]any, len(table.Columns))
+			for k, col := range table.Columns {
+				if k >= len(row) {
+					break
+				}
+				value, err := convertColumnValue(row[k], col.Type)
+				if err != nil {
+					return QueryResult{}, fmt.Errorf("Query: failed to convert column %s: %w", t.Columns[k].Name, err)
+				}
+				values[k] = value
+			}
+			t.Rows[j] = values
+		}
+		res.Tables[i] = t
 	}
 	return res, nil
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// parseMetricValue converts a numeric column value of the query result into a float.
+func parseMetricValue(value any) (float64, error) {
+	switch v := value.(type) {
+	case float64:
+		// Metric value is already a float
+		return v, nil
+	case float32:
+		// Convert to float64 if it's a float32
+		return float64(v), nil
+	case int:
+		// Convert integer to float64
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case json.Number:
+		parsed, err := v.Float64()
+		if err != nil {
+			return 0, fmt.Errorf("failed to parse %v as float: %w", value, err)
+		}
+		return parsed, nil
+	case string:
+		// Try to parse the string as a float
+		parsed, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return 0, fmt.Errorf("failed to parse %v as float: %w", value, err)
+		}
+		return parsed, nil
+	default:
+		return 0, fmt.Errorf("unexpected type %T, value: %v", value, value)
+	}
+}
+
+// parseOptionalMetricValue returns the numeric value of the named column in the row, or nil if the column is not in the result or the value is empty.
+func parseOptionalMetricValue(row azquery.Row, columnIndexes map[string]int, name string) (*float64, error) {
+	idx, ok := columnIndexes[name]
+	if !ok || row[idx] == nil {
+		return nil, nil
+	}
+	value, err := parseMetricValue(row[idx])
+	if err != nil {
+		return nil, err
+	}
+	return &value, nil
+}
+
+// convertColumnValue converts a column value of the query result into the Go type matching the column type.
+// Datetimes become time.Time, ints and longs int64, reals and decimals float64. Other values are returned as is.
+// Ints and longs decoded as float64 are only converted if they are exact, since float64 has 53 bits of precision.
+func convertColumnValue(value any, columnType *azquery.LogsColumnType) (any, error) {
+	if value == nil || columnType == nil {
+		return value, nil
+	}
+
+	switch *columnType {
+	case azquery.LogsColumnTypeDatetime:
+		v, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("unexpected datetime type %T, value: %v", value, value)
+		}
+		return time.Parse(time.RFC3339Nano, v)
+	case azquery.LogsColumnTypeInt, azquery.LogsColumnTypeLong:
+		switch v := value.(type) {
+		case json.Number:
+			return v.Int64()
+		case float64:
+			if v != math.Trunc(v) || math.Abs(v) > 1<<53 {
+				return nil, fmt.Errorf("integer value %v is not exact as float", v)
+			}
+			return int64(v), nil
+		case string:
+			return strconv.ParseInt(v, 10, 64)
+		default:
+			return nil, fmt.Errorf("unexpected integer type %T, value: %v", value, value)
+		}
+	case azquery.LogsColumnTypeReal, azquery.LogsColumnTypeDecimal:
+		return parseMetricValue(value)
+	case azquery.LogsColumnTypeBool:
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case string:
+			return strconv.ParseBool(v)
+		default:
+			return nil, fmt.Errorf("unexpected bool type %T, value: %v", value, value)
+		}
+	default:
+		return value, nil
+	}
+}
+
+// formatDimensionValue converts a column value of the query result into a dimension value.
+func formatDimensionValue(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case json.Number:
+		return v.String()
+	default:
+		return fmt.Sprint(v)
+	}
 }
\ No newline at end of file
@@ -0,0 +1,394 @@
+package kql
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/monitor/azquery"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type fakeQueryClient struct {
+	results azquery.Results
+	err     error
+}
+
+func (f fakeQueryClient) QueryWorkspace(_ context.Context, _ string, _ azquery.Body, _ *azquery.LogsClientQueryWorkspaceOptions) (azquery.LogsClientQueryWorkspaceResponse, error) {
+	return azquery.LogsClientQueryWorkspaceResponse{Results: f.results}, f.err
+}
+
+func newFakeResults(columns []string, rows ...azquery.Row) azquery.Results {
+	table := &azquery.Table{Name: to.Ptr("PrimaryResult"), Rows: rows}
+	for _, c := range columns {
+		table.Columns = append(table.Columns, &azquery.Column{Name: to.Ptr(c)})
+	}
+	return azquery.Results{Tables: []*azquery.Table{table}}
+}
+
+func TestQueryWorkspaceForAggregateValue(t *testing.T) {
+	tests := []struct {
+		name    string
+		results azquery.Results
+		want    []LogLine
+		wantErr bool
+	}{
+		{
+			name:    "metric value only",
+			results: newFakeResults([]string{"MetricValue"}, azquery.Row{0.93}),
+			want:    []LogLine{{MetricValue: 0.93, Dimensions: []Dimension{}}},
+		},
+		{
+			name: "dimensions and pre-aggregated values",
+			results: newFakeResults(
+				[]string{"cloud_RoleName", "MetricValue", "MetricMin", "MetricMax", "MetricSum", "MetricCount", "ResultCode"},
+				azquery.Row{"api", 1.5, 0.5, 3.0, 15.0, 10.0, float64(200)},
+			),
+			want: []LogLine{{
+				MetricValue: 1.5,
+				MetricMin:   to.Ptr(0.5),
+				MetricMax:   to.Ptr(3.0),
+				MetricSum:   to.Ptr(15.0),
+				MetricCount: to.Ptr(int64(10)),
+				Dimensions:  []Dimension{{"cloud_RoleName", "api"}, {"ResultCode", "200"}},
+			}},
+		},
+		{
+			name:    "string metric value",
+			results: newFakeResults([]string{"MetricValue"}, azquery.Row{"12.25"}),
+			want:    []LogLine{{MetricValue: 12.25, Dimensions: []Dimension{}}},
+		},
+		{
+			name:    "time generated with fractional seconds",
+			results: newFakeResults([]string{"TimeGenerated", "MetricValue"}, azquery.Row{"2024-09-20T10:00:00.1234567Z", 1.0}),
+			want:    []LogLine{{TimeGenerated: to.Ptr(time.Date(2024, 9, 20, 10, 0, 0, 123456700, time.UTC)), MetricValue: 1.0, Dimensions: []Dimension{}}},
+		},
+		{
+			name:    "null time generated",
+			results: newFakeResults([]string{"TimeGenerated", "MetricValue"}, azquery.Row{nil, 1.0}),
+			want:    []LogLine{{MetricValue: 1.0, Dimensions: []Dimension{}}},
+		},
+		{
+			name:    "invalid time generated",
+			results: newFakeResults([]string{"TimeGenerated", "MetricValue"}, azquery.Row{float64(1), 1.0}),
+			wantErr: true,
+		},
+		{
+			name:    "missing metric value column",
+			results: newFakeResults([]string{"Value"}, azquery.Row{1.0}),
+			wantErr: true,
+		},
+		{
+			name:    "invalid metric value",
+			results: newFakeResults([]string{"MetricValue"}, azquery.Row{"abc"}),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			wsc, err := NewWorkspaceClient("workspace", WithQueryClient(fakeQueryClient{results: tt.results}))
+			if err != nil {
+				t.Fatalf("NewWorkspaceClient() error = %v", err)
+			}
+			got, err := wsc.QueryWorkspaceForAggregateValue(context.Background(), azquery.Body{}, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("QueryWorkspaceForAggregateValue() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("QueryWorkspaceForAggregateValue() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryWorkspaceForAggregateValueWithColumns(t *testing.T) {
+	results := azquery.Results{
+		Tables: []*azquery.Table{{
+			Name: to.Ptr("PrimaryResult"),
+			Columns: []*azquery.Column{
+				{Name: to.Ptr("TimeGenerated"), Type: to.Ptr(azquery.LogsColumnTypeDatetime)},
+				{Name: to.Ptr("Requests"), Type: to.Ptr(azquery.LogsColumnTypeLong)},
+				{Name: to.Ptr("LastSeen"), Type: to.Ptr(azquery.LogsColumnTypeDatetime)},
+				{Name: to.Ptr("MetricValue"), Type: to.Ptr(azquery.LogsColumnTypeReal)},
+			},
+			Rows: []azquery.Row{
+				{"2024-09-20T10:00:00Z", json.Number("9007199254740993"), "2024-09-20T09:00:00Z", json.Number("0.5")},
+				{"2024-09-20T11:00:00Z", json.Number("1"), "yesterday", json.Number("1.5")},
+			},
+		}},
+	}
+	wsc, err := NewWorkspaceClient("workspace", WithQueryClient(fakeQueryClient{results: results}))
+	if err != nil {
+		t.Fatalf("NewWorkspaceClient() error = %v", err)
+	}
+
+	got, err := wsc.QueryWorkspaceForAggregateValueWithColumns(context.Background(), azquery.Body{}, nil)
+	if err != nil {
+		t.Fatalf("QueryWorkspaceForAggregateValueWithColumns() error = %v", err)
+	}
+	first, second := time.Date(2024, 9, 20, 10, 0, 0, 0, time.UTC), time.Date(2024, 9, 20, 11, 0, 0, 0, time.UTC)
+	want := []LogLine{
+		{
+			TimeGenerated: &first,
+			MetricValue:   0.5,
+			Dimensions:    []Dimension{{"Requests", "9007199254740993"}, {"LastSeen", "2024-09-20T09:00:00Z"}},
+			Columns:       map[string]any{"TimeGenerated": first, "Requests": int64(9007199254740993), "LastSeen": time.Date(2024, 9, 20, 9, 0, 0, 0, time.UTC), "MetricValue": 0.5},
+		},
+		{
+			TimeGenerated: &second,
+			MetricValue:   1.5,
+			Dimensions:    []Dimension{{"Requests", "1"}, {"LastSeen", "yesterday"}},
+			// Values that fail to convert are kept as is
+			Columns: map[string]any{"TimeGenerated": second, "Requests": int64(1), "LastSeen": "yesterday", "MetricValue": 1.5},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("QueryWorkspaceForAggregateValueWithColumns() = %+v, want %+v", got, want)
+	}
+}
+
+// newTestLogsClient returns a logs client sending its requests to the given server.
+func newTestLogsClient(t *testing.T, server *httptest.Server) *azquery.LogsClient {
+	t.Helper()
+	configuration := cloud.Configuration{Services: map[cloud.ServiceName]cloud.ServiceConfiguration{
+		azquery.ServiceNameLogs: {Audience: "https://api.loganalytics.io", Endpoint: server.URL},
+	}}
+	client, err := azquery.NewLogsClient(fakeCredential{}, &azquery.LogsClientOptions{ClientOptions: azcore.ClientOptions{Cloud: configuration, Transport: server.Client()}})
+	if err != nil {
+		t.Fatalf("NewLogsClient() error = %v", err)
+	}
+	return client
+}
+
+func TestLogsQueryClient(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/workspaces/ws1/query" {
+			t.Errorf("request path = %s, want /workspaces/ws1/query", r.URL.Path)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"tables":[{"name":"PrimaryResult","columns":[{"name":"Requests","type":"long"},{"name":"MetricValue","type":"real"}],"rows":[[9007199254740993,1.5]]}]}`))
+	}))
+	defer server.Close()
+
+	wsc, err := NewWorkspaceClient("ws1", WithQueryClient(logsQueryClient{client: newTestLogsClient(t, server)}))
+	if err != nil {
+		t.Fatalf("NewWorkspaceClient() error = %v", err)
+	}
+	got, err := wsc.QueryWorkspaceForAggregateValueWithColumns(context.Background(), azquery.Body{Query: to.Ptr("requests")}, nil)
+	if err != nil {
+		t.Fatalf("QueryWorkspaceForAggregateValueWithColumns() error = %v", err)
+	}
+	want := []LogLine{{
+		MetricValue: 1.5,
+		Dimensions:  []Dimension{{"Requests", "9007199254740993"}},
+		Columns:     map[string]any{"Requests": int64(9007199254740993), "MetricValue": 1.5},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("QueryWorkspaceForAggregateValueWithColumns() = %+v, want %+v", got, want)
+	}
+}
+
+func TestResourceQueryClient(t *testing.T) {
+	const resourceId = "/subscriptions/sub/resourceGroups/rg/providers/microsoft.insights/components/app"
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != resourceId+"/query" {
+			t.Errorf("request path = %s, want %s/query", r.URL.Path, resourceId)
+		}
+		if got := r.Header.Get("Prefer"); got != "wait=600" {
+			t.Errorf("Prefer header = %q, want wait=600", got)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"tables":[{"name":"PrimaryResult","columns":[{"name":"Requests","type":"long"},{"name":"MetricValue","type":"real"}],"rows":[[9007199254740993,1.5]]}]}`))
+	}))
+	defer server.Close()
+
+	wsc, err := NewResourceQueryClient(resourceId, WithQueryClient(resourceQueryClient{client: newTestLogsClient(t, server)}))
+	if err != nil {
+		t.Fatalf("NewResourceQueryClient() error = %v", err)
+	}
+	options := &azquery.LogsClientQueryWorkspaceOptions{Options: &azquery.LogsQueryOptions{Wait: to.Ptr(600)}}
+	got, err := wsc.QueryWorkspaceForAggregateValueWithColumns(context.Background(), azquery.Body{Query: to.Ptr("requests")}, options)
+	if err != nil {
+		t.Fatalf("QueryWorkspaceForAggregateValueWithColumns() error = %v", err)
+	}
+	want := []LogLine{{
+		MetricValue: 1.5,
+		Dimensions:  []Dimension{{"Requests", "9007199254740993"}},
+		Columns:     map[string]any{"Requests": int64(9007199254740993), "MetricValue": 1.5},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("QueryWorkspaceForAggregateValueWithColumns() = %+v, want %+v", got, want)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	results := azquery.Results{
+		Tables: []*azquery.Table{{
+			Name: to.Ptr("PrimaryResult"),
+			Columns: []*azquery.Column{
+				{Name: to.Ptr("TimeGenerated"), Type: to.Ptr(azquery.LogsColumnTypeDatetime)},
+				{Name: to.Ptr("Count"), Type: to.Ptr(azquery.LogsColumnTypeLong)},
+			},
+			Rows: []azquery.Row{{"2024-09-20T10:00:00Z", float64(3)}},
+		}},
+		Error: &azquery.ErrorInfo{Code: "PartialError"},
+	}
+	wsc, err := NewWorkspaceClient("workspace", WithQueryClient(fakeQueryClient{results: results}))
+	if err != nil {
+		t.Fatalf("NewWorkspaceClient() error = %v", err)
+	}
+
+	got, err := wsc.Query(context.Background(), azquery.Body{}, nil)
+	if err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+	want := []ResultTable{{
+		Name:    "PrimaryResult",
+		Columns: []ResultColumn{{Name: "TimeGenerated", Type: "datetime"}, {Name: "Count", Type: "long"}},
+		Rows:    [][]any{{time.Date(2024, 9, 20, 10, 0, 0, 0, time.UTC), int64(3)}},
+	}}
+	if !reflect.DeepEqual(got.Tables, want) {
+		t.Errorf("Query() = %+v, want %+v", got.Tables, want)
+	}
+	if got.PartialError == nil {
+		t.Errorf("Query() partial error not set")
+	}
+}
+
+func TestConvertColumnValue(t *testing.T) {
+	tests := []struct {
+		name       string
+		value      any
+		columnType azquery.LogsColumnType
+		want       any
+		wantErr    bool
+	}{
+		{name: "datetime", value: "2024-09-20T10:00:00.123Z", columnType: azquery.LogsColumnTypeDatetime, want: time.Date(2024, 9, 20, 10, 0, 0, 123000000, time.UTC)},
+		{name: "long", value: float64(42), columnType: azquery.LogsColumnTypeLong, want: int64(42)},
+		{name: "long as number", value: json.Number("9007199254740993"), columnType: azquery.LogsColumnTypeLong, want: int64(9007199254740993)},
+		{name: "long beyond float precision", value: float64(1 << 60), columnType: azquery.LogsColumnTypeLong, wantErr: true},
+		{name: "real as number", value: json.Number("0.93"), columnType: azquery.LogsColumnTypeReal, want: 0.93},
+		{name: "real", value: 0.93, columnType: azquery.LogsColumnTypeReal, want: 0.93},
+		{name: "decimal as string", value: "1.5", columnType: azquery.LogsColumnTypeDecimal, want: 1.5},
+		{name: "bool", value: true, columnType: azquery.LogsColumnTypeBool, want: true},
+		{name: "string", value: "api", columnType: azquery.LogsColumnTypeString, want: "api"},
+		{name: "dynamic", value: map[string]any{"a": "b"}, columnType: azquery.LogsColumnTypeDynamic, want: map[string]any{"a": "b"}},
+		{name: "null", value: nil, columnType: azquery.LogsColumnTypeLong, want: nil},
+		{name: "invalid datetime", value: "yesterday", columnType: azquery.LogsColumnTypeDatetime, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := convertColumnValue(tt.value, &tt.columnType)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("convertColumnValue() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("convertColumnValue() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+type recordingQueryClient struct {
+	fakeQueryClient
+	workspaceID string
+	body        azquery.Body
+}
+
+func (r *recordingQueryClient) QueryWorkspace(ctx context.Context, workspaceID string, body azquery.Body, options *azquery.LogsClientQueryWorkspaceOptions) (azquery.LogsClientQueryWorkspaceResponse, error) {
+	r.workspaceID = workspaceID
+	r.body = body
+	return r.fakeQueryClient.QueryWorkspace(ctx, workspaceID, body, options)
+}
+
+func TestWithAdditionalWorkspaces(t *testing.T) {
+	tests := []struct {
+		name       string
+		additional []string
+		body       azquery.Body
+		want       []*string
+	}{
+		{
+			name: "single workspace",
+		},
+		{
+			name:       "additional workspaces",
+			additional: []string{"ws2", "ws3"},
+			want:       to.SliceOfPtrs("ws2", "ws3"),
+		},
+		{
+			name:       "body workspaces are kept",
+			additional: []string{"ws2"},
+			body:       azquery.Body{AdditionalWorkspaces: to.SliceOfPtrs("other")},
+			want:       to.SliceOfPtrs("other"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			client := &recordingQueryClient{fakeQueryClient: fakeQueryClient{results: newFakeResults([]string{"MetricValue"}, azquery.Row{1.0})}}
+			wsc, err := NewWorkspaceClient("ws1", WithQueryClient(client), WithAdditionalWorkspaces(tt.additional...))
+			if err != nil {
+				t.Fatalf("NewWorkspaceClient() error = %v", err)
+			}
+			if _, err := wsc.QueryWorkspaceForAggregateValue(context.Background(), tt.body, nil); err != nil {
+				t.Fatalf("QueryWorkspaceForAggregateValue() error = %v", err)
+			}
+			if client.workspaceID != "ws1" {
+				t.Errorf("QueryWorkspace() workspace = %s, want ws1", client.workspaceID)
+			}
+			if !reflect.DeepEqual(client.body.AdditionalWorkspaces, tt.want) {
+				t.Errorf("QueryWorkspace() additional workspaces = %v, want %v", client.body.AdditionalWorkspaces, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseWorkspaceIDs(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{"ws1", []string{"ws1"}},
+		{"ws1, ws2,,ws3 ", []string{"ws1", "ws2", "ws3"}},
+		{" , ", nil},
+	}
+	for _, tt := range tests {
+		if got := ParseWorkspaceIDs(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseWorkspaceIDs(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestWithDimension(t *testing.T) {
+	lines := []LogLine{{
+		MetricValue: 1,
+		Dimensions:  []Dimension{{"cloud_RoleName", "api"}},
+		Columns:     map[string]any{"cloud_RoleName": "api", "MetricValue": 1.0},
+	}}
+
+	got := WithDimension(lines, WorkspaceIDDimension, "ws1")
+	wantDimensions := []Dimension{{"cloud_RoleName", "api"}, {WorkspaceIDDimension, "ws1"}}
+	if !reflect.DeepEqual(got[0].Dimensions, wantDimensions) {
+		t.Errorf("WithDimension() dimensions = %v, want %v", got[0].Dimensions, wantDimensions)
+	}
+	if got[0].Columns[WorkspaceIDDimension] != "ws1" {
+		t.Errorf("WithDimension() column = %v, want ws1", got[0].Columns[WorkspaceIDDimension])
+	}
+	if len(lines[0].Dimensions) != 1 || len(lines[0].Columns) != 2 {
+		t.Errorf("WithDimension() modified the given lines")
+	}
+
+	got = WithDimension([]LogLine{{MetricValue: 1}}, WorkspaceIDDimension, "ws1")
+	if got[0].Columns != nil {
+		t.Errorf("WithDimension() columns = %v, want none for lines without columns", got[0].Columns)
+	}
+}